@@ -16,14 +16,18 @@ require (
 	github.com/kubedb/apimachinery v0.0.0-20190529152419-7517175ae10f
 	github.com/lib/pq v0.0.0-20190507191818-2ff3cb3adc01
 	github.com/mattn/go-sqlite3 v1.10.0 // indirect
+	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/onsi/ginkgo v1.8.0
 	github.com/onsi/gomega v1.5.0
 	github.com/pkg/errors v0.8.1
+	github.com/prometheus/client_golang v0.9.3
 	github.com/spf13/afero v1.2.2
 	github.com/spf13/cobra v0.0.4
 	github.com/spf13/pflag v1.0.3
 	github.com/ziutek/mymysql v1.5.4 // indirect
+	golang.org/x/crypto v0.0.0-20190513172903-22d7a77e9e5f
 	gomodules.xyz/cert v1.0.0
+	gopkg.in/robfig/cron.v2 v2.0.0-20150107220207-be2e0b0deed5
 	k8s.io/api v0.0.0-20190503110853-61630f889b3c
 	k8s.io/apiextensions-apiserver v0.0.0-20190508224317-421cff06bf05
 	k8s.io/apimachinery v0.0.0-20190508063446-a3da69d3723c
@@ -10,6 +10,8 @@ show_help() {
   echo " "
   echo "postgres-tools.sh COMMAND [options]"
   echo " "
+  echo "commands: backup, restore, precheck, basebackup, restore-basebackup"
+  echo " "
   echo "options:"
   echo "-h, --help                         show brief help"
   echo "    --data-dir=DIR                 path to directory holding db data (default: /var/data)"
@@ -17,6 +19,13 @@ show_help() {
   echo "    --bucket=BUCKET                name of bucket"
   echo "    --folder=FOLDER                name of folder in bucket"
   echo "    --snapshot=SNAPSHOT            name of snapshot"
+  echo "    --format=FORMAT                format of backup, sql (default) or directory"
+  echo "    --jobs=JOBS                    number of parallel jobs of pg_dump and pg_restore in directory format (default 1)"
+  echo "    --databases=LIST               comma separated databases to restore from directory format (default all)"
+  echo "    --schemas=LIST                 comma separated schemas to restore from directory format (default all)"
+  echo "    --tables=LIST                  comma separated tables to restore from directory format (default all)"
+  echo "    --encryption-key=FILE          OpenPGP key to encrypt a backup with"
+  echo "    --decryption-keys=DIR          directory of OpenPGP keys to decrypt a backup with"
   echo "    --enable-analytics=ENABLE_ANALYTICS   send analytical events to Google Analytics (default true)"
 }
 
@@ -31,6 +40,13 @@ DB_BUCKET=${DB_BUCKET:-}
 DB_FOLDER=${DB_FOLDER:-}
 DB_SNAPSHOT=${DB_SNAPSHOT:-}
 DB_DATA_DIR=${DB_DATA_DIR:-/var/data}
+DB_FORMAT=${DB_FORMAT:-sql}
+DB_JOBS=${DB_JOBS:-1}
+DB_DATABASES=${DB_DATABASES:-}
+DB_SCHEMAS=${DB_SCHEMAS:-}
+DB_TABLES=${DB_TABLES:-}
+DB_ENCRYPTION_KEY=${DB_ENCRYPTION_KEY:-}
+DB_DECRYPTION_KEYS=${DB_DECRYPTION_KEYS:-}
 OSM_CONFIG_FILE=/etc/osm/config
 ENABLE_ANALYTICS=${ENABLE_ANALYTICS:-true}
 
@@ -63,6 +79,34 @@ while test $# -gt 0; do
       export DB_SNAPSHOT=$(echo $1 | sed -e 's/^[^=]*=//g')
       shift
       ;;
+    --format*)
+      export DB_FORMAT=$(echo $1 | sed -e 's/^[^=]*=//g')
+      shift
+      ;;
+    --jobs*)
+      export DB_JOBS=$(echo $1 | sed -e 's/^[^=]*=//g')
+      shift
+      ;;
+    --databases*)
+      export DB_DATABASES=$(echo $1 | sed -e 's/^[^=]*=//g')
+      shift
+      ;;
+    --schemas*)
+      export DB_SCHEMAS=$(echo $1 | sed -e 's/^[^=]*=//g')
+      shift
+      ;;
+    --tables*)
+      export DB_TABLES=$(echo $1 | sed -e 's/^[^=]*=//g')
+      shift
+      ;;
+    --encryption-key*)
+      export DB_ENCRYPTION_KEY=$(echo $1 | sed -e 's/^[^=]*=//g')
+      shift
+      ;;
+    --decryption-keys*)
+      export DB_DECRYPTION_KEYS=$(echo $1 | sed -e 's/^[^=]*=//g')
+      shift
+      ;;
     --analytics* | --enable-analytics*)
       export ENABLE_ANALYTICS=$(echo $1 | sed -e 's/^[^=]*=//g')
       shift
@@ -93,6 +137,68 @@ function exit_on_error() {
   exit 1
 }
 
+# encrypt_data encrypts every file of the backup for the OpenPGP key given with --encryption-key.
+function encrypt_data() {
+  if [ -z "$DB_ENCRYPTION_KEY" ]; then
+    return 0
+  fi
+  export GNUPGHOME=$(mktemp -d)
+  gpg --batch --import "$DB_ENCRYPTION_KEY" || exit_on_error "failed to import encryption key"
+  recipient=$(gpg --batch --with-colons --list-keys | awk -F: '/^fpr:/ {print $10; exit}')
+  while read -r file; do
+    gpg --batch --yes --trust-model always --recipient "$recipient" --output "$file.gpg" --encrypt "$file" || exit_on_error "failed to encrypt $file"
+    rm "$file"
+  done < <(find "$DB_DATA_DIR" -type f ! -name '*.gpg')
+}
+
+# decrypt_data decrypts the files of an encrypted backup. All keys found in --decryption-keys are imported,
+# so that backups taken before a key rotation stay restorable.
+function decrypt_data() {
+  if [ -z "$(find "$DB_DATA_DIR" -type f -name '*.gpg')" ]; then
+    return 0
+  fi
+  if [ -z "$DB_DECRYPTION_KEYS" ]; then
+    exit_on_error "backup is encrypted, but no decryption keys are given"
+  fi
+  export GNUPGHOME=$(mktemp -d)
+  for key in "$DB_DECRYPTION_KEYS"/*; do
+    gpg --batch --import "$key" || exit_on_error "failed to import decryption key $key"
+  done
+  while read -r file; do
+    gpg --batch --yes --output "${file%.gpg}" --decrypt "$file" || exit_on_error "failed to decrypt $file"
+    rm "$file"
+  done < <(find "$DB_DATA_DIR" -type f -name '*.gpg')
+}
+
+# precheck runs before the database exists. It verifies access to the archive storage by writing and deleting a probe object.
+if [ "$op" = "precheck" ]; then
+  probe="${DB_FOLDER:+$DB_FOLDER/}kubedb-precheck-$(hostname)"
+  echo "kubedb archive storage precheck" >precheck
+  osm push --enable-analytics="$ENABLE_ANALYTICS" --osmconfig="$OSM_CONFIG_FILE" -c "$DB_BUCKET" precheck "$probe" || exit_on_error "failed to write to bucket $DB_BUCKET"
+  osm rm --enable-analytics="$ENABLE_ANALYTICS" --osmconfig="$OSM_CONFIG_FILE" -c "$DB_BUCKET" "$probe" || exit_on_error "failed to delete from bucket $DB_BUCKET"
+  exit 0
+fi
+
+# restore-basebackup runs in an init container of the database pods. It places the data directory of a
+# physical snapshot into PGDATA, unless the pod already has data.
+if [ "$op" = "restore-basebackup" ]; then
+  if [ -e "$PGDATA/PG_VERSION" ]; then
+    echo "data directory is not empty, skipping restore"
+    exit 0
+  fi
+  osm pull --enable-analytics="$ENABLE_ANALYTICS" --osmconfig="$OSM_CONFIG_FILE" -c "$DB_BUCKET" "$DB_FOLDER/$DB_SNAPSHOT" "$DB_DATA_DIR" || exit_on_error "failed to pull data"
+  decrypt_data
+  mkdir -p "$PGDATA"
+  rm -rf "$PGDATA"/*
+  tar -xzf base.tar.gz -C "$PGDATA" || exit_on_error "failed to extract base backup"
+  # read by the run script of the primary, which replaces the configuration of the source.
+  # The run script of a replica does not read it and takes a base backup of the primary instead.
+  touch "$PGDATA/kubedb-basebackup-restored"
+  chown -R postgres:postgres "$PGDATA"
+  chmod 0700 "$PGDATA"
+  exit 0
+fi
+
 # Wait for postgres to start
 # ref: http://unix.stackexchange.com/a/5279
 while ! nc "$DB_HOST" "$DB_PORT" -w 30 >/dev/null; do
@@ -102,12 +208,57 @@ done
 
 case "$op" in
   backup)
-    PGPASSWORD="$POSTGRES_PASSWORD" pg_dumpall -U "$DB_USER" -h "$DB_HOST" "$@" >dumpfile.sql || exit_on_error "failed to take backup"
+    if [ "$DB_FORMAT" = "directory" ]; then
+      # globals plus a compressed dump per database, which can be restored in parallel and selectively
+      PGPASSWORD="$POSTGRES_PASSWORD" pg_dumpall -U "$DB_USER" -h "$DB_HOST" --globals-only >globals.sql || exit_on_error "failed to take backup of globals"
+      databases=$(PGPASSWORD="$POSTGRES_PASSWORD" psql -U "$DB_USER" -h "$DB_HOST" -At -c "SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate" postgres) || exit_on_error "failed to list databases"
+      mkdir -p databases
+      for db in $databases; do
+        PGPASSWORD="$POSTGRES_PASSWORD" pg_dump -U "$DB_USER" -h "$DB_HOST" -Fd -j "$DB_JOBS" -f "databases/$db" "$@" "$db" || exit_on_error "failed to take backup of database $db"
+      done
+    else
+      PGPASSWORD="$POSTGRES_PASSWORD" pg_dumpall -U "$DB_USER" -h "$DB_HOST" "$@" >dumpfile.sql || exit_on_error "failed to take backup"
+    fi
+    encrypt_data
+    osm push --enable-analytics="$ENABLE_ANALYTICS" --osmconfig="$OSM_CONFIG_FILE" -c "$DB_BUCKET" "$DB_DATA_DIR" "$DB_FOLDER/$DB_SNAPSHOT" || exit_on_error "failed to push data"
+    ;;
+  basebackup)
+    # WAL is fetched into the tar, so that the backup is self-contained and can be taken from a standby
+    PGPASSWORD="$POSTGRES_PASSWORD" pg_basebackup -U "$DB_USER" -h "$DB_HOST" -D "$DB_DATA_DIR" -Ft -z -X fetch --no-password "$@" || exit_on_error "failed to take base backup"
+    encrypt_data
     osm push --enable-analytics="$ENABLE_ANALYTICS" --osmconfig="$OSM_CONFIG_FILE" -c "$DB_BUCKET" "$DB_DATA_DIR" "$DB_FOLDER/$DB_SNAPSHOT" || exit_on_error "failed to push data"
     ;;
   restore)
     osm pull --enable-analytics="$ENABLE_ANALYTICS" --osmconfig="$OSM_CONFIG_FILE" -c "$DB_BUCKET" "$DB_FOLDER/$DB_SNAPSHOT" "$DB_DATA_DIR" || exit_on_error "failed to pull data"
-    PGPASSWORD="$POSTGRES_PASSWORD" psql -U "$DB_USER" -h "$DB_HOST" "$@" -f dumpfile.sql postgres || exit_on_error "failed to restore backup"
+    decrypt_data
+    if [ ! -e globals.sql ]; then
+      if [ -n "$DB_DATABASES$DB_SCHEMAS$DB_TABLES" ]; then
+        exit_on_error "selective restore requires a snapshot in directory format"
+      fi
+      PGPASSWORD="$POSTGRES_PASSWORD" psql -U "$DB_USER" -h "$DB_HOST" "$@" -f dumpfile.sql postgres || exit_on_error "failed to restore backup"
+      exit 0
+    fi
+
+    # roles of the snapshot that exist already are reported and skipped
+    PGPASSWORD="$POSTGRES_PASSWORD" psql -U "$DB_USER" -h "$DB_HOST" -f globals.sql postgres || exit_on_error "failed to restore globals"
+    selection=()
+    for schema in ${DB_SCHEMAS//,/ }; do
+      selection+=(-n "$schema")
+    done
+    for table in ${DB_TABLES//,/ }; do
+      selection+=(-t "$table")
+    done
+    databases=${DB_DATABASES//,/ }
+    if [ -z "$databases" ]; then
+      databases=$(ls databases)
+    fi
+    for db in $databases; do
+      [ -d "databases/$db" ] || exit_on_error "database $db is not in snapshot $DB_SNAPSHOT"
+      if [ -z "$(PGPASSWORD="$POSTGRES_PASSWORD" psql -U "$DB_USER" -h "$DB_HOST" -At -c "SELECT 1 FROM pg_database WHERE datname = '$db'" postgres)" ]; then
+        PGPASSWORD="$POSTGRES_PASSWORD" createdb -U "$DB_USER" -h "$DB_HOST" "$db" || exit_on_error "failed to create database $db"
+      fi
+      PGPASSWORD="$POSTGRES_PASSWORD" pg_restore -U "$DB_USER" -h "$DB_HOST" -d "$db" -j "$DB_JOBS" ${selection[@]+"${selection[@]}"} "$@" "databases/$db" || exit_on_error "failed to restore database $db"
+    done
     ;;
   *)
     (10)
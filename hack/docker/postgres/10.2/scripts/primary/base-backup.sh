@@ -0,0 +1,14 @@
+#!/usr/bin/env bash
+
+# Pushes a base backup of the running primary to the wal archive and prints the name of the new backup.
+# WALG_DELTA_MAX_STEPS > 0 makes wal-g take a delta backup on top of the previous one.
+
+set -eo pipefail
+
+export PGPASSWORD=${POSTGRES_PASSWORD:-postgres}
+
+source /scripts/primary/archive-env.sh
+
+PGUSER="postgres" wal-g backup-push "$PGDATA" 1>&2
+/scripts/primary/retention.sh 1>&2 || echo "Failed to apply archive retention" >&2
+wal-g backup-list 2>/dev/null | tail -n 1 | awk '{print $1}'
@@ -0,0 +1,25 @@
+#!/usr/bin/env bash
+
+# Writes postgresql.conf for a data directory that was copied from another Postgres.
+
+set -eo pipefail
+
+# the postgresql.conf of the source is replaced
+touch /tmp/postgresql.conf
+echo "wal_level = replica" >>/tmp/postgresql.conf
+echo "max_wal_senders = 90" >>/tmp/postgresql.conf # default is 10.  value must be less than max_connections minus superuser_reserved_connections. ref: https://www.postgresql.org/docs/11/runtime-config-replication.html#GUC-MAX-WAL-SENDERS
+echo "wal_keep_segments = 32" >>/tmp/postgresql.conf
+if [ "$STREAMING" == "synchronous" ]; then
+  # setup synchronous streaming replication
+  echo "synchronous_commit = remote_write" >>/tmp/postgresql.conf
+  echo "synchronous_standby_names = '*'" >>/tmp/postgresql.conf
+fi
+
+if [ "$ARCHIVE" == "wal-g" ]; then
+  # setup postgresql.conf
+  echo "archive_command = 'wal-g wal-push %p'" >>/tmp/postgresql.conf
+  echo "archive_timeout = 60" >>/tmp/postgresql.conf
+  echo "archive_mode = always" >>/tmp/postgresql.conf
+fi
+cat /scripts/primary/postgresql.conf >>/tmp/postgresql.conf
+mv /tmp/postgresql.conf "$PGDATA/postgresql.conf"
@@ -0,0 +1,22 @@
+#!/usr/bin/env bash
+
+# Clones the data of another Postgres by streaming a base backup from its primary.
+
+set -eo pipefail
+
+mkdir -p "$PGDATA"
+rm -rf "$PGDATA"/*
+chmod 0700 "$PGDATA"
+
+until pg_isready --host="$CLONE_HOST" --timeout=2 &>/dev/null; do
+  echo "waiting for $CLONE_HOST to clone from..."
+  sleep 5
+done
+
+echo "Cloning from $CLONE_HOST..."
+PGPASSWORD="$CLONE_PASSWORD" pg_basebackup -X stream --no-password --pgdata "$PGDATA" --username="$CLONE_USER" --host="$CLONE_HOST"
+
+# a clone of a standby must not stay in recovery
+rm -f "$PGDATA/recovery.conf"
+
+/scripts/primary/configure.sh
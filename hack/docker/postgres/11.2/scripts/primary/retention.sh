@@ -0,0 +1,42 @@
+#!/usr/bin/env bash
+
+# Deletes old base backups and the WAL they depend on from the wal archive.
+#   ARCHIVE_RETAIN_BASE_BACKUPS     keep this many full base backups
+#   ARCHIVE_RETAIN_WINDOW_SECONDS   keep everything needed to recover to any point within this window
+# When both are set, a backup is only deleted if neither policy needs it.
+
+set -eo pipefail
+
+RETAIN=${ARCHIVE_RETAIN_BASE_BACKUPS:-}
+WINDOW=${ARCHIVE_RETAIN_WINDOW_SECONDS:-}
+
+if [[ -z "$RETAIN" ]] && [[ -z "$WINDOW" ]]; then
+  exit 0
+fi
+
+source /scripts/primary/archive-env.sh
+
+if [[ -z "$WINDOW" ]]; then
+  echo "Retaining $RETAIN full base backups"
+  wal-g delete retain FULL "$RETAIN" --confirm
+  exit 0
+fi
+
+# backup-list prints "name last_modified wal_segment_backup_start", oldest first.
+# Delta backups carry "_D_" in their name.
+CUTOFF=$(date -u -d "@$(($(date +%s) - WINDOW))" +%Y-%m-%dT%H:%M:%SZ)
+TARGET="$CUTOFF"
+if [[ -n "$RETAIN" ]]; then
+  NTH_FULL=$(wal-g backup-list 2>/dev/null | tail -n +2 | grep -v "_D_" | tail -n "$RETAIN" | head -n 1 || true)
+  if [[ -z "$NTH_FULL" ]]; then
+    exit 0
+  fi
+  NTH_NAME=$(echo "$NTH_FULL" | awk '{print $1}')
+  NTH_TIME=$(echo "$NTH_FULL" | awk '{print $2}')
+  if [[ "$NTH_TIME" < "$CUTOFF" ]]; then
+    TARGET="$NTH_NAME"
+  fi
+fi
+
+echo "Deleting base backups and WAL older than $TARGET"
+wal-g delete before FIND_FULL "$TARGET" --confirm
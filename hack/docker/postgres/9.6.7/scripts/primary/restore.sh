@@ -25,6 +25,12 @@ elif [[ ${RESTORE_GS_PREFIX} != "" ]]; then
 
 elif [[ ${RESTORE_FILE_PREFIX} != "" ]]; then
   export WALG_FILE_PREFIX="$RESTORE_FILE_PREFIX"
+  # archives written before the per database directory was introduced are kept in a directory per pod,
+  # the one with the latest base backup is restored
+  if [[ ! -d "$WALG_FILE_PREFIX/basebackups_005" ]] && [[ ${RESTORE_FILE_LEGACY_PREFIX} != "" ]]; then
+    LEGACY_BACKUPS=$(ls -td "$RESTORE_FILE_LEGACY_PREFIX"-*/basebackups_005 2>/dev/null | head -n 1)
+    [[ "$LEGACY_BACKUPS" != "" ]] && export WALG_FILE_PREFIX=$(dirname "$LEGACY_BACKUPS")
+  fi
   mkdir -p $WALG_FILE_PREFIX
 
 elif [[ ${RESTORE_AZ_PREFIX} != "" ]]; then
@@ -58,11 +64,19 @@ elif [[ ${RESTORE_SWIFT_PREFIX} != "" ]]; then
   [[ -e "$CRED_PATH/ST_KEY" ]] && export ST_KEY=$(cat "$CRED_PATH/ST_KEY")
 fi
 
+# the archive is decrypted with all OpenPGP keys of the backup encryption secret, combined into one keyring,
+# as base backups and wal segments taken before a key rotation are encrypted with an older key
+if [[ ${RESTORE_PGP_KEYS_DIR:-} != "" ]]; then
+  pg-operator backup-keyring --keys="$RESTORE_PGP_KEYS_DIR" --out=/tmp/restore-keyring.asc || exit 1
+  export WALG_PGP_KEY_PATH=/tmp/restore-keyring.asc
+fi
+
 PITR=${PITR:-false}
 TARGET_INCLUSIVE=${TARGET_INCLUSIVE:-true}
 TARGET_TIME=${TARGET_TIME:-}
 TARGET_TIMELINE=${TARGET_TIMELINE:-}
 TARGET_XID=${TARGET_XID:-}
+TARGET_NAME=${TARGET_NAME:-}
 
 until wal-g backup-list &>/dev/null; do
   echo "waiting for archived backup..."
@@ -94,6 +108,9 @@ if [ "$PITR" = true ]; then
   if [ ! -z "$TARGET_XID" ]; then
     echo "recovery_target_xid = '$TARGET_XID'" >>/tmp/recovery.conf
   fi
+  if [ ! -z "$TARGET_NAME" ]; then
+    echo "recovery_target_name = '$TARGET_NAME'" >>/tmp/recovery.conf
+  fi
 fi
 
 echo "restore_command = 'wal-g wal-fetch %f %p'" >>/tmp/recovery.conf
@@ -0,0 +1,151 @@
+package admission
+
+import (
+	"testing"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	le "github.com/kubedb/postgres/pkg/leader_election"
+	store "kmodules.xyz/objectstore-api/api/v1"
+)
+
+// withBackups adds a backup schedule and a wal archive to a Postgres, as the backup annotations require them.
+func withBackups(postgres api.Postgres, schedule, archiver bool) api.Postgres {
+	if schedule {
+		postgres.Spec.BackupSchedule = &api.BackupScheduleSpec{
+			CronExpression: "@every 6h",
+			Backend: store.Backend{
+				StorageSecretName: "foo-s3",
+				S3:                &store.S3Spec{Bucket: "kubedb"},
+			},
+		}
+	}
+	if archiver {
+		postgres = archiverSecret(postgres, "foo-s3")
+	}
+	return postgres
+}
+
+func TestValidateNotificationWebhooks(t *testing.T) {
+	for _, c := range []struct {
+		webhooks string
+		valid    bool
+	}{
+		{"", true},
+		{"https://hooks.example.com/failover", true},
+		{"https://hooks.example.com/failover, http://alerts.example.com:8080/pg,", true},
+		{"hooks.example.com", false},
+		{"ftp://hooks.example.com", false},
+		{"https://", false},
+	} {
+		postgres := withAnnotations(samplePostgres(), map[string]string{le.NotificationWebhooksAnnotation: c.webhooks})
+		if err := validateNotificationWebhooks(&postgres); (err == nil) != c.valid {
+			t.Errorf("webhooks %q: expected valid=%v, got error: %v", c.webhooks, c.valid, err)
+		}
+	}
+}
+
+func TestValidateBackupDrill(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		annotations map[string]string
+		schedule    bool
+		archiver    bool
+		valid       bool
+	}{
+		{"no drill", nil, false, false, true},
+		{"snapshot drill", map[string]string{BackupDrillAnnotation: BackupDrillSnapshot}, true, false, true},
+		{"archive drill", map[string]string{BackupDrillAnnotation: BackupDrillArchive}, false, true, true},
+		{"drill with assertions and timeout", map[string]string{
+			BackupDrillAnnotation:           BackupDrillArchive,
+			BackupDrillAssertionsAnnotation: "foo-assertions",
+			BackupDrillTimeoutAnnotation:    "90m",
+		}, false, true, true},
+		{"snapshot drill without schedule", map[string]string{BackupDrillAnnotation: BackupDrillSnapshot}, false, true, false},
+		{"archive drill without archiver", map[string]string{BackupDrillAnnotation: BackupDrillArchive}, true, false, false},
+		{"unknown drill", map[string]string{BackupDrillAnnotation: "stash"}, true, true, false},
+		{"invalid timeout", map[string]string{BackupDrillAnnotation: BackupDrillArchive, BackupDrillTimeoutAnnotation: "1d"}, false, true, false},
+		{"negative timeout", map[string]string{BackupDrillAnnotation: BackupDrillArchive, BackupDrillTimeoutAnnotation: "-1h"}, false, true, false},
+		{"assertions without drill", map[string]string{BackupDrillAssertionsAnnotation: "foo-assertions"}, true, true, false},
+		{"timeout without drill", map[string]string{BackupDrillTimeoutAnnotation: "2h"}, true, true, false},
+	} {
+		postgres := withAnnotations(withBackups(samplePostgres(), c.schedule, c.archiver), c.annotations)
+		if err := validateBackupDrill(&postgres); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid=%v, got error: %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestValidateBackupRPO(t *testing.T) {
+	for _, c := range []struct {
+		rpo   string
+		valid bool
+	}{
+		{"24h", true},
+		{"90m", true},
+		{"1h30m", true},
+		{"1d", false},
+		{"0s", false},
+		{"-1h", false},
+		{"", false},
+	} {
+		postgres := withAnnotations(samplePostgres(), map[string]string{BackupRPOAnnotation: c.rpo})
+		if err := validateBackupRPO(&postgres); (err == nil) != c.valid {
+			t.Errorf("rpo %q: expected valid=%v, got error: %v", c.rpo, c.valid, err)
+		}
+	}
+
+	postgres := samplePostgres()
+	if err := validateBackupRPO(&postgres); err != nil {
+		t.Errorf("no rpo: expected valid, got error: %v", err)
+	}
+}
+
+func TestValidateStashBackup(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		annotations map[string]string
+		schedule    bool
+		valid       bool
+	}{
+		{"no stash backup", nil, false, true},
+		{"stash backup", map[string]string{StashBackupAnnotation: "true"}, true, true},
+		{"stash backup with task", map[string]string{StashBackupAnnotation: "true", StashBackupTaskAnnotation: "pg-backup-11.2"}, true, true},
+		{"disabled stash backup", map[string]string{StashBackupAnnotation: "false"}, false, true},
+		{"stash backup with archive drill", map[string]string{StashBackupAnnotation: "true", BackupDrillAnnotation: BackupDrillArchive}, true, true},
+		{"stash backup without schedule", map[string]string{StashBackupAnnotation: "true"}, false, false},
+		{"invalid value", map[string]string{StashBackupAnnotation: "yes please"}, true, false},
+		{"task without stash backup", map[string]string{StashBackupTaskAnnotation: "pg-backup-11.2"}, true, false},
+		{"stash backup with retain window", map[string]string{StashBackupAnnotation: "true", SnapshotRetainWindowAnnotation: "168h"}, true, false},
+		{"stash backup with snapshot drill", map[string]string{StashBackupAnnotation: "true", BackupDrillAnnotation: BackupDrillSnapshot}, true, false},
+	} {
+		postgres := withAnnotations(withBackups(samplePostgres(), c.schedule, false), c.annotations)
+		if err := validateStashBackup(&postgres); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid=%v, got error: %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestValidateInitVolumeSnapshot(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		annotations map[string]string
+		storageType api.StorageType
+		init        *api.InitSpec
+		valid       bool
+	}{
+		{"no init volume snapshot", nil, api.StorageTypeEphemeral, nil, true},
+		{"init volume snapshot", map[string]string{InitVolumeSnapshotAnnotation: "foo-snapshot"}, api.StorageTypeDurable, nil, true},
+		{"init volume snapshot with script", map[string]string{InitVolumeSnapshotAnnotation: "foo-snapshot"}, api.StorageTypeDurable, &api.InitSpec{ScriptSource: &api.ScriptSourceSpec{}}, true},
+		{"ephemeral storage", map[string]string{InitVolumeSnapshotAnnotation: "foo-snapshot"}, api.StorageTypeEphemeral, nil, false},
+		{"with snapshot init", map[string]string{InitVolumeSnapshotAnnotation: "foo-snapshot"}, api.StorageTypeDurable, &api.InitSpec{SnapshotSource: &api.SnapshotSourceSpec{Name: "snap"}}, false},
+		{"with wal init", map[string]string{InitVolumeSnapshotAnnotation: "foo-snapshot"}, api.StorageTypeDurable, &api.InitSpec{PostgresWAL: &api.PostgresWALSourceSpec{}}, false},
+		{"with clone", map[string]string{InitVolumeSnapshotAnnotation: "foo-snapshot", CloneFromAnnotation: "bar"}, api.StorageTypeDurable, nil, false},
+	} {
+		postgres := withAnnotations(samplePostgres(), c.annotations)
+		postgres.Spec.StorageType = c.storageType
+		postgres.Spec.Init = c.init
+		if err := validateInitVolumeSnapshot(&postgres); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid=%v, got error: %v", c.name, c.valid, err)
+		}
+	}
+}
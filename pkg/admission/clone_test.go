@@ -0,0 +1,152 @@
+package admission
+
+import (
+	"testing"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	extFake "github.com/kubedb/apimachinery/client/clientset/versioned/fake"
+	authenticationV1 "k8s.io/api/authentication/v1"
+	authorization "k8s.io/api/authorization/v1"
+	core "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clientTesting "k8s.io/client-go/testing"
+	store "kmodules.xyz/objectstore-api/api/v1"
+)
+
+// reviewAccess answers the SubjectAccessReviews of a fake client with allowed.
+func reviewAccess(client *fake.Clientset, allowed func(attributes *authorization.ResourceAttributes) bool) {
+	client.PrependReactor("create", "subjectaccessreviews", func(action clientTesting.Action) (bool, runtime.Object, error) {
+		review := action.(clientTesting.CreateAction).GetObject().(*authorization.SubjectAccessReview)
+		review.Status.Allowed = allowed(review.Spec.ResourceAttributes)
+		return true, review, nil
+	})
+}
+
+func clonedPostgres(namespace, name string, archiver *store.Backend) *api.Postgres {
+	postgres := &api.Postgres{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: api.PostgresSpec{
+			DatabaseSecret: &core.SecretVolumeSource{
+				SecretName: name + "-auth",
+			},
+		},
+	}
+	if archiver != nil {
+		postgres.Spec.Archiver = &api.PostgresArchiverSpec{Storage: archiver}
+	}
+	return postgres
+}
+
+func TestValidateCloneFrom(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		annotations map[string]string
+		init        *api.InitSpec
+		valid       bool
+	}{
+		{"no clone", nil, nil, true},
+		{"same namespace", map[string]string{CloneFromAnnotation: "bar"}, nil, true},
+		{"other namespace", map[string]string{CloneFromAnnotation: "demo/bar"}, nil, true},
+		{"target time", map[string]string{CloneFromAnnotation: "bar", CloneTargetTimeAnnotation: "2019-05-06T10:00:00Z"}, nil, true},
+		{"script init", map[string]string{CloneFromAnnotation: "bar"}, &api.InitSpec{ScriptSource: &api.ScriptSourceSpec{}}, true},
+		{"itself", map[string]string{CloneFromAnnotation: "default/foo"}, nil, false},
+		{"missing name", map[string]string{CloneFromAnnotation: "demo/"}, nil, false},
+		{"empty", map[string]string{CloneFromAnnotation: ""}, nil, false},
+		{"target time without source", map[string]string{CloneTargetTimeAnnotation: "2019-05-06T10:00:00Z"}, nil, false},
+		{"invalid target time", map[string]string{CloneFromAnnotation: "bar", CloneTargetTimeAnnotation: "yesterday"}, nil, false},
+		{"snapshot init", map[string]string{CloneFromAnnotation: "bar"}, &api.InitSpec{SnapshotSource: &api.SnapshotSourceSpec{Name: "snap"}}, false},
+	} {
+		postgres := samplePostgres()
+		postgres.Annotations = c.annotations
+		postgres.Spec.Init = c.init
+		if err := validateCloneFrom(&postgres); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid=%v, got error: %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestValidateCloneUnchanged(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		old   string
+		new   string
+		valid bool
+	}{
+		{"unchanged", "demo/bar", "demo/bar", true},
+		{"removed", "demo/bar", "", true},
+		{"added", "", "demo/bar", false},
+		{"changed", "demo/bar", "demo/baz", false},
+	} {
+		oldPostgres, postgres := samplePostgres(), samplePostgres()
+		if c.old != "" {
+			oldPostgres = cloneFrom(oldPostgres, c.old)
+		}
+		if c.new != "" {
+			postgres = cloneFrom(postgres, c.new)
+		}
+		if err := validateCloneUnchanged(&postgres, &oldPostgres); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid=%v, got error: %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestValidateCloneSource(t *testing.T) {
+	s3 := &store.Backend{
+		StorageSecretName: "bar-storage",
+		S3:                &store.S3Spec{Bucket: "kubedb"},
+	}
+	local := &store.Backend{
+		Local: &store.LocalSpec{MountPath: "/var/pv"},
+	}
+
+	for _, c := range []struct {
+		name       string
+		from       string
+		targetTime string
+		allowed    []string
+		valid      bool
+	}{
+		{"same namespace without access review", "archived-local", "", nil, true},
+		{"same namespace point in time", "archived-local", "2019-05-06T10:00:00Z", nil, true},
+		{"same namespace point in time without archive", "streamed", "2019-05-06T10:00:00Z", nil, false},
+		{"other namespace allowed", "demo/archived", "", []string{"postgreses", "secrets"}, true},
+		{"other namespace streamed allowed", "demo/streamed", "", []string{"postgreses", "secrets"}, true},
+		{"other namespace denied", "demo/archived", "", nil, false},
+		{"other namespace without access to secret", "demo/archived", "", []string{"postgreses"}, false},
+		{"other namespace local archive", "demo/archived-local", "", []string{"postgreses", "secrets"}, false},
+		{"not found", "demo/missing", "", []string{"postgreses", "secrets"}, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			reviewAccess(client, func(attributes *authorization.ResourceAttributes) bool {
+				for _, resource := range c.allowed {
+					if attributes.Resource == resource {
+						return true
+					}
+				}
+				return false
+			})
+			extClient := extFake.NewSimpleClientset(
+				clonedPostgres("demo", "archived", s3),
+				clonedPostgres("demo", "archived-local", local),
+				clonedPostgres("demo", "streamed", nil),
+				clonedPostgres("default", "archived-local", local),
+				clonedPostgres("default", "streamed", nil),
+			)
+
+			postgres := cloneFrom(samplePostgres(), c.from)
+			if c.targetTime != "" {
+				postgres.Annotations[CloneTargetTimeAnnotation] = c.targetTime
+			}
+			err := validateCloneSource(client, extClient, &postgres, authenticationV1.UserInfo{Username: "alice"})
+			if (err == nil) != c.valid {
+				t.Errorf("expected valid=%v, got error: %v", c.valid, err)
+			}
+		})
+	}
+}
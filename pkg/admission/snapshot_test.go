@@ -0,0 +1,89 @@
+package admission
+
+import (
+	"testing"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	extFake "github.com/kubedb/apimachinery/client/clientset/versioned/fake"
+	authenticationV1 "k8s.io/api/authentication/v1"
+	authorization "k8s.io/api/authorization/v1"
+	core "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	store "kmodules.xyz/objectstore-api/api/v1"
+)
+
+func restoreNamespace(name, allowed string) *core.Namespace {
+	ns := &core.Namespace{ObjectMeta: metaV1.ObjectMeta{Name: name}}
+	if allowed != "" {
+		ns.Annotations = map[string]string{SnapshotRestoreNamespacesAnnotation: allowed}
+	}
+	return ns
+}
+
+func sourceSnapshot(namespace, name, storageSecretName string) *api.Snapshot {
+	return &api.Snapshot{
+		ObjectMeta: metaV1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: api.SnapshotSpec{
+			DatabaseName: "bar",
+			Backend: store.Backend{
+				StorageSecretName: storageSecretName,
+				S3:                &store.S3Spec{Bucket: "kubedb"},
+			},
+		},
+	}
+}
+
+func TestValidateSnapshotSourceAccess(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		namespace string
+		snapshot  string
+		allowed   []string
+		valid     bool
+	}{
+		{"same namespace without access review", "", "snap", nil, true},
+		{"no allow-list", "demo", "snap", []string{"snapshots", "secrets"}, true},
+		{"allow-list hit", "prod", "snap", []string{"snapshots", "secrets"}, true},
+		{"allow-list wildcard", "shared", "snap", []string{"snapshots", "secrets"}, true},
+		{"allow-list miss", "locked", "snap", []string{"snapshots", "secrets"}, false},
+		{"snapshot without storage secret", "demo", "local", []string{"snapshots"}, true},
+		{"access to snapshot denied", "demo", "snap", []string{"secrets"}, false},
+		{"access to storage secret denied", "demo", "snap", []string{"snapshots"}, false},
+		{"snapshot not found", "demo", "missing", []string{"snapshots", "secrets"}, false},
+		{"namespace not found", "missing", "snap", []string{"snapshots", "secrets"}, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(
+				restoreNamespace("demo", ""),
+				restoreNamespace("prod", "staging, default"),
+				restoreNamespace("shared", "*"),
+				restoreNamespace("locked", "staging"),
+			)
+			reviewAccess(client, func(attributes *authorization.ResourceAttributes) bool {
+				for _, resource := range c.allowed {
+					if attributes.Resource == resource {
+						return true
+					}
+				}
+				return false
+			})
+			extClient := extFake.NewSimpleClientset(
+				sourceSnapshot("demo", "snap", "snap-storage"),
+				sourceSnapshot("demo", "local", ""),
+				sourceSnapshot("prod", "snap", "snap-storage"),
+				sourceSnapshot("shared", "snap", "snap-storage"),
+				sourceSnapshot("locked", "snap", "snap-storage"),
+			)
+
+			postgres := samplePostgres()
+			postgres.Spec.Init = &api.InitSpec{
+				SnapshotSource: &api.SnapshotSourceSpec{Namespace: c.namespace, Name: c.snapshot},
+			}
+			err := validateSnapshotSourceAccess(client, extClient, &postgres, authenticationV1.UserInfo{Username: "alice"})
+			if (err == nil) != c.valid {
+				t.Errorf("expected valid=%v, got error: %v", c.valid, err)
+			}
+		})
+	}
+}
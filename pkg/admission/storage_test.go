@@ -0,0 +1,50 @@
+package admission
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	store "kmodules.xyz/objectstore-api/api/v1"
+)
+
+func TestValidateWALStorageSecret(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		backend store.Backend
+		data    []string
+		valid   bool
+	}{
+		{"local", store.Backend{Local: &store.LocalSpec{MountPath: "/var/pv"}}, nil, true},
+		{"s3 with node role", store.Backend{S3: &store.S3Spec{Bucket: "kubedb"}}, nil, true},
+		{"s3", store.Backend{S3: &store.S3Spec{Bucket: "kubedb"}}, []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"}, true},
+		{"s3 without secret key", store.Backend{S3: &store.S3Spec{Bucket: "kubedb"}}, []string{"AWS_ACCESS_KEY_ID"}, false},
+		{"gcs with json key", store.Backend{GCS: &store.GCSSpec{Bucket: "kubedb"}}, []string{"GOOGLE_SERVICE_ACCOUNT_JSON_KEY"}, true},
+		{"gcs without credentials", store.Backend{GCS: &store.GCSSpec{Bucket: "kubedb"}}, []string{"GOOGLE_PROJECT_ID"}, false},
+		{"azure with account name", store.Backend{Azure: &store.AzureSpec{Container: "kubedb"}}, []string{"AZURE_ACCOUNT_NAME", "AZURE_STORAGE_ACCESS_KEY"}, true},
+		{"azure without key", store.Backend{Azure: &store.AzureSpec{Container: "kubedb"}}, []string{"AZURE_STORAGE_ACCOUNT"}, false},
+		{"azure without secret", store.Backend{Azure: &store.AzureSpec{Container: "kubedb"}}, nil, false},
+		{"swift with token", store.Backend{Swift: &store.SwiftSpec{Container: "kubedb"}}, []string{"OS_STORAGE_URL", "OS_AUTH_TOKEN"}, true},
+		{"swift with partial auth", store.Backend{Swift: &store.SwiftSpec{Container: "kubedb"}}, []string{"OS_AUTH_URL", "OS_USERNAME"}, false},
+		{"no provider", store.Backend{}, nil, false},
+	} {
+		secret := &core.Secret{
+			ObjectMeta: metaV1.ObjectMeta{
+				Name:      "foo-storage",
+				Namespace: "default",
+			},
+			Data: map[string][]byte{},
+		}
+		for _, key := range c.data {
+			secret.Data[key] = []byte("value")
+		}
+		if c.data != nil {
+			c.backend.StorageSecretName = secret.Name
+		}
+		err := validateWALStorageSecret(fake.NewSimpleClientset(secret), c.backend, "default", "spec.archiver.storage")
+		if (err == nil) != c.valid {
+			t.Errorf("%s: expected valid=%v, got error: %v", c.name, c.valid, err)
+		}
+	}
+}
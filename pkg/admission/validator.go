@@ -2,16 +2,26 @@ package admission
 
 import (
 	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/appscode/go/log"
+	catalog "github.com/kubedb/apimachinery/apis/catalog/v1alpha1"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	cs "github.com/kubedb/apimachinery/client/clientset/versioned"
 	amv "github.com/kubedb/apimachinery/pkg/validator"
+	"github.com/kubedb/postgres/pkg/archive"
+	le "github.com/kubedb/postgres/pkg/leader_election"
 	"github.com/pkg/errors"
+	cron "gopkg.in/robfig/cron.v2"
 	admission "k8s.io/api/admission/v1beta1"
+	authentication "k8s.io/api/authentication/v1"
+	authorization "k8s.io/api/authorization/v1"
+	core "k8s.io/api/core/v1"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -21,6 +31,7 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/leaderelection"
 	meta_util "kmodules.xyz/client-go/meta"
+	store "kmodules.xyz/objectstore-api/api/v1"
 	hookapi "kmodules.xyz/webhook-runtime/admission/v1beta1"
 )
 
@@ -33,6 +44,78 @@ type PostgresValidator struct {
 
 var _ hookapi.AdmissionHook = &PostgresValidator{}
 
+const (
+	// Number of succeeded scheduled Snapshots to keep.
+	SnapshotRetainAnnotation = api.PostgresKey + "/snapshot-retain"
+	// Scheduled Snapshots younger than this duration are kept, regardless of SnapshotRetainAnnotation.
+	SnapshotRetainWindowAnnotation = api.PostgresKey + "/snapshot-retain-window"
+
+	// Postgres to clone a new Postgres from, as "name" or "namespace/name". The clone is restored
+	// from the wal archive of the source, or streamed from its primary if it has no archive.
+	CloneFromAnnotation = api.PostgresKey + "/clone-from"
+	// Point in time to clone to. Requires a wal archive. Latest, if not set.
+	CloneTargetTimeAnnotation = api.PostgresKey + "/clone-target-time"
+
+	// Databases, schemas and tables to restore from a Snapshot in directory format, comma separated.
+	// Everything is restored, if not set.
+	RestoreDatabasesAnnotation = api.PostgresKey + "/restore-databases"
+	RestoreSchemasAnnotation   = api.PostgresKey + "/restore-schemas"
+	RestoreTablesAnnotation    = api.PostgresKey + "/restore-tables"
+	// Number of parallel pg_restore jobs.
+	RestoreJobsAnnotation = api.PostgresKey + "/restore-jobs"
+
+	// Secret with armored OpenPGP keys without passphrase. Snapshots and the wal archive are encrypted
+	// before they are uploaded. On rotation, older keys are kept in the Secret to restore older backups.
+	BackupEncryptionSecretAnnotation = api.PostgresKey + "/backup-encryption-secret"
+	// Key in the Secret that new backups are encrypted with. DefaultBackupEncryptionKey, if not set.
+	BackupEncryptionKeyAnnotation = api.PostgresKey + "/backup-encryption-key"
+	// Key in the Secret that the wal archive of spec.init.postgresWAL was encrypted with, the encryption
+	// key if not set. The wal init is decrypted with all keys of the Secret.
+	RestoreEncryptionKeyAnnotation = api.PostgresKey + "/restore-encryption-key"
+
+	DefaultBackupEncryptionKey = "key.asc"
+
+	// Restore verification of backups in a throwaway Postgres, BackupDrillSnapshot or BackupDrillArchive.
+	// Snapshot drills verify the latest scheduled Snapshot once it succeeds. Archive drills verify
+	// every new wal-g base backup together with the WAL archived after it.
+	BackupDrillAnnotation = api.PostgresKey + "/backup-drill"
+	// ConfigMap with SQL assertions of a drill. Every key "<name>.sql" or "<database>.<name>.sql" holds
+	// a query that must return true. Queries without a database run in database "postgres".
+	BackupDrillAssertionsAnnotation = api.PostgresKey + "/backup-drill-assertions"
+	// A drill fails, if the restored Postgres is not verified within this duration. One hour, if not set.
+	BackupDrillTimeoutAnnotation = api.PostgresKey + "/backup-drill-timeout"
+
+	BackupDrillSnapshot = "snapshot"
+	BackupDrillArchive  = "archive"
+
+	// Recovery point objective. Backups are stale, if the newest succeeded Snapshot, base backup or
+	// archived WAL segment is older than this duration.
+	BackupRPOAnnotation = api.PostgresKey + "/backup-rpo"
+
+	// Back up with Stash instead of Snapshots, if "true". The operator generates a Stash Repository from
+	// the backend of spec.backupSchedule and a BackupConfiguration with its schedule, targeting the AppBinding
+	// of the Postgres. The number of backups to keep is taken from SnapshotRetainAnnotation.
+	StashBackupAnnotation = api.PostgresKey + "/stash-backup"
+	// Stash Task that backs up the Postgres, "postgres-backup-<version>" if not set.
+	StashBackupTaskAnnotation = api.PostgresKey + "/stash-backup-task"
+
+	// Secret with a random "key" that the copy of the auth Secret in the backup metadata is encrypted with.
+	// Without it, only the name and the keys of the auth Secret are stored with backups.
+	BackupMetadataKeyAnnotation = api.PostgresKey + "/backup-metadata-key"
+	BackupMetadataKey           = "key"
+
+	// CSI VolumeSnapshot in the namespace of a new Postgres to provision its data volumes from.
+	// Volume Snapshots in spec.init.snapshotSource are restored the same way.
+	InitVolumeSnapshotAnnotation = api.PostgresKey + "/init-volume-snapshot"
+
+	// Namespaces allowed to restore the Snapshots of a namespace, comma separated, or "*" for all.
+	// Set on the source namespace. Any namespace may restore its Snapshots, if not set.
+	SnapshotRestoreNamespacesAnnotation = api.PostgresKey + "/snapshot-restore-namespaces"
+)
+
+// archiveListTimeout bounds the time spent listing a wal archive during an admission request.
+const archiveListTimeout = 10 * time.Second
+
 var forbiddenEnvVars = []string{
 	"POSTGRES_PASSWORD",
 	"POSTGRES_USER",
@@ -114,11 +197,31 @@ func (a *PostgresValidator) Admit(req *admission.AdmissionRequest) *admission.Ad
 			if err := validateUpdate(postgres, oldPostgres, req.Kind.Kind); err != nil {
 				return hookapi.StatusBadRequest(fmt.Errorf("%v", err))
 			}
+			if err := validateCloneUnchanged(postgres, oldPostgres); err != nil {
+				return hookapi.StatusBadRequest(err)
+			}
 		}
 		// validate database specs
 		if err = ValidatePostgres(a.client, a.extClient, obj.(*api.Postgres), false); err != nil {
 			return hookapi.StatusForbidden(err)
 		}
+		if req.Operation == admission.Create {
+			if err = validateWALStorageSecrets(a.client, obj.(*api.Postgres)); err != nil {
+				return hookapi.StatusForbidden(err)
+			}
+			if err = validateBackupEncryption(a.client, obj.(*api.Postgres)); err != nil {
+				return hookapi.StatusForbidden(err)
+			}
+			if err = validateRecoveryTarget(a.client, obj.(*api.Postgres)); err != nil {
+				return hookapi.StatusForbidden(err)
+			}
+			if err = validateCloneSource(a.client, a.extClient, obj.(*api.Postgres), req.UserInfo); err != nil {
+				return hookapi.StatusForbidden(err)
+			}
+			if err = validateSnapshotSourceAccess(a.client, a.extClient, obj.(*api.Postgres), req.UserInfo); err != nil {
+				return hookapi.StatusForbidden(err)
+			}
+		}
 	}
 	status.Allowed = true
 	return status
@@ -130,7 +233,8 @@ func ValidatePostgres(client kubernetes.Interface, extClient cs.Interface, postg
 	if postgres.Spec.Version == "" {
 		return errors.New(`'spec.version' is missing`)
 	}
-	if _, err := extClient.CatalogV1alpha1().PostgresVersions().Get(string(postgres.Spec.Version), metav1.GetOptions{}); err != nil {
+	postgresVersion, err := extClient.CatalogV1alpha1().PostgresVersions().Get(string(postgres.Spec.Version), metav1.GetOptions{})
+	if err != nil {
 		return err
 	}
 
@@ -189,6 +293,24 @@ func ValidatePostgres(client kubernetes.Interface, extClient cs.Interface, postg
 			}
 		}
 
+		if err := validateWALStorageSecrets(client, postgres); err != nil {
+			return err
+		}
+
+		if err := validateBackupEncryption(client, postgres); err != nil {
+			return err
+		}
+
+		if secretName := postgres.Annotations[BackupMetadataKeyAnnotation]; secretName != "" {
+			secret, err := client.CoreV1().Secrets(postgres.Namespace).Get(secretName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if !hasKeys(secret, BackupMetadataKey) {
+				return fmt.Errorf(`backup metadata secret "%v" has no key "%v"`, secretName, BackupMetadataKey)
+			}
+		}
+
 		// Check if postgresVersion is deprecated.
 		// If deprecated, return error
 		postgresVersion, err := extClient.CatalogV1alpha1().PostgresVersions().Get(string(postgres.Spec.Version), metav1.GetOptions{})
@@ -263,12 +385,684 @@ func ValidatePostgres(client kubernetes.Interface, extClient cs.Interface, postg
 		}
 	}
 
+	if err := validateNotificationWebhooks(postgres); err != nil {
+		return err
+	}
+
+	if err := validateBaseBackupSchedule(postgres); err != nil {
+		return err
+	}
+
+	if err := validateRecoveryTargetAnnotations(postgres, postgresVersion); err != nil {
+		return err
+	}
+
+	if err := validateRestorePoint(postgres); err != nil {
+		return err
+	}
+
+	if err := validateCloneFrom(postgres); err != nil {
+		return err
+	}
+
+	if err := validateArchiveRetention(postgres); err != nil {
+		return err
+	}
+
+	if err := validateSnapshotRetention(postgres); err != nil {
+		return err
+	}
+
+	if err := validateSnapshotRestore(postgres); err != nil {
+		return err
+	}
+
+	if err := validateBackupDrill(postgres); err != nil {
+		return err
+	}
+
+	if err := validateStashBackup(postgres); err != nil {
+		return err
+	}
+
+	if err := validateInitVolumeSnapshot(postgres); err != nil {
+		return err
+	}
+
+	if err := validateBackupRPO(postgres); err != nil {
+		return err
+	}
+
 	if err := matchWithDormantDatabase(extClient, postgres); err != nil {
 		return err
 	}
 	return nil
 }
 
+func validateBaseBackupSchedule(postgres *api.Postgres) error {
+	if schedule, found := postgres.Annotations[le.BaseBackupScheduleAnnotation]; found {
+		if postgres.Spec.Archiver == nil || postgres.Spec.Archiver.Storage == nil {
+			return fmt.Errorf(`annotation "%v" requires 'spec.archiver'`, le.BaseBackupScheduleAnnotation)
+		}
+		if _, err := cron.Parse(schedule); err != nil {
+			return fmt.Errorf(`annotation "%v" has invalid schedule "%v". Reason: %v`, le.BaseBackupScheduleAnnotation, schedule, err)
+		}
+	}
+	if steps, found := postgres.Annotations[le.BaseBackupDeltaMaxStepsAnnotation]; found {
+		if n, err := strconv.Atoi(steps); err != nil || n < 0 {
+			return fmt.Errorf(`annotation "%v" must be a non-negative integer`, le.BaseBackupDeltaMaxStepsAnnotation)
+		}
+	}
+	return nil
+}
+
+func validateArchiveRetention(postgres *api.Postgres) error {
+	retain, retainFound := postgres.Annotations[le.ArchiveRetainBaseBackupsAnnotation]
+	window, windowFound := postgres.Annotations[le.ArchiveRetainWindowAnnotation]
+	if !retainFound && !windowFound {
+		return nil
+	}
+	if postgres.Spec.Archiver == nil || postgres.Spec.Archiver.Storage == nil {
+		return errors.New("archive retention requires 'spec.archiver'")
+	}
+	if retainFound {
+		if n, err := strconv.Atoi(retain); err != nil || n <= 0 {
+			return fmt.Errorf(`annotation "%v" must be a positive integer`, le.ArchiveRetainBaseBackupsAnnotation)
+		}
+	}
+	if windowFound {
+		if d, err := time.ParseDuration(window); err != nil || d <= 0 {
+			return fmt.Errorf(`annotation "%v" must be a positive duration, e.g. "168h"`, le.ArchiveRetainWindowAnnotation)
+		}
+	}
+	return nil
+}
+
+func validateSnapshotRetention(postgres *api.Postgres) error {
+	retain, retainFound := postgres.Annotations[SnapshotRetainAnnotation]
+	window, windowFound := postgres.Annotations[SnapshotRetainWindowAnnotation]
+	if !retainFound && !windowFound {
+		return nil
+	}
+	if postgres.Spec.BackupSchedule == nil {
+		return errors.New("snapshot retention requires 'spec.backupSchedule'")
+	}
+	if retainFound {
+		if n, err := strconv.Atoi(retain); err != nil || n <= 0 {
+			return fmt.Errorf(`annotation "%v" must be a positive integer`, SnapshotRetainAnnotation)
+		}
+	}
+	if windowFound {
+		if d, err := time.ParseDuration(window); err != nil || d <= 0 {
+			return fmt.Errorf(`annotation "%v" must be a positive duration, e.g. "720h"`, SnapshotRetainWindowAnnotation)
+		}
+	}
+	return nil
+}
+
+// validateSnapshotRestore checks the options of a selective or parallel Snapshot restore.
+func validateSnapshotRestore(postgres *api.Postgres) error {
+	found := false
+	for _, key := range []string{RestoreDatabasesAnnotation, RestoreSchemasAnnotation, RestoreTablesAnnotation, RestoreJobsAnnotation} {
+		if _, ok := postgres.Annotations[key]; ok {
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	if postgres.Spec.Init == nil || postgres.Spec.Init.SnapshotSource == nil {
+		return errors.New("snapshot restore options require 'spec.init.snapshotSource'")
+	}
+	for _, key := range []string{RestoreDatabasesAnnotation, RestoreSchemasAnnotation, RestoreTablesAnnotation} {
+		list, ok := postgres.Annotations[key]
+		if !ok {
+			continue
+		}
+		for _, name := range strings.Split(list, ",") {
+			if name == "" || strings.ContainsAny(name, " '\"") {
+				return fmt.Errorf(`annotation "%v" must be a comma separated list of names, found "%v"`, key, list)
+			}
+		}
+	}
+	if jobs, ok := postgres.Annotations[RestoreJobsAnnotation]; ok {
+		if n, err := strconv.Atoi(jobs); err != nil || n <= 0 {
+			return fmt.Errorf(`annotation "%v" must be a positive integer`, RestoreJobsAnnotation)
+		}
+	}
+	return nil
+}
+
+func validateBackupDrill(postgres *api.Postgres) error {
+	drill, found := postgres.Annotations[BackupDrillAnnotation]
+	if !found {
+		for _, key := range []string{BackupDrillAssertionsAnnotation, BackupDrillTimeoutAnnotation} {
+			if _, ok := postgres.Annotations[key]; ok {
+				return fmt.Errorf(`annotation "%v" requires annotation "%v"`, key, BackupDrillAnnotation)
+			}
+		}
+		return nil
+	}
+	switch drill {
+	case BackupDrillSnapshot:
+		if postgres.Spec.BackupSchedule == nil {
+			return errors.New("snapshot drills require 'spec.backupSchedule'")
+		}
+	case BackupDrillArchive:
+		if postgres.Spec.Archiver == nil || postgres.Spec.Archiver.Storage == nil {
+			return errors.New("archive drills require 'spec.archiver'")
+		}
+	default:
+		return fmt.Errorf(`annotation "%v" must be "%v" or "%v"`, BackupDrillAnnotation, BackupDrillSnapshot, BackupDrillArchive)
+	}
+	if timeout, ok := postgres.Annotations[BackupDrillTimeoutAnnotation]; ok {
+		if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+			return fmt.Errorf(`annotation "%v" must be a positive duration, e.g. "2h"`, BackupDrillTimeoutAnnotation)
+		}
+	}
+	return nil
+}
+
+func validateBackupRPO(postgres *api.Postgres) error {
+	if rpo, found := postgres.Annotations[BackupRPOAnnotation]; found {
+		if d, err := time.ParseDuration(rpo); err != nil || d <= 0 {
+			return fmt.Errorf(`annotation "%v" must be a positive duration, e.g. "24h"`, BackupRPOAnnotation)
+		}
+	}
+	return nil
+}
+
+func validateStashBackup(postgres *api.Postgres) error {
+	stash, found := postgres.Annotations[StashBackupAnnotation]
+	if !found {
+		if _, ok := postgres.Annotations[StashBackupTaskAnnotation]; ok {
+			return fmt.Errorf(`annotation "%v" requires annotation "%v"`, StashBackupTaskAnnotation, StashBackupAnnotation)
+		}
+		return nil
+	}
+	enabled, err := strconv.ParseBool(stash)
+	if err != nil {
+		return fmt.Errorf(`annotation "%v" must be "true" or "false"`, StashBackupAnnotation)
+	}
+	if !enabled {
+		return nil
+	}
+	if postgres.Spec.BackupSchedule == nil {
+		return errors.New("stash backups require 'spec.backupSchedule'")
+	}
+	if _, found := postgres.Annotations[SnapshotRetainWindowAnnotation]; found {
+		return fmt.Errorf(`annotation "%v" is not supported for stash backups`, SnapshotRetainWindowAnnotation)
+	}
+	if postgres.Annotations[BackupDrillAnnotation] == BackupDrillSnapshot {
+		return errors.New("snapshot drills are not supported for stash backups")
+	}
+	return nil
+}
+
+func validateInitVolumeSnapshot(postgres *api.Postgres) error {
+	if _, found := postgres.Annotations[InitVolumeSnapshotAnnotation]; !found {
+		return nil
+	}
+	if postgres.Spec.StorageType == api.StorageTypeEphemeral {
+		return fmt.Errorf(`annotation "%v" requires StorageType "%v"`, InitVolumeSnapshotAnnotation, api.StorageTypeDurable)
+	}
+	if init := postgres.Spec.Init; init != nil &&
+		(init.SnapshotSource != nil || init.PostgresWAL != nil || init.StashRestoreSession != nil) {
+		return fmt.Errorf(`annotation "%v" can not be combined with another init source`, InitVolumeSnapshotAnnotation)
+	}
+	if _, found := postgres.Annotations[CloneFromAnnotation]; found {
+		return fmt.Errorf(`annotation "%v" can not be combined with annotation "%v"`, InitVolumeSnapshotAnnotation, CloneFromAnnotation)
+	}
+	return nil
+}
+
+// BackupEncryption returns the Secret and the keys that backups of a Postgres are encrypted with
+// and that its wal init is decrypted with. The Secret name is empty, if backups are not encrypted.
+func BackupEncryption(postgres *api.Postgres) (secretName, key, restoreKey string) {
+	secretName = postgres.Annotations[BackupEncryptionSecretAnnotation]
+	key = postgres.Annotations[BackupEncryptionKeyAnnotation]
+	if key == "" {
+		key = DefaultBackupEncryptionKey
+	}
+	restoreKey = postgres.Annotations[RestoreEncryptionKeyAnnotation]
+	if restoreKey == "" {
+		restoreKey = key
+	}
+	return
+}
+
+func validateBackupEncryption(client kubernetes.Interface, postgres *api.Postgres) error {
+	secretName, key, restoreKey := BackupEncryption(postgres)
+	if secretName == "" {
+		for _, k := range []string{BackupEncryptionKeyAnnotation, RestoreEncryptionKeyAnnotation} {
+			if _, found := postgres.Annotations[k]; found {
+				return fmt.Errorf(`annotation "%v" requires annotation "%v"`, k, BackupEncryptionSecretAnnotation)
+			}
+		}
+		return nil
+	}
+	secret, err := client.CoreV1().Secrets(postgres.Namespace).Get(secretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !hasKeys(secret, key) {
+		return fmt.Errorf(`backup encryption secret "%v" has no key "%v"`, secretName, key)
+	}
+	if postgres.Spec.Init != nil && postgres.Spec.Init.PostgresWAL != nil && !hasKeys(secret, restoreKey) {
+		return fmt.Errorf(`backup encryption secret "%v" has no key "%v"`, secretName, restoreKey)
+	}
+	return nil
+}
+
+// validateWALStorageSecrets checks that the storage Secrets of the wal archive and of a WAL init
+// exist and hold the credentials wal-g reads for the configured provider.
+func validateWALStorageSecrets(client kubernetes.Interface, postgres *api.Postgres) error {
+	if postgres.Spec.Archiver != nil && postgres.Spec.Archiver.Storage != nil {
+		if err := validateWALStorageSecret(client, *postgres.Spec.Archiver.Storage, postgres.Namespace, "spec.archiver.storage"); err != nil {
+			return err
+		}
+	}
+	if postgres.Spec.Init != nil && postgres.Spec.Init.PostgresWAL != nil {
+		if err := validateWALStorageSecret(client, postgres.Spec.Init.PostgresWAL.Backend, postgres.Namespace, "spec.init.postgresWAL"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateWALStorageSecret(client kubernetes.Interface, backend store.Backend, namespace, path string) error {
+	if backend.Local != nil {
+		return nil
+	}
+	if _, err := backend.Container(); err != nil {
+		return fmt.Errorf("'%v' is invalid. Reason: %v", path, err)
+	}
+	if backend.StorageSecretName == "" {
+		// S3 and GCS buckets can be accessed with the IAM role of the node
+		if backend.Azure != nil || backend.Swift != nil {
+			return fmt.Errorf("'%v.storageSecretName' is missing", path)
+		}
+		return nil
+	}
+
+	secret, err := client.CoreV1().Secrets(namespace).Get(backend.StorageSecretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf(`failed to get Secret "%v" of '%v'. Reason: %v`, backend.StorageSecretName, path, err)
+	}
+
+	// every entry lists alternative sets of keys, one of which must be complete
+	var required [][][]string
+	switch {
+	case backend.S3 != nil:
+		required = [][][]string{
+			{{"AWS_ACCESS_KEY_ID"}},
+			{{"AWS_SECRET_ACCESS_KEY"}},
+		}
+	case backend.GCS != nil:
+		required = [][][]string{
+			{{"GOOGLE_APPLICATION_CREDENTIALS"}, {"GOOGLE_SERVICE_ACCOUNT_JSON_KEY"}},
+		}
+	case backend.Azure != nil:
+		required = [][][]string{
+			{{"AZURE_STORAGE_ACCOUNT"}, {"AZURE_ACCOUNT_NAME"}},
+			{{"AZURE_STORAGE_ACCESS_KEY"}, {"AZURE_ACCOUNT_KEY"}},
+		}
+	case backend.Swift != nil:
+		required = [][][]string{
+			{
+				{"OS_AUTH_URL", "OS_USERNAME", "OS_PASSWORD"},
+				{"OS_STORAGE_URL", "OS_AUTH_TOKEN"},
+				{"ST_AUTH", "ST_USER", "ST_KEY"},
+			},
+		}
+	}
+
+	for _, alternatives := range required {
+		found := false
+		for _, keys := range alternatives {
+			if hasKeys(secret, keys...) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			options := make([]string, 0, len(alternatives))
+			for _, keys := range alternatives {
+				options = append(options, strings.Join(keys, ", "))
+			}
+			return fmt.Errorf(`storage secret "%v" of '%v' is missing keys. Required: %v`,
+				backend.StorageSecretName, path, strings.Join(options, " or "))
+		}
+	}
+	return nil
+}
+
+func hasKeys(secret *core.Secret, keys ...string) bool {
+	for _, key := range keys {
+		if len(secret.Data[key]) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validateRecoveryTarget makes sure that the base backup of a WAL init exists and that its target time
+// is not before the oldest base backup. Archives that can not be listed in time are not checked.
+// Only the base backups are listed, the operator checks the end of the archive before the first start.
+func validateRecoveryTarget(client kubernetes.Interface, postgres *api.Postgres) error {
+	if postgres.Spec.Init == nil || postgres.Spec.Init.PostgresWAL == nil || postgres.Spec.Init.PostgresWAL.Local != nil {
+		return nil
+	}
+	wal := postgres.Spec.Init.PostgresWAL
+
+	window, err := listBaseBackups(client, wal.Backend, postgres.Namespace)
+	if err != nil {
+		log.Warningf("failed to list wal archive of Postgres %s/%s. Reason: %v", postgres.Namespace, postgres.Name, err)
+		return nil
+	}
+	if len(window.BaseBackups) == 0 {
+		return errors.New("no base backup found in 'spec.init.postgresWAL'")
+	}
+	if !window.HasBaseBackup(wal.BackupName) {
+		return fmt.Errorf(`base backup "%v" not found in 'spec.init.postgresWAL'. Available: %v`,
+			wal.BackupName, strings.Join(window.BaseBackupNames(), ", "))
+	}
+	if wal.PITR == nil || wal.PITR.TargetTime == "" {
+		return nil
+	}
+	target, err := archive.ParseTargetTime(wal.PITR.TargetTime)
+	if err != nil {
+		// let postgres decide about formats we do not know
+		return nil
+	}
+	if target.Before(window.From) {
+		return fmt.Errorf(`'spec.init.postgresWAL.pitr.targetTime' %v is before the oldest base backup from %v`,
+			wal.PITR.TargetTime, window.From.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// listBaseBackups lists the base backups of a wal archive within archiveListTimeout, so that a slow
+// object store does not exceed the timeout of the admission request.
+func listBaseBackups(client kubernetes.Interface, backend store.Backend, namespace string) (*archive.Window, error) {
+	type result struct {
+		window *archive.Window
+		err    error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		window, err := archive.ListBaseBackups(client, backend, namespace, archive.Prefix(backend))
+		ch <- result{window, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.window, r.err
+	case <-time.After(archiveListTimeout):
+		return nil, fmt.Errorf("listing base backups took longer than %v", archiveListTimeout)
+	}
+}
+
+var lsnRegex = regexp.MustCompile(`^[0-9A-Fa-f]{1,8}/[0-9A-Fa-f]{1,8}$`)
+
+func validateRecoveryTargetAnnotations(postgres *api.Postgres, postgresVersion *catalog.PostgresVersion) error {
+	lsn, lsnFound := postgres.Annotations[archive.RecoveryTargetLSNAnnotation]
+	name, nameFound := postgres.Annotations[archive.RecoveryTargetNameAnnotation]
+	if !lsnFound && !nameFound {
+		return nil
+	}
+	if postgres.Spec.Init == nil || postgres.Spec.Init.PostgresWAL == nil {
+		return fmt.Errorf(`annotations "%v" and "%v" require 'spec.init.postgresWAL'`,
+			archive.RecoveryTargetLSNAnnotation, archive.RecoveryTargetNameAnnotation)
+	}
+
+	targets := 0
+	for _, found := range []bool{lsnFound, nameFound} {
+		if found {
+			targets++
+		}
+	}
+	if pitr := postgres.Spec.Init.PostgresWAL.PITR; pitr != nil {
+		if pitr.TargetTime != "" {
+			targets++
+		}
+		if pitr.TargetXID != "" {
+			targets++
+		}
+	}
+	if targets > 1 {
+		return errors.New("only one recovery target of targetTime, targetXID, lsn and name can be set")
+	}
+
+	if lsnFound {
+		if !lsnRegex.MatchString(lsn) {
+			return fmt.Errorf(`annotation "%v" has invalid LSN "%v"`, archive.RecoveryTargetLSNAnnotation, lsn)
+		}
+		if strings.HasPrefix(postgresVersion.Spec.Version, "9.") {
+			return fmt.Errorf(`annotation "%v" requires Postgres 10 or later`, archive.RecoveryTargetLSNAnnotation)
+		}
+	}
+	if nameFound {
+		if err := validateRestorePointName(name); err != nil {
+			return fmt.Errorf(`annotation "%v" is invalid. Reason: %v`, archive.RecoveryTargetNameAnnotation, err)
+		}
+	}
+	return nil
+}
+
+func validateRestorePoint(postgres *api.Postgres) error {
+	name, found := postgres.Annotations[le.RestorePointAnnotation]
+	if !found {
+		return nil
+	}
+	if postgres.Spec.Archiver == nil || postgres.Spec.Archiver.Storage == nil {
+		return fmt.Errorf(`annotation "%v" requires 'spec.archiver'`, le.RestorePointAnnotation)
+	}
+	if err := validateRestorePointName(name); err != nil {
+		return fmt.Errorf(`annotation "%v" is invalid. Reason: %v`, le.RestorePointAnnotation, err)
+	}
+	return nil
+}
+
+// validateRestorePointName checks the limits postgres puts on restore point names.
+func validateRestorePointName(name string) error {
+	if name == "" {
+		return errors.New("restore point name is empty")
+	}
+	if len(name) >= 64 {
+		return errors.New("restore point name must be shorter than 64 characters")
+	}
+	return nil
+}
+
+// CloneSource returns namespace and name of the Postgres a clone is created from.
+func CloneSource(postgres *api.Postgres) (string, string) {
+	from := postgres.Annotations[CloneFromAnnotation]
+	if parts := strings.SplitN(from, "/", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return postgres.Namespace, from
+}
+
+func validateCloneFrom(postgres *api.Postgres) error {
+	if _, found := postgres.Annotations[CloneFromAnnotation]; !found {
+		if _, found := postgres.Annotations[CloneTargetTimeAnnotation]; found {
+			return fmt.Errorf(`annotation "%v" requires "%v"`, CloneTargetTimeAnnotation, CloneFromAnnotation)
+		}
+		return nil
+	}
+	namespace, name := CloneSource(postgres)
+	if namespace == "" || name == "" {
+		return fmt.Errorf(`annotation "%v" must be "name" or "namespace/name"`, CloneFromAnnotation)
+	}
+	if namespace == postgres.Namespace && name == postgres.Name {
+		return errors.New("postgres can not be cloned from itself")
+	}
+	if init := postgres.Spec.Init; init != nil &&
+		(init.SnapshotSource != nil || init.PostgresWAL != nil || init.StashRestoreSession != nil) {
+		return fmt.Errorf(`annotation "%v" can not be combined with 'spec.init'`, CloneFromAnnotation)
+	}
+	if targetTime, found := postgres.Annotations[CloneTargetTimeAnnotation]; found {
+		if _, err := archive.ParseTargetTime(targetTime); err != nil {
+			return fmt.Errorf(`annotation "%v" has invalid time "%v"`, CloneTargetTimeAnnotation, targetTime)
+		}
+	}
+	return nil
+}
+
+// validateCloneUnchanged rejects adding or changing the clone annotations of an existing Postgres.
+// The source of a clone is only checked, when the Postgres is created. The annotations may be removed.
+func validateCloneUnchanged(postgres, oldPostgres *api.Postgres) error {
+	for _, key := range []string{CloneFromAnnotation, CloneTargetTimeAnnotation} {
+		if value, found := postgres.Annotations[key]; found && value != oldPostgres.Annotations[key] {
+			return fmt.Errorf(`annotation "%v" can only be set when the Postgres is created`, key)
+		}
+	}
+	return nil
+}
+
+// validateCloneSource checks that the source of a clone can be cloned from and that the user
+// creating the clone is allowed to read the source and its credentials in another namespace.
+func validateCloneSource(client kubernetes.Interface, extClient cs.Interface, postgres *api.Postgres, user authentication.UserInfo) error {
+	if _, found := postgres.Annotations[CloneFromAnnotation]; !found {
+		return nil
+	}
+	namespace, name := CloneSource(postgres)
+	source, err := extClient.KubedbV1alpha1().Postgreses(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf(`failed to get Postgres "%v/%v" to clone from. Reason: %v`, namespace, name, err)
+	}
+
+	archived := source.Spec.Archiver != nil && source.Spec.Archiver.Storage != nil
+	if _, found := postgres.Annotations[CloneTargetTimeAnnotation]; found && !archived {
+		return fmt.Errorf(`annotation "%v" requires Postgres "%v/%v" to have 'spec.archiver'`, CloneTargetTimeAnnotation, namespace, name)
+	}
+	if namespace == postgres.Namespace {
+		return nil
+	}
+	if archived && source.Spec.Archiver.Storage.Local != nil {
+		return fmt.Errorf(`local wal archive of Postgres "%v/%v" can not be cloned into another namespace`, namespace, name)
+	}
+
+	if err := checkAccess(client, user, authorization.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      "get",
+		Group:     api.SchemeGroupVersion.Group,
+		Resource:  api.ResourcePluralPostgres,
+		Name:      name,
+	}); err != nil {
+		return err
+	}
+	secretName := ""
+	if archived {
+		secretName = source.Spec.Archiver.Storage.StorageSecretName
+	} else if source.Spec.DatabaseSecret != nil {
+		secretName = source.Spec.DatabaseSecret.SecretName
+	}
+	if secretName == "" {
+		return nil
+	}
+	return checkAccess(client, user, authorization.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      "get",
+		Resource:  "secrets",
+		Name:      secretName,
+	})
+}
+
+// validateSnapshotSourceAccess checks that a Snapshot in another namespace may be restored into that of
+// a new Postgres and that the user creating the Postgres is allowed to read the Snapshot and its backend Secret.
+func validateSnapshotSourceAccess(client kubernetes.Interface, extClient cs.Interface, postgres *api.Postgres, user authentication.UserInfo) error {
+	if postgres.Spec.Init == nil || postgres.Spec.Init.SnapshotSource == nil {
+		return nil
+	}
+	source := postgres.Spec.Init.SnapshotSource
+	if source.Namespace == "" || source.Namespace == postgres.Namespace {
+		return nil
+	}
+
+	ns, err := client.CoreV1().Namespaces().Get(source.Namespace, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf(`failed to get namespace "%v" of Snapshot "%v". Reason: %v`, source.Namespace, source.Name, err)
+	}
+	if allowed, found := ns.Annotations[SnapshotRestoreNamespacesAnnotation]; found {
+		permitted := false
+		for _, namespace := range strings.Split(allowed, ",") {
+			if namespace = strings.TrimSpace(namespace); namespace == "*" || namespace == postgres.Namespace {
+				permitted = true
+				break
+			}
+		}
+		if !permitted {
+			return fmt.Errorf(`namespace "%v" does not allow restoring its Snapshots into namespace "%v"`, source.Namespace, postgres.Namespace)
+		}
+	}
+
+	if err := checkAccess(client, user, authorization.ResourceAttributes{
+		Namespace: source.Namespace,
+		Verb:      "get",
+		Group:     api.SchemeGroupVersion.Group,
+		Resource:  api.ResourcePluralSnapshot,
+		Name:      source.Name,
+	}); err != nil {
+		return err
+	}
+	snapshot, err := extClient.KubedbV1alpha1().Snapshots(source.Namespace).Get(source.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf(`failed to get Snapshot "%v/%v" to restore. Reason: %v`, source.Namespace, source.Name, err)
+	}
+	if snapshot.Spec.StorageSecretName == "" {
+		return nil
+	}
+	return checkAccess(client, user, authorization.ResourceAttributes{
+		Namespace: source.Namespace,
+		Verb:      "get",
+		Resource:  "secrets",
+		Name:      snapshot.Spec.StorageSecretName,
+	})
+}
+
+// checkAccess asks the API server, whether the user of an admission request may access a resource.
+func checkAccess(client kubernetes.Interface, user authentication.UserInfo, attributes authorization.ResourceAttributes) error {
+	extra := map[string]authorization.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = authorization.ExtraValue(v)
+	}
+	review, err := client.AuthorizationV1().SubjectAccessReviews().Create(&authorization.SubjectAccessReview{
+		Spec: authorization.SubjectAccessReviewSpec{
+			ResourceAttributes: &attributes,
+			User:               user.Username,
+			Groups:             user.Groups,
+			UID:                user.UID,
+			Extra:              extra,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if !review.Status.Allowed {
+		resource := attributes.Resource
+		if attributes.Group != "" {
+			resource += "." + attributes.Group
+		}
+		return fmt.Errorf(`user "%v" is not allowed to %v %v "%v/%v"`,
+			user.Username, attributes.Verb, resource, attributes.Namespace, attributes.Name)
+	}
+	return nil
+}
+
+func validateNotificationWebhooks(postgres *api.Postgres) error {
+	for _, webhook := range strings.Split(postgres.Annotations[le.NotificationWebhooksAnnotation], ",") {
+		if webhook = strings.TrimSpace(webhook); webhook == "" {
+			continue
+		}
+		u, err := url.Parse(webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf(`annotation "%v" has invalid webhook url "%v"`, le.NotificationWebhooksAnnotation, webhook)
+		}
+	}
+	return nil
+}
+
 func matchWithDormantDatabase(extClient cs.Interface, postgres *api.Postgres) error {
 	// Check if DormantDatabase exists or not
 	dormantDb, err := extClient.KubedbV1alpha1().DormantDatabases(postgres.Namespace).Get(postgres.Name, metav1.GetOptions{})
@@ -9,6 +9,7 @@ import (
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	extFake "github.com/kubedb/apimachinery/client/clientset/versioned/fake"
 	"github.com/kubedb/apimachinery/client/clientset/versioned/scheme"
+	le "github.com/kubedb/postgres/pkg/leader_election"
 	admission "k8s.io/api/admission/v1beta1"
 	apps "k8s.io/api/apps/v1"
 	authenticationV1 "k8s.io/api/authentication/v1"
@@ -22,6 +23,7 @@ import (
 	clientSetScheme "k8s.io/client-go/kubernetes/scheme"
 	"kmodules.xyz/client-go/meta"
 	mona "kmodules.xyz/monitoring-agent-api/api/v1"
+	store "kmodules.xyz/objectstore-api/api/v1"
 )
 
 func init() {
@@ -54,6 +56,16 @@ func TestPostgresValidator_Admit(t *testing.T) {
 						Namespace: "default",
 					},
 				},
+				&core.Secret{
+					ObjectMeta: metaV1.ObjectMeta{
+						Name:      "foo-s3",
+						Namespace: "default",
+					},
+					Data: map[string][]byte{
+						"AWS_ACCESS_KEY_ID":     []byte("key"),
+						"AWS_SECRET_ACCESS_KEY": []byte("secret"),
+					},
+				},
 				&storageV1beta1.StorageClass{
 					ObjectMeta: metaV1.ObjectMeta{
 						Name: "standard",
@@ -138,6 +150,169 @@ var cases = []struct {
 		false,
 		false,
 	},
+	{"Create Postgres with notification webhooks",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		withAnnotations(samplePostgres(), map[string]string{
+			le.NotificationWebhooksAnnotation: "https://hooks.example.com/failover, http://alerts.example.com",
+		}),
+		api.Postgres{},
+		false,
+		true,
+	},
+	{"Create Postgres with invalid notification webhook",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		withAnnotations(samplePostgres(), map[string]string{
+			le.NotificationWebhooksAnnotation: "https://hooks.example.com/failover,hooks.example.com",
+		}),
+		api.Postgres{},
+		false,
+		false,
+	},
+	{"Create Postgres with archiver storage secret",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		archiverSecret(samplePostgres(), "foo-s3"),
+		api.Postgres{},
+		false,
+		true,
+	},
+	{"Create Postgres with incomplete archiver storage secret",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		archiverSecret(samplePostgres(), "foo-auth"),
+		api.Postgres{},
+		false,
+		false,
+	},
+	{"Create Postgres cloned from non existing Postgres",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		cloneFrom(samplePostgres(), "demo/bar"),
+		api.Postgres{},
+		false,
+		false,
+	},
+	{"Create Postgres with missing backup encryption key",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		missingBackupEncryptionKey(samplePostgres()),
+		api.Postgres{},
+		false,
+		false,
+	},
+	{"Create Postgres with archive drill",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		withAnnotations(archiverSecret(samplePostgres(), "foo-s3"), map[string]string{
+			BackupDrillAnnotation:        BackupDrillArchive,
+			BackupDrillTimeoutAnnotation: "2h",
+		}),
+		api.Postgres{},
+		false,
+		true,
+	},
+	{"Create Postgres with snapshot drill without backup schedule",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		snapshotDrillWithoutSchedule(samplePostgres()),
+		api.Postgres{},
+		false,
+		false,
+	},
+	{"Create Postgres with backup RPO",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		withAnnotations(samplePostgres(), map[string]string{
+			BackupRPOAnnotation: "24h",
+		}),
+		api.Postgres{},
+		false,
+		true,
+	},
+	{"Create Postgres with invalid backup RPO",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		invalidBackupRPO(samplePostgres()),
+		api.Postgres{},
+		false,
+		false,
+	},
+	{"Create Postgres with stash backup",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		withAnnotations(withBackups(samplePostgres(), true, false), map[string]string{
+			StashBackupAnnotation: "true",
+		}),
+		api.Postgres{},
+		false,
+		true,
+	},
+	{"Create Postgres with stash backup without backup schedule",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		stashBackupWithoutSchedule(samplePostgres()),
+		api.Postgres{},
+		false,
+		false,
+	},
+	{"Create Postgres with init volume snapshot",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		withAnnotations(samplePostgres(), map[string]string{
+			InitVolumeSnapshotAnnotation: "foo-snapshot",
+		}),
+		api.Postgres{},
+		false,
+		true,
+	},
+	{"Create Postgres with init volume snapshot and ephemeral storage",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		initVolumeSnapshotEphemeral(samplePostgres()),
+		api.Postgres{},
+		false,
+		false,
+	},
+	{"Create Postgres with Snapshot from another namespace",
+		requestKind,
+		"foo",
+		"default",
+		admission.Create,
+		snapshotFromAnotherNamespace(samplePostgres()),
+		api.Postgres{},
+		false,
+		false,
+	},
 	{"Edit Postgres Spec.DatabaseSecret with Existing Secret",
 		requestKind,
 		"foo",
@@ -158,6 +333,36 @@ var cases = []struct {
 		false,
 		true,
 	},
+	{"Edit Postgres keeping its clone source",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		editStatus(cloneFrom(samplePostgres(), "demo/bar")),
+		cloneFrom(samplePostgres(), "demo/bar"),
+		false,
+		true,
+	},
+	{"Add clone source to existing Postgres",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		cloneFrom(samplePostgres(), "demo/bar"),
+		samplePostgres(),
+		false,
+		false,
+	},
+	{"Change clone source of existing Postgres",
+		requestKind,
+		"foo",
+		"default",
+		admission.Update,
+		cloneFrom(samplePostgres(), "demo/baz"),
+		cloneFrom(samplePostgres(), "demo/bar"),
+		false,
+		false,
+	},
 	{"Edit Status",
 		requestKind,
 		"foo",
@@ -279,6 +484,82 @@ func getAwkwardPostgres() api.Postgres {
 	return postgres
 }
 
+func withAnnotations(old api.Postgres, annotations map[string]string) api.Postgres {
+	old.Annotations = annotations
+	return old
+}
+
+func archiverSecret(old api.Postgres, secretName string) api.Postgres {
+	old.Spec.Archiver = &api.PostgresArchiverSpec{
+		Storage: &store.Backend{
+			StorageSecretName: secretName,
+			S3: &store.S3Spec{
+				Bucket: "kubedb",
+			},
+		},
+	}
+	return old
+}
+
+func cloneFrom(old api.Postgres, source string) api.Postgres {
+	old.Annotations = map[string]string{
+		CloneFromAnnotation: source,
+	}
+	return old
+}
+
+func missingBackupEncryptionKey(old api.Postgres) api.Postgres {
+	old.Annotations = map[string]string{
+		BackupEncryptionSecretAnnotation: "foo-auth",
+	}
+	return old
+}
+
+func snapshotDrillWithoutSchedule(old api.Postgres) api.Postgres {
+	old.Annotations = map[string]string{
+		BackupDrillAnnotation: BackupDrillSnapshot,
+	}
+	old.Spec.BackupSchedule = nil
+	return old
+}
+
+func invalidBackupRPO(old api.Postgres) api.Postgres {
+	old.Annotations = map[string]string{
+		BackupRPOAnnotation: "1d",
+	}
+	return old
+}
+
+func stashBackupWithoutSchedule(old api.Postgres) api.Postgres {
+	old.Annotations = map[string]string{
+		StashBackupAnnotation: "true",
+	}
+	old.Spec.BackupSchedule = nil
+	return old
+}
+
+func initVolumeSnapshotEphemeral(old api.Postgres) api.Postgres {
+	old.Annotations = map[string]string{
+		InitVolumeSnapshotAnnotation: "foo-snapshot",
+	}
+	old.Spec.StorageType = api.StorageTypeEphemeral
+	old.Spec.Storage = nil
+	return old
+}
+
+func snapshotFromAnotherNamespace(old api.Postgres) api.Postgres {
+	old.Spec.DatabaseSecret = &core.SecretVolumeSource{
+		SecretName: "foo-auth",
+	}
+	old.Spec.Init = &api.InitSpec{
+		SnapshotSource: &api.SnapshotSourceSpec{
+			Namespace: "other",
+			Name:      "foo-snapshot",
+		},
+	}
+	return old
+}
+
 func editExistingSecret(old api.Postgres) api.Postgres {
 	old.Spec.DatabaseSecret = &core.SecretVolumeSource{
 		SecretName: "foo-auth",
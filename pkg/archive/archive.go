@@ -0,0 +1,242 @@
+package archive
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/graymeta/stow"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	"k8s.io/client-go/kubernetes"
+	store "kmodules.xyz/objectstore-api/api/v1"
+	"kmodules.xyz/objectstore-api/osm"
+)
+
+const (
+	// Entries of the status ConfigMap of a Postgres, published by the operator from its wal archive.
+	// BaseBackupsKey holds the names of the base backups, oldest first, comma separated.
+	BaseBackupsKey = "base-backups"
+	// Earliest and latest point in time (RFC3339) the wal archive can recover to.
+	RecoverableFromKey  = "recoverable-from"
+	RecoverableUntilKey = "recoverable-until"
+	// Name and finish time (RFC3339) of the newest base backup in the wal archive.
+	LastBaseBackupKey     = "last-base-backup"
+	LastBaseBackupTimeKey = "last-base-backup-time"
+	// MetadataBaseBackupKey is the base backup the backup metadata of the wal archive was last written for.
+	MetadataBaseBackupKey = "metadata-base-backup"
+
+	// Recovery targets of a WAL init, in addition to the ones of spec.init.postgresWAL.pitr.
+	// The LSN target requires Postgres 10 or later. Restore points are created with
+	// the restore-point annotation of the sidecar.
+	RecoveryTargetLSNAnnotation  = api.PostgresKey + "/recovery-target-lsn"
+	RecoveryTargetNameAnnotation = api.PostgresKey + "/recovery-target-name"
+
+	// wal-g layout of an archive
+	baseBackupDir  = "basebackups_005"
+	walDir         = "wal_005"
+	sentinelSuffix = "_backup_stop_sentinel.json"
+)
+
+// BaseBackup is a base backup found in a wal-g archive.
+type BaseBackup struct {
+	Name string
+	// Time the backup was finished, ie. the earliest point it can be recovered to.
+	Finished time.Time
+}
+
+// Window describes what can be restored from a wal-g archive.
+type Window struct {
+	// Base backups, oldest first
+	BaseBackups []BaseBackup
+	// Earliest point in time the archive can recover to. This is the end of the oldest base backup.
+	From time.Time
+	// Latest point in time the archive can recover to. This is the time the last WAL segment was archived.
+	Until time.Time
+}
+
+// BaseBackupNames returns the names of the base backups, oldest first.
+func (w *Window) BaseBackupNames() []string {
+	names := make([]string, 0, len(w.BaseBackups))
+	for _, b := range w.BaseBackups {
+		names = append(names, b.Name)
+	}
+	return names
+}
+
+// HasBaseBackup returns whether a base backup with the given name exists. LATEST matches any backup.
+func (w *Window) HasBaseBackup(name string) bool {
+	if name == "" || name == "LATEST" {
+		return len(w.BaseBackups) > 0
+	}
+	for _, b := range w.BaseBackups {
+		if b.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains returns whether t lies within the recoverable window.
+func (w *Window) Contains(t time.Time) bool {
+	return !t.Before(w.From) && !t.After(w.Until)
+}
+
+// List lists the base backups and WAL segments stored under prefix in the backend.
+// Local backends are mounted into the database pods only, so they can not be listed.
+func List(client kubernetes.Interface, backend store.Backend, namespace, prefix string) (*Window, error) {
+	container, err := dialArchive(client, backend, namespace)
+	if err != nil {
+		return nil, err
+	}
+	w, err := listBaseBackups(container, prefix)
+	if err != nil || len(w.BaseBackups) == 0 {
+		return w, err
+	}
+	if err := listSegments(container, prefix, w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// ListSegments extends a window of base backups to the last WAL segment stored under prefix in the backend.
+// It pages through all segments of the archive.
+func ListSegments(client kubernetes.Interface, backend store.Backend, namespace, prefix string, w *Window) error {
+	if len(w.BaseBackups) == 0 {
+		return nil
+	}
+	container, err := dialArchive(client, backend, namespace)
+	if err != nil {
+		return err
+	}
+	return listSegments(container, prefix, w)
+}
+
+// ListBaseBackups lists the base backups stored under prefix in the backend, without the WAL segments.
+// The window ends with the newest base backup, as the segments archived after it are not listed.
+func ListBaseBackups(client kubernetes.Interface, backend store.Backend, namespace, prefix string) (*Window, error) {
+	container, err := dialArchive(client, backend, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return listBaseBackups(container, prefix)
+}
+
+func dialArchive(client kubernetes.Interface, backend store.Backend, namespace string) (stow.Container, error) {
+	if backend.Local != nil {
+		return nil, errors.New("listing a local wal archive is not supported")
+	}
+	return dial(client, backend, namespace)
+}
+
+func listBaseBackups(container stow.Container, prefix string) (*Window, error) {
+	w := &Window{}
+	err := walk(container, path.Join(prefix, baseBackupDir)+"/", func(item stow.Item) error {
+		name := path.Base(item.Name())
+		if !strings.HasSuffix(name, sentinelSuffix) {
+			return nil
+		}
+		finished, err := item.LastMod()
+		if err != nil {
+			return err
+		}
+		w.BaseBackups = append(w.BaseBackups, BaseBackup{
+			Name:     strings.TrimSuffix(name, sentinelSuffix),
+			Finished: finished,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(w.BaseBackups) == 0 {
+		return w, nil
+	}
+	sort.Slice(w.BaseBackups, func(i, j int) bool {
+		return w.BaseBackups[i].Finished.Before(w.BaseBackups[j].Finished)
+	})
+	w.From = w.BaseBackups[0].Finished
+	w.Until = w.BaseBackups[len(w.BaseBackups)-1].Finished
+	return w, nil
+}
+
+func listSegments(container stow.Container, prefix string, w *Window) error {
+	return walk(container, path.Join(prefix, walDir)+"/", func(item stow.Item) error {
+		archived, err := item.LastMod()
+		if err != nil {
+			return err
+		}
+		if archived.After(w.Until) {
+			w.Until = archived
+		}
+		return nil
+	})
+}
+
+func dial(client kubernetes.Interface, backend store.Backend, namespace string) (stow.Container, error) {
+	cfg, err := osm.NewOSMContext(client, backend, namespace)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := stow.Dial(cfg.Provider, cfg.Config)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := backend.Container()
+	if err != nil {
+		return nil, err
+	}
+	return loc.Container(bucket)
+}
+
+func walk(container stow.Container, prefix string, fn func(item stow.Item) error) error {
+	cursor := stow.CursorStart
+	for {
+		items, next, err := container.Items(prefix, cursor, 50)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		cursor = next
+		if stow.IsCursorEnd(cursor) {
+			return nil
+		}
+	}
+}
+
+// Prefix returns the path inside the bucket or container of a backend.
+func Prefix(backend store.Backend) string {
+	switch {
+	case backend.S3 != nil:
+		return backend.S3.Prefix
+	case backend.GCS != nil:
+		return backend.GCS.Prefix
+	case backend.Azure != nil:
+		return backend.Azure.Prefix
+	case backend.Swift != nil:
+		return backend.Swift.Prefix
+	}
+	return ""
+}
+
+// ParseTargetTime parses a recovery_target_time as accepted by postgres for the common formats.
+func ParseTargetTime(s string) (time.Time, error) {
+	layouts := []string{
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05.999999999Z07:00",
+		"2006-01-02 15:04:05.999999999Z07",
+		"2006-01-02 15:04:05.999999999 MST",
+		"2006-01-02 15:04:05.999999999",
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("unsupported time format")
+}
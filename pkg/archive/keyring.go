@@ -0,0 +1,72 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// WriteKeyring writes the private OpenPGP keys of all armored key files in dir as a single armored
+// keyring. wal-g only reads the first armored block of WALG_PGP_KEY_PATH, so the keys of a rotated
+// backup encryption Secret are combined to decrypt an archive that was encrypted with more than one key.
+func WriteKeyring(dir string, w io.Writer) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	// the data of a mounted Secret is linked from hidden files and directories
+	var names []string
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), ".") && !f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var keyring openpgp.EntityList
+	for _, name := range names {
+		entities, err := readArmoredKeyFile(filepath.Join(dir, name))
+		if err != nil {
+			return fmt.Errorf(`failed to read key "%v". Reason: %v`, name, err)
+		}
+		for _, e := range entities {
+			if e.PrivateKey != nil {
+				keyring = append(keyring, e)
+			}
+		}
+	}
+	if len(keyring) == 0 {
+		return fmt.Errorf(`no private keys found in "%v"`, dir)
+	}
+
+	out, err := armor.Encode(w, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		return err
+	}
+	for _, e := range keyring {
+		if e.PrivateKey.Encrypted {
+			return errors.New("keys with a passphrase are not supported")
+		}
+		if err := e.SerializePrivate(out, nil); err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
+
+func readArmoredKeyFile(file string) (openpgp.EntityList, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return openpgp.ReadArmoredKeyRing(f)
+}
@@ -0,0 +1,114 @@
+package archive
+
+import (
+	"bytes"
+	"crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func writeArmoredKey(t *testing.T, file string, e *openpgp.Entity) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := armor.Encode(f, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SerializePrivate(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func encryptTo(t *testing.T, e *openpgp.Entity, message string) []byte {
+	buf := &bytes.Buffer{}
+	w, err := openpgp.Encrypt(buf, openpgp.EntityList{e}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(message)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestWriteKeyring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &packet.Config{RSABits: 1024, DefaultHash: crypto.SHA256}
+	old, err := openpgp.NewEntity("old", "", "old@kubedb.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := openpgp.NewEntity("current", "", "current@kubedb.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeArmoredKey(t, filepath.Join(dir, "old.asc"), old)
+	writeArmoredKey(t, filepath.Join(dir, "key.asc"), current)
+	// the data of a mounted Secret is linked from hidden directories
+	if err := os.Mkdir(filepath.Join(dir, "..data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	if err := WriteKeyring(dir, out); err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyring) != 2 {
+		t.Fatalf("expected 2 keys in the keyring, got %v", len(keyring))
+	}
+	for name, e := range map[string]*openpgp.Entity{"old": old, "current": current} {
+		md, err := openpgp.ReadMessage(bytes.NewReader(encryptTo(t, e, name)), keyring, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to decrypt data encrypted with the %v key: %v", name, err)
+		}
+		data, err := ioutil.ReadAll(md.UnverifiedBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != name {
+			t.Errorf("expected %q, got %q", name, data)
+		}
+	}
+}
+
+func TestWriteKeyringWithoutKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := WriteKeyring(dir, &bytes.Buffer{}); err == nil {
+		t.Error("expected an error for a directory without keys")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "key.asc"), []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteKeyring(dir, &bytes.Buffer{}); err == nil {
+		t.Error("expected an error for a malformed key")
+	}
+}
@@ -0,0 +1,159 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+
+	catalog "github.com/kubedb/apimachinery/apis/catalog/v1alpha1"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	core "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	store "kmodules.xyz/objectstore-api/api/v1"
+)
+
+// MetadataFile is stored in the folder of every Snapshot and in every wal archive, to rebuild
+// a Postgres from the backend alone.
+const MetadataFile = "kubedb-metadata.json"
+
+// Metadata describes the Postgres a backup was taken from.
+type Metadata struct {
+	// Postgres without status, init source and annotations maintained by the operator
+	Postgres *api.Postgres `json:"postgres"`
+	// Version of the Postgres, to recreate it in a cluster without the catalog entry
+	PostgresVersion *catalog.PostgresVersion `json:"postgresVersion"`
+	// Snapshot the metadata was stored with, nil for a wal archive
+	Snapshot *api.Snapshot `json:"snapshot,omitempty"`
+	// Latest base backup of a wal archive, when the metadata was stored
+	BaseBackup string `json:"baseBackup,omitempty"`
+	// Auth Secret of the Postgres
+	Secret *SecretMetadata `json:"secret,omitempty"`
+}
+
+// SecretMetadata describes the auth Secret of a Postgres. The data is only stored encrypted.
+type SecretMetadata struct {
+	Name string   `json:"name"`
+	Keys []string `json:"keys"`
+	// AES-256-GCM encrypted JSON of the Secret data, nonce first
+	EncryptedData []byte `json:"encryptedData,omitempty"`
+}
+
+// NewSecretMetadata describes a Secret. The data is encrypted with key, if a key is given.
+func NewSecretMetadata(secret *core.Secret, key []byte) (*SecretMetadata, error) {
+	m := &SecretMetadata{
+		Name: secret.Name,
+	}
+	for k := range secret.Data {
+		m.Keys = append(m.Keys, k)
+	}
+	sort.Strings(m.Keys)
+	if len(key) == 0 {
+		return m, nil
+	}
+
+	data, err := json.Marshal(secret.Data)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	m.EncryptedData = gcm.Seal(nonce, nonce, data, nil)
+	return m, nil
+}
+
+// Data decrypts the data of a Secret with the key it was stored with.
+func (m *SecretMetadata) Data(key []byte) (map[string][]byte, error) {
+	if len(m.EncryptedData) == 0 {
+		return nil, errors.New("secret data was not stored")
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.EncryptedData) < gcm.NonceSize() {
+		return nil, errors.New("malformed secret data")
+	}
+	nonce, sealed := m.EncryptedData[:gcm.NonceSize()], m.EncryptedData[gcm.NonceSize():]
+	data, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt secret data, wrong key?")
+	}
+	result := map[string][]byte{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// newGCM derives the AES-256 key from the sha256 of a key of any length.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// WriteMetadata stores the metadata of a backup in the folder location of the backend.
+func WriteMetadata(client kubernetes.Interface, backend store.Backend, namespace, location string, m *Metadata) error {
+	if backend.Local != nil {
+		return errors.New("writing to a local backend is not supported")
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	container, err := dial(client, backend, namespace)
+	if err != nil {
+		return err
+	}
+	_, err = container.Put(path.Join(location, MetadataFile), bytes.NewReader(data), int64(len(data)), nil)
+	return err
+}
+
+// ReadMetadata reads the metadata of a backup from the folder location of the backend.
+func ReadMetadata(client kubernetes.Interface, backend store.Backend, namespace, location string) (*Metadata, error) {
+	if backend.Local != nil {
+		return nil, errors.New("reading from a local backend is not supported")
+	}
+	container, err := dial(client, backend, namespace)
+	if err != nil {
+		return nil, err
+	}
+	item, err := container.Item(path.Join(location, MetadataFile))
+	if err != nil {
+		return nil, err
+	}
+	r, err := item.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	m := &Metadata{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Postgres == nil || m.PostgresVersion == nil {
+		return nil, errors.New("metadata is missing the Postgres or its version")
+	}
+	return m, nil
+}
@@ -0,0 +1,89 @@
+package archive
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	catalog "github.com/kubedb/apimachinery/apis/catalog/v1alpha1"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func authSecret() *core.Secret {
+	return &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo-auth"},
+		Data: map[string][]byte{
+			"POSTGRES_USER":     []byte("postgres"),
+			"POSTGRES_PASSWORD": []byte("secret"),
+		},
+	}
+}
+
+func TestSecretMetadata(t *testing.T) {
+	secret := authSecret()
+	m, err := NewSecretMetadata(secret, []byte("backup-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"POSTGRES_PASSWORD", "POSTGRES_USER"}; !reflect.DeepEqual(m.Keys, want) {
+		t.Errorf("expected keys %v, got %v", want, m.Keys)
+	}
+	data, err := m.Data([]byte("backup-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, secret.Data) {
+		t.Errorf("expected data %v, got %v", secret.Data, data)
+	}
+	if _, err := m.Data([]byte("wrong-key")); err == nil {
+		t.Error("expected an error for a wrong key")
+	}
+}
+
+func TestSecretMetadataWithoutKey(t *testing.T) {
+	m, err := NewSecretMetadata(authSecret(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.EncryptedData) != 0 {
+		t.Error("expected no data to be stored without a key")
+	}
+	if _, err := m.Data([]byte("backup-key")); err == nil {
+		t.Error("expected an error for data that was not stored")
+	}
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	secret, err := NewSecretMetadata(authSecret(), []byte("backup-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Metadata{
+		Postgres: &api.Postgres{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "default"},
+			Spec:       api.PostgresSpec{Version: "10.2-v2"},
+		},
+		PostgresVersion: &catalog.PostgresVersion{
+			ObjectMeta: metav1.ObjectMeta{Name: "10.2-v2"},
+			Spec:       catalog.PostgresVersionSpec{Version: "10.2"},
+		},
+		BaseBackup: "base_000000010000000000000004",
+		Secret:     secret,
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := &Metadata{}
+	if err := json.Unmarshal(data, read); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, m) {
+		t.Errorf("expected %+v, got %+v", m, read)
+	}
+	if _, err := read.Secret.Data([]byte("backup-key")); err != nil {
+		t.Errorf("failed to decrypt the secret data read back: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package archive
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/graymeta/stow"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	"k8s.io/client-go/kubernetes"
+	store "kmodules.xyz/objectstore-api/api/v1"
+)
+
+// Layout of a Snapshot in directory format. Every database is dumped with pg_dump -Fd into its own directory.
+const (
+	SnapshotDatabasesDir = "databases"
+	snapshotTOCFile      = "toc.dat"
+
+	snapshotDumpFile       = "dumpfile.sql"
+	snapshotBaseBackupFile = "base.tar.gz"
+	encryptedFileSuffix    = ".gpg"
+)
+
+// SnapshotFolder is the data of a Snapshot found in a backend.
+type SnapshotFolder struct {
+	// Namespace, Postgres and name of the Snapshot the data was taken for
+	Namespace string
+	Postgres  string
+	Name      string
+	// Folder of all Snapshots of the Postgres, "<prefix>/kubedb/<namespace>/<postgres>"
+	Location string
+	// A physical Snapshot holds a base backup, a logical one a sql dump or the databases in directory format
+	Physical  bool
+	Directory bool
+	// Whether the backup metadata is stored with the Snapshot
+	Metadata bool
+	// Upload times of the oldest and the newest file
+	StartTime      time.Time
+	CompletionTime time.Time
+
+	hasData bool
+}
+
+// SnapshotDatabases lists the databases of a Snapshot in directory format stored under location in the backend.
+func SnapshotDatabases(client kubernetes.Interface, backend store.Backend, namespace, location string) ([]string, error) {
+	if backend.Local != nil {
+		return nil, errors.New("listing a local snapshot is not supported")
+	}
+	container, err := dial(client, backend, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var databases []string
+	prefix := path.Join(location, SnapshotDatabasesDir) + "/"
+	err = walk(container, prefix, func(item stow.Item) error {
+		if database, ok := snapshotDatabase(strings.TrimPrefix(item.Name(), prefix)); ok {
+			databases = append(databases, database)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(databases)
+	return databases, nil
+}
+
+// snapshotDatabase returns the database of a file in the databases folder of a Snapshot, if the file is
+// the table of contents of the database, "<database>/toc.dat" or "<database>/toc.dat.gpg" if encrypted.
+func snapshotDatabase(file string) (string, bool) {
+	dir, file := path.Split(strings.TrimSuffix(file, encryptedFileSuffix))
+	if file != snapshotTOCFile || strings.Count(dir, "/") != 1 {
+		return "", false
+	}
+	return strings.TrimSuffix(dir, "/"), true
+}
+
+// ListSnapshots finds the Snapshots stored under "<prefix>/kubedb" in a backend, sorted by location and name.
+// A folder is a Snapshot, if it holds a sql dump, a base backup or databases in directory format.
+func ListSnapshots(client kubernetes.Interface, backend store.Backend, namespace string) ([]*SnapshotFolder, error) {
+	if backend.Local != nil {
+		return nil, errors.New("listing a local backend is not supported")
+	}
+	container, err := dial(client, backend, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	root := path.Join(Prefix(backend), api.DatabaseNamePrefix) + "/"
+	folders := map[string]*SnapshotFolder{}
+	err = walk(container, root, func(item stow.Item) error {
+		// <namespace>/<postgres>/<snapshot>/<file>
+		parts := strings.SplitN(strings.TrimPrefix(item.Name(), root), "/", 4)
+		if len(parts) < 4 {
+			return nil
+		}
+		key := path.Join(parts[:3]...)
+		folder, ok := folders[key]
+		if !ok {
+			folder = &SnapshotFolder{
+				Namespace: parts[0],
+				Postgres:  parts[1],
+				Name:      parts[2],
+				Location:  path.Join(strings.TrimSuffix(root, "/"), parts[0], parts[1]),
+			}
+			folders[key] = folder
+		}
+		switch file := strings.TrimSuffix(parts[3], encryptedFileSuffix); {
+		case file == MetadataFile:
+			folder.Metadata = true
+			return nil
+		case file == snapshotBaseBackupFile:
+			folder.Physical = true
+		case strings.HasPrefix(file, SnapshotDatabasesDir+"/"):
+			folder.Directory = true
+		case file != snapshotDumpFile:
+			return nil
+		}
+		folder.hasData = true
+		if t, err := item.LastMod(); err == nil {
+			if folder.StartTime.IsZero() || t.Before(folder.StartTime) {
+				folder.StartTime = t
+			}
+			if t.After(folder.CompletionTime) {
+				folder.CompletionTime = t
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var found []*SnapshotFolder
+	for _, folder := range folders {
+		if folder.hasData {
+			found = append(found, folder)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Location != found[j].Location {
+			return found[i].Location < found[j].Location
+		}
+		return found[i].Name < found[j].Name
+	})
+	return found, nil
+}
+
+// DeleteSnapshot removes the data of a Snapshot stored under location in the backend.
+func DeleteSnapshot(client kubernetes.Interface, backend store.Backend, namespace, location string) error {
+	if backend.Local != nil {
+		return errors.New("deleting from a local backend is not supported")
+	}
+	container, err := dial(client, backend, namespace)
+	if err != nil {
+		return err
+	}
+	// A separator after the location prevents matching Snapshots with the same name prefix.
+	return walk(container, strings.TrimSuffix(location, "/")+"/", func(item stow.Item) error {
+		return container.RemoveItem(item.ID())
+	})
+}
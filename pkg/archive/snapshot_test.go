@@ -0,0 +1,23 @@
+package archive
+
+import "testing"
+
+func TestSnapshotDatabase(t *testing.T) {
+	for _, c := range []struct {
+		file     string
+		database string
+		ok       bool
+	}{
+		{"postgres/toc.dat", "postgres", true},
+		{"postgres/toc.dat.gpg", "postgres", true},
+		{"postgres/3001.dat.gz", "", false},
+		{"postgres/3001.dat.gz.gpg", "", false},
+		{"toc.dat", "", false},
+		{"postgres/nested/toc.dat", "", false},
+	} {
+		database, ok := snapshotDatabase(c.file)
+		if database != c.database || ok != c.ok {
+			t.Errorf("%s: expected %q, %v, got %q, %v", c.file, c.database, c.ok, database, ok)
+		}
+	}
+}
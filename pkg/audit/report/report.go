@@ -7,6 +7,7 @@ import (
 
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	cs "github.com/kubedb/apimachinery/client/clientset/versioned"
+	"github.com/kubedb/postgres/pkg/audit/summary"
 	"github.com/kubedb/postgres/pkg/controller"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -49,36 +50,10 @@ func ExportReport(
 	host := fmt.Sprintf("%v.%v", kubedbName, namespace)
 	port := controller.PostgresPort
 
-	databases := make([]string, 0)
-	if dbname == "" {
-		engine, err := newXormEngine(username, password, host, port, "postgres")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		databases, err = getAllDatabase(engine)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	} else {
-		databases = append(databases, dbname)
-	}
-
-	pgSummary := make(map[string]*api.PostgresSummary)
-	for _, db := range databases {
-		engine, err := newXormEngine(username, password, host, port, db)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		info, err := getDataFromDB(engine)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		pgSummary[db] = info
+	pgSummary, err := summary.Collect(username, password, host, port, dbname)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	completionTime := metav1.Now()
@@ -1,4 +1,4 @@
-package report
+package summary
 
 import (
 	"fmt"
@@ -12,6 +12,37 @@ import (
 	pg "github.com/lib/pq"
 )
 
+// Collect returns the audit summary of a database, or of all databases if dbname is empty.
+func Collect(username, password, host string, port int, dbname string) (map[string]*api.PostgresSummary, error) {
+	databases := make([]string, 0)
+	if dbname == "" {
+		engine, err := newXormEngine(username, password, host, port, "postgres")
+		if err != nil {
+			return nil, err
+		}
+		databases, err = getAllDatabase(engine)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		databases = append(databases, dbname)
+	}
+
+	pgSummary := make(map[string]*api.PostgresSummary)
+	for _, db := range databases {
+		engine, err := newXormEngine(username, password, host, port, db)
+		if err != nil {
+			return nil, err
+		}
+		info, err := getDataFromDB(engine)
+		if err != nil {
+			return nil, err
+		}
+		pgSummary[db] = info
+	}
+	return pgSummary, nil
+}
+
 func newXormEngine(username, password, host string, port int, dbName string) (*xorm.Engine, error) {
 	cnnstr := fmt.Sprintf("user=%v password=%v host=%v port=%v dbname=%v sslmode=disable",
 		username, password, host, port, dbName)
@@ -76,7 +107,7 @@ func getDataFromDB(engine *xorm.Engine) (*api.PostgresSummary, error) {
 func getDataFromSchema(session *xorm.Session, schemaName string) (*api.PostgresSchemaInfo, error) {
 	tableRowSlice, err := session.Query("SELECT tablename FROM pg_tables where schemaname=$1", schemaName)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
 
 	schemaInfo := &api.PostgresSchemaInfo{
@@ -0,0 +1,50 @@
+package cmds
+
+import (
+	"io"
+
+	"github.com/appscode/go/log"
+	cs "github.com/kubedb/apimachinery/client/clientset/versioned"
+	"github.com/kubedb/postgres/pkg/restore"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func NewCmdImport(out io.Writer) *cobra.Command {
+	var (
+		kubeconfigPath string
+		masterURL      string
+		opt            = restore.ImportOptions{
+			EnableStatusSubresource: true,
+		}
+	)
+
+	cmd := &cobra.Command{
+		Use:               "import-snapshots",
+		Short:             "Create Snapshots for the Snapshot data in a backend",
+		Long:              "Scan the backend prefix, as in spec.backupSchedule, for Snapshot data and create a succeeded Snapshot for each, so that a Postgres can be initialized from it.",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if err := restore.Import(kubernetes.NewForConfigOrDie(config), cs.NewForConfigOrDie(config), opt, out); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	cmd.Flags().StringVar(&opt.BackendFile, "backend", opt.BackendFile, "Path to a YAML file with the backend the Snapshots were taken to")
+	cmd.Flags().StringVar(&opt.Namespace, "namespace", opt.Namespace, "Namespace of the imported Snapshots and of the storage secret")
+	cmd.Flags().StringVar(&opt.FromNamespace, "from-namespace", opt.FromNamespace, "Only import Snapshots taken in this namespace")
+	cmd.Flags().StringVar(&opt.FromPostgres, "from-postgres", opt.FromPostgres, "Only import Snapshots of this Postgres")
+	cmd.Flags().StringVar(&opt.Postgres, "postgres", opt.Postgres, "Name of the Postgres the imported Snapshots belong to, the original name if empty")
+	cmd.Flags().BoolVar(&opt.DryRun, "dry-run", opt.DryRun, "Print the Snapshots as JSON instead of creating them")
+	cmd.Flags().BoolVar(&opt.EnableStatusSubresource, "enable-status-subresource", opt.EnableStatusSubresource, "If true, uses sub resource for KubeDB crds.")
+	cmd.MarkFlagRequired("backend")
+	return cmd
+}
@@ -0,0 +1,42 @@
+package cmds
+
+import (
+	"os"
+
+	"github.com/appscode/go/log"
+	"github.com/kubedb/postgres/pkg/archive"
+	"github.com/spf13/cobra"
+)
+
+func NewCmdBackupKeyring() *cobra.Command {
+	var (
+		keysDir string
+		out     string
+	)
+
+	cmd := &cobra.Command{
+		Use:               "backup-keyring",
+		Short:             "Combine the backup encryption keys into a keyring for wal-g",
+		Long:              "Combine the armored OpenPGP keys of a mounted backup encryption Secret into a single armored keyring, so that wal-g decrypts an archive that was encrypted before a key rotation.",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			f, err := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if err := archive.WriteKeyring(keysDir, f); err != nil {
+				f.Close()
+				log.Fatalln(err)
+			}
+			if err := f.Close(); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&keysDir, "keys", keysDir, "Directory with the armored OpenPGP keys of the backup encryption Secret")
+	cmd.Flags().StringVar(&out, "out", out, "File to write the armored keyring to")
+	cmd.MarkFlagRequired("keys")
+	cmd.MarkFlagRequired("out")
+	return cmd
+}
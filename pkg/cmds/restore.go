@@ -0,0 +1,50 @@
+package cmds
+
+import (
+	"io"
+
+	"github.com/appscode/go/log"
+	cs "github.com/kubedb/apimachinery/client/clientset/versioned"
+	"github.com/kubedb/postgres/pkg/restore"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func NewCmdRestore(out io.Writer) *cobra.Command {
+	var (
+		kubeconfigPath string
+		masterURL      string
+		opt            = restore.Options{
+			EnableStatusSubresource: true,
+		}
+	)
+
+	cmd := &cobra.Command{
+		Use:               "restore",
+		Short:             "Rebuild a Postgres from the metadata stored with its backups",
+		Long:              "Rebuild a Postgres from the metadata stored with a Snapshot or in a wal archive. The backend prefix points to the Snapshot folder or to the wal archive.",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if err := restore.Restore(kubernetes.NewForConfigOrDie(config), cs.NewForConfigOrDie(config), opt, out); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	cmd.Flags().StringVar(&opt.BackendFile, "backend", opt.BackendFile, "Path to a YAML file with the backend of the Snapshot folder or wal archive")
+	cmd.Flags().StringVar(&opt.Namespace, "namespace", opt.Namespace, "Namespace of the rebuilt Postgres and of the storage secret")
+	cmd.Flags().StringVar(&opt.Name, "name", opt.Name, "Name of the rebuilt Postgres, the original name if empty")
+	cmd.Flags().StringVar(&opt.MetadataKeyFile, "metadata-key-file", opt.MetadataKeyFile, "Path to the key the auth secret was stored encrypted with")
+	cmd.Flags().StringVar(&opt.TargetTime, "target-time", opt.TargetTime, "Point in time to recover a wal archive to")
+	cmd.Flags().BoolVar(&opt.DryRun, "dry-run", opt.DryRun, "Print the objects as JSON instead of creating them")
+	cmd.Flags().BoolVar(&opt.EnableStatusSubresource, "enable-status-subresource", opt.EnableStatusSubresource, "If true, uses sub resource for KubeDB crds.")
+	cmd.MarkFlagRequired("backend")
+	return cmd
+}
@@ -36,6 +36,10 @@ func NewRootCmd(version string) *cobra.Command {
 
 	rootCmd.AddCommand(v.NewCmdVersion())
 	rootCmd.AddCommand(NewCmdLeaderElection())
+	rootCmd.AddCommand(NewCmdRestore(os.Stdout))
+	rootCmd.AddCommand(NewCmdImport(os.Stdout))
+	rootCmd.AddCommand(NewCmdVolumeSnapshot())
+	rootCmd.AddCommand(NewCmdBackupKeyring())
 
 	stopCh := genericapiserver.SetupSignalHandler()
 	rootCmd.AddCommand(NewCmdRun(version, os.Stdout, os.Stderr, stopCh))
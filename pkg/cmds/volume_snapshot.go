@@ -0,0 +1,50 @@
+package cmds
+
+import (
+	"time"
+
+	"github.com/appscode/go/log"
+	"github.com/kubedb/postgres/pkg/volumesnapshot"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func NewCmdVolumeSnapshot() *cobra.Command {
+	var (
+		kubeconfigPath string
+		masterURL      string
+		opt            = volumesnapshot.Options{
+			Timeout: 30 * time.Minute,
+		}
+	)
+
+	cmd := &cobra.Command{
+		Use:               "volume-snapshot",
+		Short:             "Take a VolumeSnapshot of the data volume of a database pod",
+		Long:              "Take a crash-consistent CSI VolumeSnapshot of the data volume of a database pod.",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if err := volumesnapshot.Take(dynamic.NewForConfigOrDie(config), opt); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	cmd.Flags().StringVar(&opt.Namespace, "namespace", opt.Namespace, "Namespace of the claim and of the VolumeSnapshot")
+	cmd.Flags().StringVar(&opt.Name, "name", opt.Name, "Name of the VolumeSnapshot")
+	cmd.Flags().StringVar(&opt.Claim, "claim", opt.Claim, "PersistentVolumeClaim with the data directory")
+	cmd.Flags().StringVar(&opt.Class, "class", opt.Class, "VolumeSnapshotClass, the default class if empty")
+	cmd.Flags().StringToStringVar(&opt.Labels, "labels", opt.Labels, "Labels of the VolumeSnapshot")
+	cmd.Flags().DurationVar(&opt.Timeout, "timeout", opt.Timeout, "Time to wait for the VolumeSnapshot to be ready to use")
+	cmd.MarkFlagRequired("namespace")
+	cmd.MarkFlagRequired("name")
+	cmd.MarkFlagRequired("claim")
+	return cmd
+}
@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/appscode/go/log"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	"github.com/kubedb/postgres/pkg/archive"
+	le "github.com/kubedb/postgres/pkg/leader_election"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"kmodules.xyz/client-go/tools/queue"
+)
+
+const (
+	// Backup status of a Postgres, recorded in its status ConfigMap. Times are RFC3339.
+	LastSnapshotKey           = "last-snapshot"
+	LastSnapshotTimeKey       = "last-snapshot-time"
+	LastFailedSnapshotKey     = "last-failed-snapshot"
+	LastFailedSnapshotTimeKey = "last-failed-snapshot-time"
+	// BackupFresh condition, "True" or "False", of a Postgres with a recovery point objective.
+	// The transition time is the last time the condition changed.
+	BackupFreshKey               = "backup-fresh"
+	BackupFreshReasonKey         = "backup-fresh-reason"
+	BackupFreshTransitionTimeKey = "backup-fresh-transition-time"
+
+	eventReasonBackupFresh = "BackupFresh"
+	eventReasonBackupStale = "BackupStale"
+
+	backupStatusPeriod = time.Minute
+)
+
+// initBackupStatusWatcher publishes the backup status of a Postgres every time one of its Snapshots completes.
+func (c *Controller) initBackupStatusWatcher() {
+	c.backupStatusQueue = queue.New("BackupStatus", c.MaxNumRequeues, c.NumThreads, c.runBackupStatus)
+	c.SnapInformer.AddEventHandler(queue.NewFilteredHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSnapshot, ok1 := oldObj.(*api.Snapshot)
+			newSnapshot, ok2 := newObj.(*api.Snapshot)
+			if !ok1 || !ok2 {
+				return
+			}
+			if oldSnapshot.Status.Phase != newSnapshot.Status.Phase &&
+				(newSnapshot.Status.Phase == api.SnapshotPhaseSucceeded || newSnapshot.Status.Phase == api.SnapshotPhaseFailed) {
+				c.backupStatusQueue.GetQueue().Add(newSnapshot.Namespace + "/" + newSnapshot.Spec.DatabaseName)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if snapshot, ok := obj.(*api.Snapshot); ok {
+				c.backupStatusQueue.GetQueue().Add(snapshot.Namespace + "/" + snapshot.Spec.DatabaseName)
+			}
+		},
+	}, c.selector))
+}
+
+// publishBackupFreshness re-evaluates the BackupFresh condition of every Postgres with a recovery point objective,
+// as backups turn stale without any Snapshot or archive event.
+func (c *Controller) publishBackupFreshness() {
+	postgreses, err := c.pgLister.List(labels.Everything())
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	for _, postgres := range postgreses {
+		if _, found := postgres.Annotations[validator.BackupRPOAnnotation]; found && postgres.DeletionTimestamp == nil {
+			queue.Enqueue(c.backupStatusQueue.GetQueue(), postgres)
+		}
+	}
+}
+
+func (c *Controller) runBackupStatus(key string) error {
+	log.Debugln("started processing backup status, key:", key)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	postgres, err := c.pgLister.Postgreses(namespace).Get(name)
+	if kerr.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if postgres.DeletionTimestamp != nil {
+		return nil
+	}
+
+	objs, err := c.SnapInformer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		return err
+	}
+	var lastSucceeded, lastFailed *api.Snapshot
+	for _, obj := range objs {
+		snapshot := obj.(*api.Snapshot)
+		if snapshot.Spec.DatabaseName != postgres.Name || snapshot.DeletionTimestamp != nil || snapshot.Status.CompletionTime == nil {
+			continue
+		}
+		switch snapshot.Status.Phase {
+		case api.SnapshotPhaseSucceeded:
+			if lastSucceeded == nil || lastSucceeded.Status.CompletionTime.Before(snapshot.Status.CompletionTime) {
+				lastSucceeded = snapshot
+			}
+		case api.SnapshotPhaseFailed:
+			if lastFailed == nil || lastFailed.Status.CompletionTime.Before(snapshot.Status.CompletionTime) {
+				lastFailed = snapshot
+			}
+		}
+	}
+
+	current, err := le.GetStatus(c.Client, postgres.Namespace, postgres.OffshootName())
+	if kerr.IsNotFound(err) {
+		// published once the database has been created
+		return nil
+	} else if err != nil {
+		return err
+	}
+	status := backupStatus(lastSucceeded, lastFailed, current, postgres.Annotations[validator.BackupRPOAnnotation], time.Now())
+	if err := le.UpdateStatus(c.Client, postgres.Namespace, postgres.OffshootName(), status); err != nil {
+		return err
+	}
+
+	if status[BackupFreshKey] != "" && status[BackupFreshKey] != current[BackupFreshKey] {
+		switch status[BackupFreshKey] {
+		case string(core.ConditionTrue):
+			c.recorder.Event(postgres, core.EventTypeNormal, eventReasonBackupFresh, "Backups are fresh, "+status[BackupFreshReasonKey])
+		case string(core.ConditionFalse):
+			c.recorder.Event(postgres, core.EventTypeWarning, eventReasonBackupStale, "Backups are stale, "+status[BackupFreshReasonKey])
+		}
+	}
+	return nil
+}
+
+// backupStatus returns the backup status entries of a Postgres from its last Snapshots and the current status,
+// which holds the archive window. Entries that do not apply have an empty value.
+func backupStatus(lastSucceeded, lastFailed *api.Snapshot, current map[string]string, rpo string, now time.Time) map[string]string {
+	status := map[string]string{
+		LastSnapshotKey:              "",
+		LastSnapshotTimeKey:          "",
+		LastFailedSnapshotKey:        "",
+		LastFailedSnapshotTimeKey:    "",
+		BackupFreshKey:               "",
+		BackupFreshReasonKey:         "",
+		BackupFreshTransitionTimeKey: "",
+	}
+	var newest time.Time
+	if lastSucceeded != nil {
+		newest = lastSucceeded.Status.CompletionTime.Time
+		status[LastSnapshotKey] = lastSucceeded.Name
+		status[LastSnapshotTimeKey] = newest.UTC().Format(time.RFC3339)
+	}
+	if lastFailed != nil {
+		status[LastFailedSnapshotKey] = lastFailed.Name
+		status[LastFailedSnapshotTimeKey] = lastFailed.Status.CompletionTime.UTC().Format(time.RFC3339)
+	}
+	// The wal archive can recover up to its last archived segment.
+	for _, key := range []string{archive.LastBaseBackupTimeKey, archive.RecoverableUntilKey} {
+		if t, err := time.Parse(time.RFC3339, current[key]); err == nil && t.After(newest) {
+			newest = t
+		}
+	}
+
+	d, err := time.ParseDuration(rpo)
+	if err != nil {
+		return status
+	}
+	switch {
+	case newest.IsZero():
+		status[BackupFreshKey] = string(core.ConditionFalse)
+		status[BackupFreshReasonKey] = "no backup found"
+	case now.Sub(newest) > d:
+		status[BackupFreshKey] = string(core.ConditionFalse)
+		status[BackupFreshReasonKey] = fmt.Sprintf("newest backup from %v is older than %v", newest.UTC().Format(time.RFC3339), d)
+	default:
+		status[BackupFreshKey] = string(core.ConditionTrue)
+		status[BackupFreshReasonKey] = fmt.Sprintf("newest backup from %v is within %v", newest.UTC().Format(time.RFC3339), d)
+	}
+	if status[BackupFreshKey] != current[BackupFreshKey] || current[BackupFreshTransitionTimeKey] == "" {
+		status[BackupFreshTransitionTimeKey] = now.UTC().Format(time.RFC3339)
+	} else {
+		status[BackupFreshTransitionTimeKey] = current[BackupFreshTransitionTimeKey]
+	}
+	return status
+}
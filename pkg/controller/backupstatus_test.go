@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	"github.com/kubedb/postgres/pkg/archive"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func completedSnapshot(name string, completion time.Time) *api.Snapshot {
+	return &api.Snapshot{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: api.SnapshotStatus{
+			CompletionTime: &metav1.Time{Time: completion},
+		},
+	}
+}
+
+func TestBackupStatus(t *testing.T) {
+	now := time.Date(2019, 5, 6, 12, 0, 0, 0, time.UTC)
+	earlier := now.Add(-time.Hour).Format(time.RFC3339)
+
+	for _, c := range []struct {
+		name          string
+		lastSucceeded *api.Snapshot
+		lastFailed    *api.Snapshot
+		current       map[string]string
+		rpo           string
+		fresh         string
+		transition    string
+	}{
+		{"no rpo", completedSnapshot("foo-1", now.Add(-time.Hour)), nil, nil, "", "", ""},
+		{"no backup", nil, nil, nil, "24h", string(core.ConditionFalse), now.Format(time.RFC3339)},
+		{"fresh snapshot", completedSnapshot("foo-1", now.Add(-time.Hour)), nil, nil, "2h", string(core.ConditionTrue), now.Format(time.RFC3339)},
+		{"stale snapshot", completedSnapshot("foo-1", now.Add(-3*time.Hour)), nil, nil, "2h", string(core.ConditionFalse), now.Format(time.RFC3339)},
+		{"fresh archive", completedSnapshot("foo-1", now.Add(-3*time.Hour)), nil, map[string]string{
+			archive.RecoverableUntilKey: now.Add(-time.Minute).Format(time.RFC3339),
+		}, "2h", string(core.ConditionTrue), now.Format(time.RFC3339)},
+		{"still fresh", completedSnapshot("foo-1", now.Add(-time.Hour)), nil, map[string]string{
+			BackupFreshKey:               string(core.ConditionTrue),
+			BackupFreshTransitionTimeKey: earlier,
+		}, "2h", string(core.ConditionTrue), earlier},
+		{"turned stale", completedSnapshot("foo-1", now.Add(-3*time.Hour)), completedSnapshot("foo-2", now.Add(-time.Hour)), map[string]string{
+			BackupFreshKey:               string(core.ConditionTrue),
+			BackupFreshTransitionTimeKey: earlier,
+		}, "2h", string(core.ConditionFalse), now.Format(time.RFC3339)},
+	} {
+		status := backupStatus(c.lastSucceeded, c.lastFailed, c.current, c.rpo, now)
+		if status[BackupFreshKey] != c.fresh {
+			t.Errorf("%s: expected backup fresh %q, got %q (%v)", c.name, c.fresh, status[BackupFreshKey], status[BackupFreshReasonKey])
+		}
+		if status[BackupFreshTransitionTimeKey] != c.transition {
+			t.Errorf("%s: expected transition time %q, got %q", c.name, c.transition, status[BackupFreshTransitionTimeKey])
+		}
+		if c.lastSucceeded != nil && status[LastSnapshotKey] != c.lastSucceeded.Name {
+			t.Errorf("%s: expected last snapshot %q, got %q", c.name, c.lastSucceeded.Name, status[LastSnapshotKey])
+		}
+		if (c.lastFailed == nil) != (status[LastFailedSnapshotKey] == "") {
+			t.Errorf("%s: unexpected last failed snapshot %q", c.name, status[LastFailedSnapshotKey])
+		}
+	}
+}
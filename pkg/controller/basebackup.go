@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"fmt"
+
+	catalog "github.com/kubedb/apimachinery/apis/catalog/v1alpha1"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	"github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	"github.com/kubedb/apimachinery/pkg/eventer"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/reference"
+	kutil "kmodules.xyz/client-go"
+	core_util "kmodules.xyz/client-go/core/v1"
+	meta_util "kmodules.xyz/client-go/meta"
+	storage "kmodules.xyz/objectstore-api/osm"
+)
+
+const (
+	// Type of a Snapshot, "logical" (default), "physical" or "volume". A logical Snapshot is a pg_dumpall of the database.
+	// A physical Snapshot is a pg_basebackup taken from a replica and is restored by placing the data directory
+	// before the database starts. A volume Snapshot is a CSI VolumeSnapshot of the data volume of a replica and
+	// is restored by provisioning the data volumes from it. Set on a Snapshot, or on a Postgres as default for
+	// all of its Snapshots.
+	SnapshotTypeAnnotation = api.PostgresKey + "/snapshot-type"
+
+	SnapshotTypeLogical  = "logical"
+	SnapshotTypePhysical = "physical"
+	SnapshotTypeVolume   = "volume"
+
+	restoreBaseBackupContainer = "restore-snapshot"
+)
+
+// snapshotType returns the type of a Snapshot, falling back to the default of its Postgres.
+func snapshotType(snapshot *api.Snapshot, postgres *api.Postgres) string {
+	if t := snapshot.Annotations[SnapshotTypeAnnotation]; t != "" {
+		return t
+	}
+	if postgres != nil {
+		if t := postgres.Annotations[SnapshotTypeAnnotation]; t != "" {
+			return t
+		}
+	}
+	return SnapshotTypeLogical
+}
+
+func validateSnapshotType(snapshotType string) error {
+	if snapshotType != SnapshotTypeLogical && snapshotType != SnapshotTypePhysical && snapshotType != SnapshotTypeVolume {
+		return fmt.Errorf(`annotation "%v" must be "%v", "%v" or "%v", found "%v"`,
+			SnapshotTypeAnnotation, SnapshotTypeLogical, SnapshotTypePhysical, SnapshotTypeVolume, snapshotType)
+	}
+	return nil
+}
+
+// getPhysicalInitSnapshot returns the Snapshot a Postgres is initialized from, if it is physical
+// and the Postgres has not been initialized yet.
+func (c *Controller) getPhysicalInitSnapshot(postgres *api.Postgres) (*api.Snapshot, error) {
+	if _, err := meta_util.GetString(postgres.Annotations, api.AnnotationInitialized); err != kutil.ErrNotFound {
+		return nil, nil
+	}
+	if postgres.Spec.Init == nil || postgres.Spec.Init.SnapshotSource == nil {
+		return nil, nil
+	}
+	snapshotSource := postgres.Spec.Init.SnapshotSource
+	namespace := snapshotSource.Namespace
+	if namespace == "" {
+		namespace = postgres.Namespace
+	}
+	snapshot, err := c.ExtClient.KubedbV1alpha1().Snapshots(namespace).Get(snapshotSource.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if snapshotType(snapshot, nil) != SnapshotTypePhysical {
+		return nil, nil
+	}
+	return snapshot, nil
+}
+
+func restoreBaseBackupSecretName(postgres *api.Postgres) string {
+	return postgres.OffshootName() + "-snapshot-restore"
+}
+
+// ensureRestoreBaseBackupSecret creates the osm config used by the restore init container.
+func (c *Controller) ensureRestoreBaseBackupSecret(postgres *api.Postgres, snapshot *api.Snapshot) error {
+	ref, err := reference.GetReference(clientsetscheme.Scheme, postgres)
+	if err != nil {
+		return err
+	}
+	secret, err := storage.NewOSMSecret(c.Client, restoreBaseBackupSecretName(postgres), snapshot.Namespace, snapshot.Spec.Backend)
+	if err != nil {
+		return err
+	}
+	meta := metav1.ObjectMeta{
+		Name:      secret.Name,
+		Namespace: postgres.Namespace,
+	}
+	_, _, err = core_util.CreateOrPatchSecret(c.Client, meta, func(in *core.Secret) *core.Secret {
+		core_util.EnsureOwnerReference(&in.ObjectMeta, ref)
+		in.Labels = core_util.UpsertMap(in.Labels, postgres.OffshootLabels())
+		in.Data = secret.Data
+		return in
+	})
+	return err
+}
+
+func (c *Controller) restoreBaseBackupArgs(snapshot *api.Snapshot) ([]string, error) {
+	bucket, err := snapshot.Spec.Backend.Container()
+	if err != nil {
+		return nil, err
+	}
+	folderName, err := snapshotLocation(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		"restore-basebackup",
+		fmt.Sprintf(`--bucket=%s`, bucket),
+		fmt.Sprintf(`--folder=%s`, folderName),
+		fmt.Sprintf(`--snapshot=%s`, snapshot.Name),
+		fmt.Sprintf(`--enable-analytics=%v`, c.EnableAnalytics),
+	}, nil
+}
+
+// upsertRestoreBaseBackup adds the init container that places the data directory of a physical Snapshot.
+// It runs in every pod, as the leader is not known in advance. The data is marked as restored for the run
+// script of the primary only, so that replicas replace it with a base backup of the primary.
+func upsertRestoreBaseBackup(
+	statefulSet *apps.StatefulSet,
+	postgres *api.Postgres,
+	postgresVersion *catalog.PostgresVersion,
+	snapshot *api.Snapshot,
+	args []string,
+) *apps.StatefulSet {
+	container := core.Container{
+		Name:            restoreBaseBackupContainer,
+		Image:           postgresVersion.Spec.Tools.Image,
+		ImagePullPolicy: core.PullIfNotPresent,
+		Args:            args,
+		Env: []core.EnvVar{
+			{
+				Name:  "PGDATA",
+				Value: "/var/pv/data",
+			},
+		},
+		VolumeMounts: []core.VolumeMount{
+			{
+				Name:      "data",
+				MountPath: "/var/pv",
+			},
+			{
+				Name:      "snapshot-data",
+				MountPath: "/var/data",
+			},
+			{
+				Name:      "snapshot-osmconfig",
+				MountPath: storage.SecretMountPath,
+				ReadOnly:  true,
+			},
+		},
+	}
+	volumes := []core.Volume{
+		{
+			Name: "snapshot-data",
+			VolumeSource: core.VolumeSource{
+				EmptyDir: &core.EmptyDirVolumeSource{},
+			},
+		},
+		{
+			Name: "snapshot-osmconfig",
+			VolumeSource: core.VolumeSource{
+				Secret: &core.SecretVolumeSource{
+					SecretName: restoreBaseBackupSecretName(postgres),
+				},
+			},
+		},
+	}
+	if snapshot.Spec.Backend.Local != nil {
+		container.VolumeMounts = append(container.VolumeMounts, core.VolumeMount{
+			Name:      "snapshot-local",
+			MountPath: snapshot.Spec.Backend.Local.MountPath,
+			SubPath:   snapshot.Spec.Backend.Local.SubPath,
+		})
+		volumes = append(volumes, core.Volume{
+			Name:         "snapshot-local",
+			VolumeSource: snapshot.Spec.Backend.Local.VolumeSource,
+		})
+	}
+	volumes = decryptSnapshot(&container, volumes, postgres)
+
+	statefulSet.Spec.Template.Spec.InitContainers = core_util.UpsertContainer(statefulSet.Spec.Template.Spec.InitContainers, container)
+	for _, volume := range volumes {
+		statefulSet.Spec.Template.Spec.Volumes = core_util.UpsertVolume(statefulSet.Spec.Template.Spec.Volumes, volume)
+	}
+	return statefulSet
+}
+
+// initializeFromBaseBackup marks a Postgres initialized from a physical Snapshot. The data directory
+// has been placed by the restore init container, once the database pods are running.
+func (c *Controller) initializeFromBaseBackup(postgres *api.Postgres, snapshot *api.Snapshot) error {
+	pg, _, err := util.PatchPostgres(c.ExtClient.KubedbV1alpha1(), postgres, func(in *api.Postgres) *api.Postgres {
+		in.Annotations = core_util.UpsertMap(in.Annotations, map[string]string{
+			api.AnnotationInitialized: "",
+		})
+		return in
+	})
+	if err != nil {
+		return err
+	}
+	postgres.Annotations = pg.Annotations
+	c.recorder.Eventf(
+		postgres,
+		core.EventTypeNormal,
+		eventer.EventReasonSuccessfulInitialize,
+		`Initialized from physical Snapshot "%v/%v"`,
+		snapshot.Namespace,
+		snapshot.Name,
+	)
+	return nil
+}
@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/appscode/go/log"
+	"github.com/appscode/go/types"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	"github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/reference"
+	kutil "kmodules.xyz/client-go"
+	core_util "kmodules.xyz/client-go/core/v1"
+	meta_util "kmodules.xyz/client-go/meta"
+)
+
+const (
+	// Source of a clone, resolved from the clone-from annotation when the clone is created.
+	cloneSourceAnnotation = api.PostgresKey + "/clone-source"
+
+	eventReasonCloning = "Cloning"
+)
+
+// cloneSource is what a clone is initialized from. Either WAL or Host is set.
+type cloneSource struct {
+	// WAL archive of the source Postgres
+	WAL *api.PostgresWALSourceSpec `json:"wal,omitempty"`
+	// Primary Service of the source Postgres, streamed with pg_basebackup
+	Host string `json:"host,omitempty"`
+	// Secret with the credentials for Host
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// ensureCloneSource resolves the source of a clone once and keeps it in an annotation, so that
+// the clone does not depend on the source Postgres after it has been created. The source is only
+// resolved before the first start, a Postgres that already has a database is never cloned into.
+func (c *Controller) ensureCloneSource(postgres *api.Postgres) error {
+	if _, found := postgres.Annotations[validator.CloneFromAnnotation]; !found {
+		return nil
+	}
+	if _, found := postgres.Annotations[cloneSourceAnnotation]; found {
+		return nil
+	}
+	if _, err := meta_util.GetString(postgres.Annotations, api.AnnotationInitialized); err != kutil.ErrNotFound {
+		return nil
+	}
+	if _, err := c.Client.AppsV1().StatefulSets(postgres.Namespace).Get(postgres.OffshootName(), metav1.GetOptions{}); err == nil {
+		log.Warningf("ignoring annotation %v of Postgres %s/%s, as its database already exists",
+			validator.CloneFromAnnotation, postgres.Namespace, postgres.Name)
+		return nil
+	} else if !kerr.IsNotFound(err) {
+		return err
+	}
+	source, err := c.resolveCloneSource(postgres)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(source)
+	if err != nil {
+		return err
+	}
+	pg, _, err := util.PatchPostgres(c.ExtClient.KubedbV1alpha1(), postgres, func(in *api.Postgres) *api.Postgres {
+		in.Annotations = core_util.UpsertMap(in.Annotations, map[string]string{
+			cloneSourceAnnotation: string(data),
+		})
+		return in
+	})
+	if err != nil {
+		return err
+	}
+	postgres.Annotations = pg.Annotations
+	return nil
+}
+
+// withCloneSource returns the Postgres to create the database pods from. A clone of an archived
+// Postgres is initialized from the wal archive of its source.
+func withCloneSource(postgres *api.Postgres) (*api.Postgres, error) {
+	source, err := getCloneSource(postgres)
+	if err != nil || source == nil || source.WAL == nil {
+		return postgres, err
+	}
+	clone := postgres.DeepCopy()
+	if clone.Spec.Init == nil {
+		clone.Spec.Init = &api.InitSpec{}
+	}
+	clone.Spec.Init.PostgresWAL = source.WAL
+	return clone, nil
+}
+
+func getCloneSource(postgres *api.Postgres) (*cloneSource, error) {
+	data, found := postgres.Annotations[cloneSourceAnnotation]
+	if !found {
+		return nil, nil
+	}
+	var source cloneSource
+	if err := json.Unmarshal([]byte(data), &source); err != nil {
+		return nil, fmt.Errorf(`failed to parse annotation "%v". Reason: %v`, cloneSourceAnnotation, err)
+	}
+	return &source, nil
+}
+
+func (c *Controller) resolveCloneSource(postgres *api.Postgres) (*cloneSource, error) {
+	namespace, name := validator.CloneSource(postgres)
+	source, err := c.ExtClient.KubedbV1alpha1().Postgreses(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// The clone uses the credentials of its source, unless it has its own.
+	if postgres.Spec.DatabaseSecret == nil && source.Spec.DatabaseSecret != nil {
+		if err := c.copyCloneSecret(postgres, namespace, source.Spec.DatabaseSecret.SecretName, fmt.Sprintf("%v-auth", postgres.OffshootName()), false); err != nil {
+			return nil, err
+		}
+	}
+
+	if source.Spec.Archiver != nil && source.Spec.Archiver.Storage != nil {
+		backend := source.Spec.Archiver.Storage.DeepCopy()
+		prefix := WalDataDir(source)
+		switch {
+		case backend.S3 != nil:
+			backend.S3.Prefix = prefix
+		case backend.GCS != nil:
+			backend.GCS.Prefix = prefix
+		case backend.Azure != nil:
+			backend.Azure.Prefix = prefix
+		case backend.Swift != nil:
+			backend.Swift.Prefix = prefix
+		case backend.Local != nil:
+			backend.Local.SubPath = prefix
+		}
+		if backend.StorageSecretName != "" && namespace != postgres.Namespace {
+			secretName := postgres.OffshootName() + "-clone-storage"
+			if err := c.copyCloneSecret(postgres, namespace, backend.StorageSecretName, secretName, true); err != nil {
+				return nil, err
+			}
+			backend.StorageSecretName = secretName
+		}
+
+		wal := &api.PostgresWALSourceSpec{Backend: *backend}
+		if targetTime := postgres.Annotations[validator.CloneTargetTimeAnnotation]; targetTime != "" {
+			wal.PITR = &api.RecoveryTarget{
+				TargetTime:      targetTime,
+				TargetInclusive: types.BoolP(true),
+			}
+		}
+		c.recorder.Eventf(
+			postgres,
+			core.EventTypeNormal,
+			eventReasonCloning,
+			`Cloning from wal archive of Postgres "%v/%v"`,
+			namespace,
+			name,
+		)
+		return &cloneSource{WAL: wal}, nil
+	}
+
+	if source.Spec.DatabaseSecret == nil {
+		return nil, fmt.Errorf(`postgres "%v/%v" has neither wal archive nor database secret to clone from`, namespace, name)
+	}
+	secretName := source.Spec.DatabaseSecret.SecretName
+	if namespace != postgres.Namespace {
+		secretName = postgres.OffshootName() + "-clone-auth"
+		if err := c.copyCloneSecret(postgres, namespace, source.Spec.DatabaseSecret.SecretName, secretName, true); err != nil {
+			return nil, err
+		}
+	}
+	c.recorder.Eventf(
+		postgres,
+		core.EventTypeNormal,
+		eventReasonCloning,
+		`Cloning from primary of Postgres "%v/%v"`,
+		namespace,
+		name,
+	)
+	return &cloneSource{
+		Host:       fmt.Sprintf("%v.%v", source.ServiceName(), namespace),
+		SecretName: secretName,
+	}, nil
+}
+
+// copyCloneSecret copies a Secret of the source into the namespace of the clone.
+// Copies only used to initialize the clone are owned by it, copied database Secrets
+// are labeled like the ones created by the operator.
+func (c *Controller) copyCloneSecret(postgres *api.Postgres, namespace, name, copyName string, owned bool) error {
+	secret, err := c.Client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	ref, err := reference.GetReference(clientsetscheme.Scheme, postgres)
+	if err != nil {
+		return err
+	}
+	meta := metav1.ObjectMeta{
+		Name:      copyName,
+		Namespace: postgres.Namespace,
+	}
+	if !owned {
+		if _, err := c.Client.CoreV1().Secrets(meta.Namespace).Get(meta.Name, metav1.GetOptions{}); err == nil {
+			return nil
+		} else if !kerr.IsNotFound(err) {
+			return err
+		}
+	}
+	_, _, err = core_util.CreateOrPatchSecret(c.Client, meta, func(in *core.Secret) *core.Secret {
+		if owned {
+			core_util.EnsureOwnerReference(&in.ObjectMeta, ref)
+		}
+		in.Labels = core_util.UpsertMap(in.Labels, postgres.OffshootLabels())
+		in.Type = secret.Type
+		in.Data = secret.Data
+		return in
+	})
+	return err
+}
+
+// cloneEnv returns the environment of a clone that is streamed from the primary of its source.
+func cloneEnv(postgres *api.Postgres) ([]core.EnvVar, error) {
+	source, err := getCloneSource(postgres)
+	if err != nil || source == nil || source.Host == "" {
+		return nil, err
+	}
+	return []core.EnvVar{
+		{
+			Name:  "CLONE_HOST",
+			Value: source.Host,
+		},
+		{
+			Name: "CLONE_USER",
+			ValueFrom: &core.EnvVarSource{
+				SecretKeyRef: &core.SecretKeySelector{
+					LocalObjectReference: core.LocalObjectReference{
+						Name: source.SecretName,
+					},
+					Key: PostgresUser,
+				},
+			},
+		},
+		{
+			Name: "CLONE_PASSWORD",
+			ValueFrom: &core.EnvVarSource{
+				SecretKeyRef: &core.SecretKeySelector{
+					LocalObjectReference: core.LocalObjectReference{
+						Name: source.SecretName,
+					},
+					Key: PostgresPassword,
+				},
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"testing"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	extFake "github.com/kubedb/apimachinery/client/clientset/versioned/fake"
+	amc "github.com/kubedb/apimachinery/pkg/controller"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	store "kmodules.xyz/objectstore-api/api/v1"
+)
+
+func TestResolveCloneSourceLocalArchive(t *testing.T) {
+	source := &api.Postgres{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bar",
+			Namespace: "demo",
+		},
+		Spec: api.PostgresSpec{
+			Archiver: &api.PostgresArchiverSpec{
+				Storage: &store.Backend{
+					Local: &store.LocalSpec{
+						MountPath: "/var/pv",
+						VolumeSource: core.VolumeSource{
+							PersistentVolumeClaim: &core.PersistentVolumeClaimVolumeSource{ClaimName: "archive"},
+						},
+					},
+				},
+			},
+		},
+	}
+	clone := &api.Postgres{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "demo",
+			Annotations: map[string]string{
+				validator.CloneFromAnnotation: "bar",
+			},
+		},
+	}
+	c := &Controller{
+		Controller: &amc.Controller{
+			Client:    fake.NewSimpleClientset(),
+			ExtClient: extFake.NewSimpleClientset(source),
+		},
+		recorder: record.NewFakeRecorder(10),
+	}
+
+	resolved, err := c.resolveCloneSource(clone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.WAL == nil || resolved.WAL.Local == nil {
+		t.Fatalf("expected a local wal source, got %+v", resolved)
+	}
+	if resolved.WAL.Local.SubPath != "kubedb/demo/bar/archive" {
+		t.Errorf("expected sub path %q, got %q", "kubedb/demo/bar/archive", resolved.WAL.Local.SubPath)
+	}
+	if resolved.WAL.Local.PersistentVolumeClaim == nil || resolved.WAL.Local.PersistentVolumeClaim.ClaimName != "archive" {
+		t.Errorf("expected the archive volume of the source, got %+v", resolved.WAL.Local.VolumeSource)
+	}
+}
+
+func TestEnsureCloneSourceOfExistingDatabase(t *testing.T) {
+	source := &api.Postgres{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bar",
+			Namespace: "demo",
+		},
+		Spec: api.PostgresSpec{
+			DatabaseSecret: &core.SecretVolumeSource{SecretName: "bar-auth"},
+		},
+	}
+	clone := &api.Postgres{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "demo",
+			Annotations: map[string]string{
+				validator.CloneFromAnnotation: "bar",
+			},
+		},
+	}
+	client := fake.NewSimpleClientset(&apps.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      clone.OffshootName(),
+			Namespace: clone.Namespace,
+		},
+	})
+	c := &Controller{
+		Controller: &amc.Controller{
+			Client:    client,
+			ExtClient: extFake.NewSimpleClientset(source, clone),
+		},
+		recorder: record.NewFakeRecorder(10),
+	}
+
+	if err := c.ensureCloneSource(clone); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := clone.Annotations[cloneSourceAnnotation]; found {
+		t.Errorf("clone source was resolved for a Postgres with an existing database")
+	}
+	if secrets, err := client.CoreV1().Secrets(clone.Namespace).List(metav1.ListOptions{}); err != nil {
+		t.Fatal(err)
+	} else if len(secrets.Items) != 0 {
+		t.Errorf("expected no copied Secrets, got %d", len(secrets.Items))
+	}
+}
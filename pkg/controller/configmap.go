@@ -1,14 +1,64 @@
 package controller
 
 import (
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	le "github.com/kubedb/postgres/pkg/leader_election"
+	core "k8s.io/api/core/v1"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/reference"
+	core_util "kmodules.xyz/client-go/core/v1"
 )
 
+// ensureLeaderLockConfigMap creates the leader lock before the pods, so that it carries
+// the offshoot labels the role watcher selects on.
+func (c *Controller) ensureLeaderLockConfigMap(postgres *api.Postgres) error {
+	ref, rerr := reference.GetReference(clientsetscheme.Scheme, postgres)
+	if rerr != nil {
+		return rerr
+	}
+	meta := metav1.ObjectMeta{
+		Name:      le.GetLeaderLockName(postgres.OffshootName()),
+		Namespace: postgres.Namespace,
+	}
+	_, _, err := core_util.CreateOrPatchConfigMap(c.Client, meta, func(in *core.ConfigMap) *core.ConfigMap {
+		core_util.EnsureOwnerReference(&in.ObjectMeta, ref)
+		in.Labels = core_util.UpsertMap(in.Labels, postgres.OffshootLabels())
+		return in
+	})
+	return err
+}
+
 func (c *Controller) deleteLeaderLockConfigMap(meta metav1.ObjectMeta) error {
 	if err := c.Client.CoreV1().ConfigMaps(meta.Namespace).Delete(le.GetLeaderLockName(meta.Name), nil); !kerr.IsNotFound(err) {
 		return err
 	}
 	return nil
 }
+
+// ensureStatusConfigMap creates the ConfigMap that the operator and the database pods record
+// the backup and archive status of a Postgres in.
+func (c *Controller) ensureStatusConfigMap(postgres *api.Postgres) error {
+	ref, rerr := reference.GetReference(clientsetscheme.Scheme, postgres)
+	if rerr != nil {
+		return rerr
+	}
+	meta := metav1.ObjectMeta{
+		Name:      le.GetStatusConfigMapName(postgres.OffshootName()),
+		Namespace: postgres.Namespace,
+	}
+	_, _, err := core_util.CreateOrPatchConfigMap(c.Client, meta, func(in *core.ConfigMap) *core.ConfigMap {
+		core_util.EnsureOwnerReference(&in.ObjectMeta, ref)
+		in.Labels = core_util.UpsertMap(in.Labels, postgres.OffshootLabels())
+		return in
+	})
+	return err
+}
+
+func (c *Controller) deleteStatusConfigMap(meta metav1.ObjectMeta) error {
+	if err := c.Client.CoreV1().ConfigMaps(meta.Namespace).Delete(le.GetStatusConfigMapName(meta.Name), nil); !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package controller
 
 import (
+	"time"
+
 	"github.com/appscode/go/encoding/json/types"
 	"github.com/appscode/go/log"
 	pcm "github.com/coreos/prometheus-operator/pkg/client/versioned/typed/monitoring/v1"
@@ -21,8 +23,10 @@ import (
 	crd_cs "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1"
 	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
@@ -52,6 +56,33 @@ type Controller struct {
 	pgQueue    *queue.Worker
 	pgInformer cache.SharedIndexInformer
 	pgLister   api_listers.PostgresLister
+
+	// Role labels of database pods
+	roleQueue    *queue.Worker
+	lockInformer cache.SharedIndexInformer
+	podInformer  cache.SharedIndexInformer
+	podLister    corelisters.PodLister
+
+	// Snapshot retention
+	retentionQueue *queue.Worker
+
+	// Databases of directory Snapshots
+	snapshotDatabasesQueue *queue.Worker
+
+	// Backup drills
+	drillQueue *queue.Worker
+
+	// Last backups and BackupFresh condition
+	backupStatusQueue *queue.Worker
+
+	// Backup metadata of Snapshots
+	snapshotMetadataQueue *queue.Worker
+
+	// Time the WAL segments of each archived Postgres were last listed
+	walListed map[string]time.Time
+
+	// Recovery targets outside of the wal archive of new Postgreses
+	recoveryTargets recoveryTargetChecks
 }
 
 var _ amc.Snapshotter = &Controller{}
@@ -108,6 +139,12 @@ func (c *Controller) EnsureCustomResourceDefinitions() error {
 // InitInformer initializes Postgres, DormantDB amd Snapshot watcher
 func (c *Controller) Init() error {
 	c.initWatcher()
+	c.initRoleWatcher()
+	c.initSnapshotRetentionWatcher()
+	c.initSnapshotDatabasesWatcher()
+	c.initBackupDrillWatcher()
+	c.initBackupStatusWatcher()
+	c.initSnapshotMetadataWatcher()
 	c.DrmnQueue = drmnc.NewController(c.Controller, c, c.Config, nil, c.recorder).AddEventHandlerFunc(c.selector)
 	c.SnapQueue, c.JobQueue = snapc.NewController(c.Controller, c, c.Config, nil, c.recorder).AddEventHandlerFunc(c.selector)
 	c.RSQueue = restoresession.NewController(c.Controller, c, c.Config, nil, c.recorder).AddEventHandlerFunc(c.selector)
@@ -122,6 +159,15 @@ func (c *Controller) RunControllers(stopCh <-chan struct{}) {
 
 	// Watch x  TPR objects
 	c.pgQueue.Run(stopCh)
+	c.roleQueue.Run(stopCh)
+	c.retentionQueue.Run(stopCh)
+	c.snapshotDatabasesQueue.Run(stopCh)
+	c.drillQueue.Run(stopCh)
+	c.backupStatusQueue.Run(stopCh)
+	c.snapshotMetadataQueue.Run(stopCh)
+
+	go wait.Until(c.publishArchiveWindows, archiveListPeriod, stopCh)
+	go wait.Until(c.publishBackupFreshness, backupStatusPeriod, stopCh)
 	c.DrmnQueue.Run(stopCh)
 	c.SnapQueue.Run(stopCh)
 	c.JobQueue.Run(stopCh)
@@ -42,6 +42,10 @@ func (c *Controller) WaitUntilPaused(drmn *api.DormantDatabase) error {
 		return err
 	}
 
+	if err := c.deleteStatusConfigMap(db.ObjectMeta); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -0,0 +1,452 @@
+package controller
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/appscode/go/log"
+	"github.com/appscode/go/types"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	"github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	"github.com/kubedb/postgres/pkg/archive"
+	"github.com/kubedb/postgres/pkg/audit/summary"
+	le "github.com/kubedb/postgres/pkg/leader_election"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	meta_util "kmodules.xyz/client-go/meta"
+	"kmodules.xyz/client-go/tools/queue"
+)
+
+const (
+	// Result of the last backup drill. It is recorded in the status ConfigMap of the Postgres
+	// for an archive drill, and in annotations of the Snapshot for a snapshot drill.
+	DrillPhaseKey          = "drill-phase"
+	DrillReasonKey         = "drill-reason"
+	DrillSummaryKey        = "drill-summary"
+	DrillCompletionTimeKey = "drill-completion-time"
+	// Base backup verified by the last archive drill
+	DrillBaseBackupKey = "drill-base-backup"
+
+	DrillPhaseAnnotation          = api.PostgresKey + "/" + DrillPhaseKey
+	DrillReasonAnnotation         = api.PostgresKey + "/" + DrillReasonKey
+	DrillSummaryAnnotation        = api.PostgresKey + "/" + DrillSummaryKey
+	DrillCompletionTimeAnnotation = api.PostgresKey + "/" + DrillCompletionTimeKey
+
+	DrillPhaseRunning   = "Running"
+	DrillPhaseSucceeded = "Succeeded"
+	DrillPhaseFailed    = "Failed"
+
+	// Postgres a throwaway drill Postgres belongs to, and the Snapshot or base backup it restores
+	drillOfLabel          = api.PostgresKey + "/drill-of"
+	drillTargetAnnotation = api.PostgresKey + "/drill-target"
+
+	eventReasonBackupDrill       = "BackupDrill"
+	eventReasonBackupDrillFailed = "BackupDrillFailed"
+
+	drillPollPeriod     = 30 * time.Second
+	defaultDrillTimeout = time.Hour
+)
+
+// errDrillNotReady is returned while the drill Postgres is still restoring.
+var errDrillNotReady = errors.New("drill postgres is not ready yet")
+
+// initBackupDrillWatcher starts a snapshot drill every time a Snapshot completes. Archive drills
+// are started by publishArchiveWindows, once a new base backup shows up.
+func (c *Controller) initBackupDrillWatcher() {
+	c.drillQueue = queue.New("BackupDrill", c.MaxNumRequeues, c.NumThreads, c.runBackupDrill)
+	c.SnapInformer.AddEventHandler(queue.NewFilteredHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSnapshot, ok1 := oldObj.(*api.Snapshot)
+			newSnapshot, ok2 := newObj.(*api.Snapshot)
+			if !ok1 || !ok2 {
+				return
+			}
+			if oldSnapshot.Status.Phase != newSnapshot.Status.Phase && newSnapshot.Status.Phase == api.SnapshotPhaseSucceeded {
+				c.drillQueue.GetQueue().Add(newSnapshot.Namespace + "/" + newSnapshot.Spec.DatabaseName)
+			}
+		},
+	}, c.selector))
+}
+
+func drillName(postgres *api.Postgres) string {
+	return postgres.Name + "-drill"
+}
+
+func (c *Controller) runBackupDrill(key string) error {
+	log.Debugln("started processing backup drill, key:", key)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	postgres, err := c.pgLister.Postgreses(namespace).Get(name)
+	if kerr.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	drill, err := c.ExtClient.KubedbV1alpha1().Postgreses(namespace).Get(drillName(postgres), metav1.GetOptions{})
+	if err == nil {
+		if drill.Labels[drillOfLabel] != postgres.Name {
+			return fmt.Errorf(`postgres "%v/%v" is not a drill of "%v"`, namespace, drill.Name, postgres.Name)
+		}
+		if drill.DeletionTimestamp != nil {
+			return nil
+		}
+		return c.checkBackupDrill(key, postgres, drill)
+	} else if !kerr.IsNotFound(err) {
+		return err
+	}
+	if postgres.DeletionTimestamp != nil {
+		return nil
+	}
+
+	switch postgres.Annotations[validator.BackupDrillAnnotation] {
+	case validator.BackupDrillSnapshot:
+		snapshot, err := c.pendingDrillSnapshot(postgres)
+		if err != nil || snapshot == nil {
+			return err
+		}
+		if err := c.createDrillPostgres(postgres, snapshot.Name); err != nil {
+			return err
+		}
+		if err := c.recordSnapshotDrill(snapshot, DrillPhaseRunning, "", ""); err != nil {
+			return err
+		}
+		c.recorder.Eventf(
+			postgres,
+			core.EventTypeNormal,
+			eventReasonBackupDrill,
+			`Verifying Snapshot "%v" in Postgres "%v"`,
+			snapshot.Name,
+			drillName(postgres),
+		)
+	case validator.BackupDrillArchive:
+		status, err := le.GetStatus(c.Client, postgres.Namespace, postgres.OffshootName())
+		if err != nil {
+			return err
+		}
+		backups := strings.Split(status[archive.BaseBackupsKey], ",")
+		latest := backups[len(backups)-1]
+		if latest == "" || latest == status[DrillBaseBackupKey] {
+			return nil
+		}
+		if err := c.createDrillPostgres(postgres, latest); err != nil {
+			return err
+		}
+		if err := c.recordArchiveDrill(postgres, latest, DrillPhaseRunning, "", ""); err != nil {
+			return err
+		}
+		c.recorder.Eventf(
+			postgres,
+			core.EventTypeNormal,
+			eventReasonBackupDrill,
+			`Verifying base backup "%v" and wal archive in Postgres "%v"`,
+			latest,
+			drillName(postgres),
+		)
+	default:
+		return nil
+	}
+	c.drillQueue.GetQueue().AddAfter(key, drillPollPeriod)
+	return nil
+}
+
+// pendingDrillSnapshot returns the latest succeeded scheduled Snapshot, if it has not been drilled yet.
+func (c *Controller) pendingDrillSnapshot(postgres *api.Postgres) (*api.Snapshot, error) {
+	scheduled := regexp.MustCompile(fmt.Sprintf(`^%s-\d{8}-\d{6}$`, regexp.QuoteMeta(postgres.Name)))
+	objs, err := c.SnapInformer.GetIndexer().ByIndex(cache.NamespaceIndex, postgres.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []*api.Snapshot
+	for _, obj := range objs {
+		snapshot := obj.(*api.Snapshot)
+		if snapshot.Spec.DatabaseName == postgres.Name &&
+			scheduled.MatchString(snapshot.Name) &&
+			snapshot.DeletionTimestamp == nil &&
+			snapshot.Status.Phase == api.SnapshotPhaseSucceeded {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	if len(snapshots) == 0 {
+		return nil, nil
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[j].CreationTimestamp.Before(&snapshots[i].CreationTimestamp)
+	})
+	if _, found := snapshots[0].Annotations[DrillPhaseAnnotation]; found {
+		return nil, nil
+	}
+	return snapshots[0].DeepCopy(), nil
+}
+
+// createDrillPostgres creates an ephemeral Postgres restored from a Snapshot, or cloned from the wal archive.
+// It uses the database secret of the drilled Postgres, as the restored roles are the ones of the backup.
+func (c *Controller) createDrillPostgres(postgres *api.Postgres, target string) error {
+	annotations := map[string]string{
+		drillTargetAnnotation: target,
+	}
+	for _, key := range []string{
+		validator.BackupEncryptionSecretAnnotation,
+		validator.BackupEncryptionKeyAnnotation,
+	} {
+		if v, found := postgres.Annotations[key]; found {
+			annotations[key] = v
+		}
+	}
+
+	drill := &api.Postgres{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      drillName(postgres),
+			Namespace: postgres.Namespace,
+			Labels: map[string]string{
+				drillOfLabel: postgres.Name,
+			},
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: api.SchemeGroupVersion.String(),
+					Kind:       api.ResourceKindPostgres,
+					Name:       postgres.Name,
+					UID:        postgres.UID,
+				},
+			},
+		},
+		Spec: api.PostgresSpec{
+			Version:           postgres.Spec.Version,
+			Replicas:          types.Int32P(1),
+			StorageType:       api.StorageTypeEphemeral,
+			Storage:           postgres.Spec.Storage.DeepCopy(),
+			DatabaseSecret:    postgres.Spec.DatabaseSecret.DeepCopy(),
+			TerminationPolicy: api.TerminationPolicyDelete,
+		},
+	}
+	drill.Spec.PodTemplate.Spec.NodeSelector = postgres.Spec.PodTemplate.Spec.NodeSelector
+	drill.Spec.PodTemplate.Spec.Tolerations = postgres.Spec.PodTemplate.Spec.Tolerations
+	drill.Spec.PodTemplate.Spec.ImagePullSecrets = postgres.Spec.PodTemplate.Spec.ImagePullSecrets
+	drill.Spec.PodTemplate.Spec.Resources = postgres.Spec.PodTemplate.Spec.Resources
+
+	if postgres.Annotations[validator.BackupDrillAnnotation] == validator.BackupDrillSnapshot {
+		drill.Spec.Init = &api.InitSpec{
+			SnapshotSource: &api.SnapshotSourceSpec{
+				Namespace: postgres.Namespace,
+				Name:      target,
+			},
+		}
+	} else {
+		drill.Annotations[validator.CloneFromAnnotation] = postgres.Name
+	}
+	_, err := c.ExtClient.KubedbV1alpha1().Postgreses(drill.Namespace).Create(drill)
+	return err
+}
+
+// checkBackupDrill verifies a drill Postgres once it has been restored, records the result and removes it.
+func (c *Controller) checkBackupDrill(key string, postgres, drill *api.Postgres) error {
+	timeout := defaultDrillTimeout
+	if d, err := time.ParseDuration(postgres.Annotations[validator.BackupDrillTimeoutAnnotation]); err == nil {
+		timeout = d
+	}
+
+	var phase, reason, result string
+	_, initErr := meta_util.GetString(drill.Annotations, api.AnnotationInitialized)
+	switch {
+	case drill.Status.Phase == api.DatabasePhaseFailed:
+		phase, reason = DrillPhaseFailed, "restore failed: "+drill.Status.Reason
+	case drill.Status.Phase == api.DatabasePhaseRunning && (drill.Spec.Init == nil || initErr == nil):
+		var err error
+		result, err = c.verifyBackupDrill(postgres, drill)
+		if err == nil {
+			phase = DrillPhaseSucceeded
+		} else if err != errDrillNotReady {
+			phase, reason = DrillPhaseFailed, err.Error()
+		}
+	}
+	if phase == "" {
+		if time.Since(drill.CreationTimestamp.Time) < timeout {
+			c.drillQueue.GetQueue().AddAfter(key, drillPollPeriod)
+			return nil
+		}
+		phase, reason = DrillPhaseFailed, fmt.Sprintf("restore was not verified within %v", timeout)
+	}
+
+	target := drill.Annotations[drillTargetAnnotation]
+	if postgres.Annotations[validator.BackupDrillAnnotation] == validator.BackupDrillArchive {
+		if err := c.recordArchiveDrill(postgres, target, phase, reason, result); err != nil {
+			return err
+		}
+	} else {
+		snapshot, err := c.ExtClient.KubedbV1alpha1().Snapshots(postgres.Namespace).Get(target, metav1.GetOptions{})
+		if err == nil {
+			if err := c.recordSnapshotDrill(snapshot, phase, reason, result); err != nil {
+				return err
+			}
+		} else if !kerr.IsNotFound(err) {
+			return err
+		}
+	}
+	if phase == DrillPhaseSucceeded {
+		c.recorder.Eventf(
+			postgres,
+			core.EventTypeNormal,
+			eventReasonBackupDrill,
+			`Verified "%v": %v`,
+			target,
+			result,
+		)
+	} else {
+		c.recorder.Eventf(
+			postgres,
+			core.EventTypeWarning,
+			eventReasonBackupDrillFailed,
+			`Failed to verify "%v". Reason: %v`,
+			target,
+			reason,
+		)
+	}
+
+	err := c.ExtClient.KubedbV1alpha1().Postgreses(drill.Namespace).Delete(drill.Name, &metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// verifyBackupDrill runs the audit report and the assertions against a drill Postgres.
+func (c *Controller) verifyBackupDrill(postgres, drill *api.Postgres) (string, error) {
+	secret, err := c.Client.CoreV1().Secrets(drill.Namespace).Get(drill.Spec.DatabaseSecret.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	username := string(secret.Data[PostgresUser])
+	password := string(secret.Data[PostgresPassword])
+	host := fmt.Sprintf("%v.%v", drill.ServiceName(), drill.Namespace)
+
+	db, err := sql.Open("postgres", drillConnectionString(username, password, host, "postgres"))
+	if err != nil {
+		return "", err
+	}
+	var inRecovery bool
+	err = db.QueryRow("SELECT pg_is_in_recovery()").Scan(&inRecovery)
+	db.Close()
+	if err != nil || inRecovery {
+		// not accepting connections or still replaying WAL
+		return "", errDrillNotReady
+	}
+
+	pgSummary, err := summary.Collect(username, password, host, PostgresPort, "")
+	if err != nil {
+		return "", fmt.Errorf("audit report failed: %v", err)
+	}
+	tables, rows := 0, int64(0)
+	for _, s := range pgSummary {
+		for _, schema := range s.Schema {
+			for _, table := range schema.Table {
+				tables++
+				if table.TotalRow > 0 {
+					rows += table.TotalRow
+				}
+			}
+		}
+	}
+	result := fmt.Sprintf("%d databases, %d tables, %d rows", len(pgSummary), tables, rows)
+
+	name := postgres.Annotations[validator.BackupDrillAssertionsAnnotation]
+	if name == "" {
+		return result, nil
+	}
+	cm, err := c.Client.CoreV1().ConfigMaps(postgres.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(cm.Data))
+	for key := range cm.Data {
+		if strings.HasSuffix(key, ".sql") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		database := "postgres"
+		if parts := strings.Split(key, "."); len(parts) > 2 {
+			database = parts[0]
+		}
+		if err := runDrillAssertion(drillConnectionString(username, password, host, database), cm.Data[key]); err != nil {
+			return "", fmt.Errorf(`assertion "%v" failed: %v`, key, err)
+		}
+	}
+	return fmt.Sprintf("%s, %d assertions passed", result, len(keys)), nil
+}
+
+func drillConnectionString(username, password, host, database string) string {
+	return fmt.Sprintf("user=%v password=%v host=%v port=%v dbname=%v sslmode=disable connect_timeout=10",
+		username, password, host, PostgresPort, database)
+}
+
+func runDrillAssertion(connStr, query string) error {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var ok bool
+	if err := db.QueryRow(query).Scan(&ok); err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("query returned false")
+	}
+	return nil
+}
+
+func (c *Controller) recordSnapshotDrill(snapshot *api.Snapshot, phase, reason, result string) error {
+	_, _, err := util.PatchSnapshot(c.ExtClient.KubedbV1alpha1(), snapshot, func(in *api.Snapshot) *api.Snapshot {
+		in.Annotations = upsertDrillResult(in.Annotations, drillResult(phase, reason, result, time.Now()))
+		return in
+	})
+	return err
+}
+
+func (c *Controller) recordArchiveDrill(postgres *api.Postgres, baseBackup, phase, reason, result string) error {
+	status := drillResult(phase, reason, result, time.Now())
+	status[DrillBaseBackupKey] = baseBackup
+	return le.UpdateStatus(c.Client, postgres.Namespace, postgres.OffshootName(), status)
+}
+
+// drillResult returns the status entries of a drill. Entries that do not apply have an empty value.
+func drillResult(phase, reason, result string, now time.Time) map[string]string {
+	status := map[string]string{
+		DrillPhaseKey:          phase,
+		DrillReasonKey:         reason,
+		DrillSummaryKey:        result,
+		DrillCompletionTimeKey: "",
+	}
+	if phase != DrillPhaseRunning {
+		status[DrillCompletionTimeKey] = now.UTC().Format(time.RFC3339)
+	}
+	return status
+}
+
+// upsertDrillResult records the entries of a drill result as annotations.
+func upsertDrillResult(annotations map[string]string, status map[string]string) map[string]string {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for key, value := range status {
+		key = api.PostgresKey + "/" + key
+		if value == "" {
+			delete(annotations, key)
+		} else {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
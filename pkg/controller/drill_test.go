@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDrillResult(t *testing.T) {
+	now := time.Date(2019, 5, 6, 10, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	for _, c := range []struct {
+		name   string
+		phase  string
+		reason string
+		result string
+		status map[string]string
+	}{
+		{"running", DrillPhaseRunning, "", "", map[string]string{
+			DrillPhaseKey:          DrillPhaseRunning,
+			DrillReasonKey:         "",
+			DrillSummaryKey:        "",
+			DrillCompletionTimeKey: "",
+		}},
+		{"succeeded", DrillPhaseSucceeded, "", "1 databases, 3 tables, 42 rows", map[string]string{
+			DrillPhaseKey:          DrillPhaseSucceeded,
+			DrillReasonKey:         "",
+			DrillSummaryKey:        "1 databases, 3 tables, 42 rows",
+			DrillCompletionTimeKey: "2019-05-06T08:00:00Z",
+		}},
+		{"failed", DrillPhaseFailed, "restore failed", "", map[string]string{
+			DrillPhaseKey:          DrillPhaseFailed,
+			DrillReasonKey:         "restore failed",
+			DrillSummaryKey:        "",
+			DrillCompletionTimeKey: "2019-05-06T08:00:00Z",
+		}},
+	} {
+		if status := drillResult(c.phase, c.reason, c.result, now); !reflect.DeepEqual(status, c.status) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.status, status)
+		}
+	}
+}
+
+func TestUpsertDrillResult(t *testing.T) {
+	now := time.Date(2019, 5, 6, 10, 0, 0, 0, time.UTC)
+
+	annotations := upsertDrillResult(nil, drillResult(DrillPhaseFailed, "restore failed", "", now))
+	expected := map[string]string{
+		DrillPhaseAnnotation:          DrillPhaseFailed,
+		DrillReasonAnnotation:         "restore failed",
+		DrillCompletionTimeAnnotation: "2019-05-06T10:00:00Z",
+	}
+	if !reflect.DeepEqual(annotations, expected) {
+		t.Errorf("expected %v, got %v", expected, annotations)
+	}
+
+	// a new drill of the Snapshot clears the result of the previous one and keeps other annotations
+	annotations[SnapshotImportedAnnotation] = "true"
+	annotations = upsertDrillResult(annotations, drillResult(DrillPhaseRunning, "", "", now))
+	expected = map[string]string{
+		DrillPhaseAnnotation:       DrillPhaseRunning,
+		SnapshotImportedAnnotation: "true",
+	}
+	if !reflect.DeepEqual(annotations, expected) {
+		t.Errorf("expected %v, got %v", expected, annotations)
+	}
+}
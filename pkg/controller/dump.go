@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/appscode/go/log"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	"github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	"github.com/kubedb/postgres/pkg/archive"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
+	"kmodules.xyz/client-go/tools/queue"
+)
+
+const (
+	// Format of a logical Snapshot, "sql" (default) or "directory". A sql Snapshot is a single pg_dumpall file.
+	// A directory Snapshot holds the globals and a compressed pg_dump -Fd per database, which can be
+	// restored in parallel and selectively. Set on a Snapshot, or on a Postgres as default for all of its Snapshots.
+	SnapshotFormatAnnotation = api.PostgresKey + "/snapshot-format"
+	// Number of parallel pg_dump jobs of a directory Snapshot.
+	SnapshotJobsAnnotation = api.PostgresKey + "/snapshot-jobs"
+	// Databases of a succeeded directory Snapshot, comma separated.
+	SnapshotDatabasesAnnotation = api.PostgresKey + "/snapshot-databases"
+
+	SnapshotFormatSQL       = "sql"
+	SnapshotFormatDirectory = "directory"
+)
+
+// snapshotFormat returns the format of a logical Snapshot, falling back to the default of its Postgres.
+func snapshotFormat(snapshot *api.Snapshot, postgres *api.Postgres) string {
+	if f := snapshot.Annotations[SnapshotFormatAnnotation]; f != "" {
+		return f
+	}
+	if f := postgres.Annotations[SnapshotFormatAnnotation]; f != "" {
+		return f
+	}
+	return SnapshotFormatSQL
+}
+
+func validateSnapshotFormat(snapshot *api.Snapshot, postgres *api.Postgres) error {
+	format := snapshotFormat(snapshot, postgres)
+	if format != SnapshotFormatSQL && format != SnapshotFormatDirectory {
+		return fmt.Errorf(`annotation "%v" must be "%v" or "%v", found "%v"`, SnapshotFormatAnnotation, SnapshotFormatSQL, SnapshotFormatDirectory, format)
+	}
+	if jobs, found := snapshot.Annotations[SnapshotJobsAnnotation]; found {
+		if format != SnapshotFormatDirectory {
+			return fmt.Errorf(`annotation "%v" requires Snapshot format "%v"`, SnapshotJobsAnnotation, SnapshotFormatDirectory)
+		}
+		if n, err := strconv.Atoi(jobs); err != nil || n <= 0 {
+			return fmt.Errorf(`annotation "%v" must be a positive integer`, SnapshotJobsAnnotation)
+		}
+	}
+	return nil
+}
+
+// backupArgs returns the options of postgres-tools.sh for the format of a logical Snapshot.
+func backupArgs(snapshot *api.Snapshot, postgres *api.Postgres) []string {
+	if snapshotFormat(snapshot, postgres) != SnapshotFormatDirectory {
+		return nil
+	}
+	args := []string{fmt.Sprintf(`--format=%s`, SnapshotFormatDirectory)}
+	if jobs := snapshot.Annotations[SnapshotJobsAnnotation]; jobs != "" {
+		args = append(args, fmt.Sprintf(`--jobs=%s`, jobs))
+	}
+	return args
+}
+
+// restoreArgs returns the options of postgres-tools.sh for a selective or parallel restore.
+// They only apply to Snapshots in directory format.
+func restoreArgs(postgres *api.Postgres) []string {
+	var args []string
+	for _, opt := range []struct {
+		flag, annotation string
+	}{
+		{"jobs", validator.RestoreJobsAnnotation},
+		{"databases", validator.RestoreDatabasesAnnotation},
+		{"schemas", validator.RestoreSchemasAnnotation},
+		{"tables", validator.RestoreTablesAnnotation},
+	} {
+		if v := postgres.Annotations[opt.annotation]; v != "" {
+			args = append(args, fmt.Sprintf(`--%s=%s`, opt.flag, v))
+		}
+	}
+	return args
+}
+
+// initSnapshotDatabasesWatcher records the databases of every directory Snapshot that succeeds.
+func (c *Controller) initSnapshotDatabasesWatcher() {
+	c.snapshotDatabasesQueue = queue.New("SnapshotDatabases", c.MaxNumRequeues, c.NumThreads, c.runSnapshotDatabases)
+	c.SnapInformer.AddEventHandler(queue.NewFilteredHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSnapshot, ok1 := oldObj.(*api.Snapshot)
+			newSnapshot, ok2 := newObj.(*api.Snapshot)
+			if !ok1 || !ok2 {
+				return
+			}
+			if oldSnapshot.Status.Phase != newSnapshot.Status.Phase && newSnapshot.Status.Phase == api.SnapshotPhaseSucceeded {
+				queue.Enqueue(c.snapshotDatabasesQueue.GetQueue(), newSnapshot)
+			}
+		},
+	}, c.selector))
+}
+
+func (c *Controller) runSnapshotDatabases(key string) error {
+	log.Debugln("started processing snapshot databases, key:", key)
+	obj, exists, err := c.SnapInformer.GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		return err
+	}
+	snapshot := obj.(*api.Snapshot).DeepCopy()
+	if snapshot.DeletionTimestamp != nil || snapshot.Spec.Local != nil {
+		return nil
+	}
+	if _, found := snapshot.Annotations[SnapshotDatabasesAnnotation]; found {
+		return nil
+	}
+	postgres, err := c.pgLister.Postgreses(snapshot.Namespace).Get(snapshot.Spec.DatabaseName)
+	if kerr.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if snapshotType(snapshot, postgres) != SnapshotTypeLogical || snapshotFormat(snapshot, postgres) != SnapshotFormatDirectory {
+		return nil
+	}
+
+	folderName, err := snapshotLocation(snapshot)
+	if err != nil {
+		return err
+	}
+	databases, err := archive.SnapshotDatabases(c.Client, snapshot.Spec.Backend, snapshot.Namespace, path.Join(folderName, snapshot.Name))
+	if err != nil {
+		return err
+	}
+	_, _, err = util.PatchSnapshot(c.ExtClient.KubedbV1alpha1(), snapshot, func(in *api.Snapshot) *api.Snapshot {
+		if in.Annotations == nil {
+			in.Annotations = map[string]string{}
+		}
+		in.Annotations[SnapshotDatabasesAnnotation] = strings.Join(databases, ",")
+		return in
+	})
+	return err
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"path"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	core_util "kmodules.xyz/client-go/core/v1"
+)
+
+const (
+	backupEncryptionVolume = "backup-encryption"
+	// Mount path of the OpenPGP keys in Snapshot Jobs and database pods
+	backupEncryptionMountPath = "/etc/backup-encryption"
+)
+
+func backupEncryptionVolumeSource(secretName string) core.Volume {
+	return core.Volume{
+		Name: backupEncryptionVolume,
+		VolumeSource: core.VolumeSource{
+			Secret: &core.SecretVolumeSource{
+				SecretName: secretName,
+			},
+		},
+	}
+}
+
+func backupEncryptionVolumeMount() core.VolumeMount {
+	return core.VolumeMount{
+		Name:      backupEncryptionVolume,
+		MountPath: backupEncryptionMountPath,
+		ReadOnly:  true,
+	}
+}
+
+// encryptSnapshot adds the OpenPGP key of a Postgres to a Snapshot Job, so that the backup
+// is encrypted before it is uploaded.
+func encryptSnapshot(podSpec *core.PodSpec, postgres *api.Postgres) {
+	secretName, key, _ := validator.BackupEncryption(postgres)
+	if secretName == "" {
+		return
+	}
+	podSpec.Containers[0].Args = append([]string{
+		podSpec.Containers[0].Args[0],
+		"--encryption-key=" + path.Join(backupEncryptionMountPath, key),
+	}, podSpec.Containers[0].Args[1:]...)
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, backupEncryptionVolumeMount())
+	podSpec.Volumes = append(podSpec.Volumes, backupEncryptionVolumeSource(secretName))
+}
+
+// decryptSnapshot adds all OpenPGP keys of a Postgres to the container restoring a Snapshot into it.
+func decryptSnapshot(container *core.Container, volumes []core.Volume, postgres *api.Postgres) []core.Volume {
+	secretName, _, _ := validator.BackupEncryption(postgres)
+	if secretName == "" {
+		return volumes
+	}
+	container.Args = append([]string{
+		container.Args[0],
+		"--decryption-keys=" + backupEncryptionMountPath,
+	}, container.Args[1:]...)
+	container.VolumeMounts = core_util.UpsertVolumeMount(container.VolumeMounts, backupEncryptionVolumeMount())
+	return core_util.UpsertVolume(volumes, backupEncryptionVolumeSource(secretName))
+}
+
+// backupEncryptionEnv returns the OpenPGP key wal-g encrypts the wal archive with and the keys it decrypts
+// the wal init with. All keys of the Secret are used for decryption, so that a wal init can cross a key rotation.
+func backupEncryptionEnv(postgres *api.Postgres) []core.EnvVar {
+	secretName, key, _ := validator.BackupEncryption(postgres)
+	if secretName == "" {
+		return nil
+	}
+	var envList []core.EnvVar
+	if postgres.Spec.Archiver != nil && postgres.Spec.Archiver.Storage != nil {
+		envList = append(envList, core.EnvVar{
+			Name:  "ARCHIVE_PGP_KEY_PATH",
+			Value: path.Join(backupEncryptionMountPath, key),
+		})
+	}
+	if postgres.Spec.Init != nil && postgres.Spec.Init.PostgresWAL != nil {
+		envList = append(envList, core.EnvVar{
+			Name:  "RESTORE_PGP_KEYS_DIR",
+			Value: backupEncryptionMountPath,
+		})
+	}
+	return envList
+}
+
+func upsertBackupEncryption(statefulSet *apps.StatefulSet, postgres *api.Postgres) *apps.StatefulSet {
+	secretName, _, _ := validator.BackupEncryption(postgres)
+	if secretName == "" {
+		return statefulSet
+	}
+	for i, container := range statefulSet.Spec.Template.Spec.Containers {
+		if container.Name == api.ResourceSingularPostgres {
+			volumeMounts := core_util.UpsertVolumeMount(container.VolumeMounts, backupEncryptionVolumeMount())
+			statefulSet.Spec.Template.Spec.Containers[i].VolumeMounts = volumeMounts
+
+			volumes := core_util.UpsertVolume(statefulSet.Spec.Template.Spec.Volumes, backupEncryptionVolumeSource(secretName))
+			statefulSet.Spec.Template.Spec.Volumes = volumes
+			return statefulSet
+		}
+	}
+	return statefulSet
+}
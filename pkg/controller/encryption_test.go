@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	store "kmodules.xyz/objectstore-api/api/v1"
+)
+
+func TestBackupEncryptionEnv(t *testing.T) {
+	archiver := &api.PostgresArchiverSpec{Storage: &store.Backend{S3: &store.S3Spec{Bucket: "kubedb"}}}
+	walInit := &api.InitSpec{PostgresWAL: &api.PostgresWALSourceSpec{}}
+
+	for _, c := range []struct {
+		name        string
+		annotations map[string]string
+		archiver    *api.PostgresArchiverSpec
+		init        *api.InitSpec
+		want        []core.EnvVar
+	}{
+		{"not encrypted", nil, archiver, walInit, nil},
+		{"archive",
+			map[string]string{validator.BackupEncryptionSecretAnnotation: "foo-backup-keys"},
+			archiver, nil,
+			[]core.EnvVar{{Name: "ARCHIVE_PGP_KEY_PATH", Value: "/etc/backup-encryption/key.asc"}}},
+		{"rotated key",
+			map[string]string{validator.BackupEncryptionSecretAnnotation: "foo-backup-keys", validator.BackupEncryptionKeyAnnotation: "2019.asc"},
+			archiver, nil,
+			[]core.EnvVar{{Name: "ARCHIVE_PGP_KEY_PATH", Value: "/etc/backup-encryption/2019.asc"}}},
+		{"wal init decrypted with all keys",
+			map[string]string{validator.BackupEncryptionSecretAnnotation: "foo-backup-keys", validator.RestoreEncryptionKeyAnnotation: "2018.asc"},
+			archiver, walInit,
+			[]core.EnvVar{
+				{Name: "ARCHIVE_PGP_KEY_PATH", Value: "/etc/backup-encryption/key.asc"},
+				{Name: "RESTORE_PGP_KEYS_DIR", Value: "/etc/backup-encryption"},
+			}},
+	} {
+		postgres := &api.Postgres{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "demo", Annotations: c.annotations},
+			Spec:       api.PostgresSpec{Archiver: c.archiver, Init: c.init},
+		}
+		if env := backupEncryptionEnv(postgres); !reflect.DeepEqual(env, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, env)
+		}
+	}
+}
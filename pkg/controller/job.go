@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	"github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
 	batch "k8s.io/api/batch/v1"
 	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -46,7 +47,7 @@ func (c *Controller) createRestoreJob(postgres *api.Postgres, snapshot *api.Snap
 	}
 
 	// Folder name inside Cloud bucket where backup will be uploaded
-	folderName, err := snapshot.Location()
+	folderName, err := snapshotLocation(snapshot)
 	if err != nil {
 		return nil, err
 	}
@@ -83,8 +84,7 @@ func (c *Controller) createRestoreJob(postgres *api.Postgres, snapshot *api.Snap
 								fmt.Sprintf(`--folder=%s`, folderName),
 								fmt.Sprintf(`--snapshot=%s`, snapshot.Name),
 								fmt.Sprintf(`--enable-analytics=%v`, c.EnableAnalytics),
-								"--",
-							}, postgres.Spec.Init.SnapshotSource.Args...),
+							}, append(restoreArgs(postgres), append([]string{"--"}, postgres.Spec.Init.SnapshotSource.Args...)...)...),
 							Env: []core.EnvVar{
 								{
 									Name: PostgresUser,
@@ -161,6 +161,8 @@ func (c *Controller) createRestoreJob(postgres *api.Postgres, snapshot *api.Snap
 		},
 	}
 
+	job.Spec.Template.Spec.Volumes = decryptSnapshot(&job.Spec.Template.Spec.Containers[0], job.Spec.Template.Spec.Volumes, postgres)
+
 	if snapshot.Spec.Backend.Local != nil {
 		job.Spec.Template.Spec.Containers[0].VolumeMounts = append(job.Spec.Template.Spec.Containers[0].VolumeMounts, core.VolumeMount{
 			Name:      "local",
@@ -197,6 +199,25 @@ func (c *Controller) GetSnapshotter(snapshot *api.Snapshot) (*batch.Job, error)
 	if err != nil {
 		return nil, err
 	}
+
+	// The type is recorded, so that the Snapshot is restored and wiped out without the defaults of its Postgres.
+	snapType := snapshotType(snapshot, postgres)
+	if _, found := snapshot.Annotations[SnapshotTypeAnnotation]; !found {
+		snap, _, err := util.PatchSnapshot(c.ExtClient.KubedbV1alpha1(), snapshot, func(in *api.Snapshot) *api.Snapshot {
+			in.Annotations = core_util.UpsertMap(in.Annotations, map[string]string{
+				SnapshotTypeAnnotation: snapType,
+			})
+			return in
+		})
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Annotations = snap.Annotations
+	}
+	if snapType == SnapshotTypeVolume {
+		return c.getVolumeSnapshotter(snapshot, postgres, postgresVersion)
+	}
+
 	jobName := fmt.Sprintf("%s-%s", api.DatabaseNamePrefix, snapshot.OffshootName())
 	jobLabel := postgres.OffshootLabels()
 	if jobLabel == nil {
@@ -231,6 +252,16 @@ func (c *Controller) GetSnapshotter(snapshot *api.Snapshot) (*batch.Job, error)
 		return nil, err
 	}
 
+	// physical Snapshots are base backups of the data directory
+	backupOp, formatArgs := api.JobTypeBackup, backupArgs(snapshot, postgres)
+	if snapType == SnapshotTypePhysical {
+		backupOp, formatArgs = "basebackup", nil
+	}
+	host, _, err := c.snapshotSourceHost(snapshot, postgres)
+	if err != nil {
+		return nil, err
+	}
+
 	job := &batch.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        jobName,
@@ -256,14 +287,13 @@ func (c *Controller) GetSnapshotter(snapshot *api.Snapshot) (*batch.Job, error)
 							Name:  api.JobTypeBackup,
 							Image: postgresVersion.Spec.Tools.Image,
 							Args: append([]string{
-								api.JobTypeBackup,
-								fmt.Sprintf(`--host=%s`, postgres.ServiceName()),
+								backupOp,
+								fmt.Sprintf(`--host=%s`, host),
 								fmt.Sprintf(`--bucket=%s`, bucket),
 								fmt.Sprintf(`--folder=%s`, folderName),
 								fmt.Sprintf(`--snapshot=%s`, snapshot.Name),
 								fmt.Sprintf(`--enable-analytics=%v`, c.EnableAnalytics),
-								"--",
-							}, snapshot.Spec.PodTemplate.Spec.Args...),
+							}, append(formatArgs, append([]string{"--"}, snapshot.Spec.PodTemplate.Spec.Args...)...)...),
 							Env: []core.EnvVar{
 								{
 									Name: PostgresUser,
@@ -340,6 +370,8 @@ func (c *Controller) GetSnapshotter(snapshot *api.Snapshot) (*batch.Job, error)
 		},
 	}
 
+	encryptSnapshot(&job.Spec.Template.Spec, postgres)
+
 	if snapshot.Spec.Backend.Local != nil {
 		job.Spec.Template.Spec.Containers[0].VolumeMounts = append(job.Spec.Template.Spec.Containers[0].VolumeMounts, core.VolumeMount{
 			Name:      "local",
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"path"
+
+	"github.com/appscode/go/log"
+	catalog "github.com/kubedb/apimachinery/apis/catalog/v1alpha1"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	"github.com/kubedb/postgres/pkg/archive"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"kmodules.xyz/client-go/tools/queue"
+)
+
+// SnapshotImportedAnnotation marks a Snapshot that was created for backup data already in the backend.
+// Its status is set by the importer, so no backup is taken for it.
+const SnapshotImportedAnnotation = api.PostgresKey + "/imported"
+
+// SnapshotLocationAnnotation is the folder of the Postgres an imported Snapshot was taken of, if it is
+// not the folder of the Snapshot's own namespace and Postgres, "<prefix>/kubedb/<namespace>/<postgres>".
+const SnapshotLocationAnnotation = api.PostgresKey + "/snapshot-location"
+
+// snapshotLocation returns the folder in the backend that holds the folder of a Snapshot.
+func snapshotLocation(snapshot *api.Snapshot) (string, error) {
+	if location := snapshot.Annotations[SnapshotLocationAnnotation]; location != "" {
+		return location, nil
+	}
+	return snapshot.Location()
+}
+
+// annotations maintained by the operator, which are not stored in the backup metadata
+var operatorAnnotations = []string{
+	api.AnnotationInitialized,
+	"kubectl.kubernetes.io/last-applied-configuration",
+	// a rebuilt Postgres must not clone its source again
+	validator.CloneFromAnnotation,
+	validator.CloneTargetTimeAnnotation,
+	cloneSourceAnnotation,
+}
+
+// backupMetadata describes a Postgres, its version and its auth Secret to rebuild it from a backup.
+func (c *Controller) backupMetadata(postgres *api.Postgres) (*archive.Metadata, error) {
+	postgresVersion, err := c.ExtClient.CatalogV1alpha1().PostgresVersions().Get(string(postgres.Spec.Version), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pg := &api.Postgres{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: api.SchemeGroupVersion.String(),
+			Kind:       api.ResourceKindPostgres,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        postgres.Name,
+			Namespace:   postgres.Namespace,
+			Labels:      postgres.Labels,
+			Annotations: map[string]string{},
+		},
+		Spec: *postgres.Spec.DeepCopy(),
+	}
+	for k, v := range postgres.Annotations {
+		pg.Annotations[k] = v
+	}
+	for _, k := range operatorAnnotations {
+		delete(pg.Annotations, k)
+	}
+	// a rebuilt Postgres is initialized from the backup it is rebuilt from
+	pg.Spec.Init = nil
+
+	m := &archive.Metadata{
+		Postgres: pg,
+		PostgresVersion: &catalog.PostgresVersion{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: catalog.SchemeGroupVersion.String(),
+				Kind:       catalog.ResourceKindPostgresVersion,
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   postgresVersion.Name,
+				Labels: postgresVersion.Labels,
+			},
+			Spec: postgresVersion.Spec,
+		},
+	}
+	if postgres.Spec.DatabaseSecret == nil {
+		return m, nil
+	}
+	secret, err := c.Client.CoreV1().Secrets(postgres.Namespace).Get(postgres.Spec.DatabaseSecret.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var key []byte
+	if name := postgres.Annotations[validator.BackupMetadataKeyAnnotation]; name != "" {
+		keySecret, err := c.Client.CoreV1().Secrets(postgres.Namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		key = keySecret.Data[validator.BackupMetadataKey]
+	}
+	if m.Secret, err = archive.NewSecretMetadata(secret, key); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// initSnapshotMetadataWatcher stores the backup metadata with every Snapshot that succeeds.
+func (c *Controller) initSnapshotMetadataWatcher() {
+	c.snapshotMetadataQueue = queue.New("SnapshotMetadata", c.MaxNumRequeues, c.NumThreads, c.runSnapshotMetadata)
+	c.SnapInformer.AddEventHandler(queue.NewFilteredHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSnapshot, ok1 := oldObj.(*api.Snapshot)
+			newSnapshot, ok2 := newObj.(*api.Snapshot)
+			if !ok1 || !ok2 {
+				return
+			}
+			if oldSnapshot.Status.Phase != newSnapshot.Status.Phase && newSnapshot.Status.Phase == api.SnapshotPhaseSucceeded {
+				queue.Enqueue(c.snapshotMetadataQueue.GetQueue(), newSnapshot)
+			}
+		},
+	}, c.selector))
+}
+
+func (c *Controller) runSnapshotMetadata(key string) error {
+	log.Debugln("started processing snapshot metadata, key:", key)
+	obj, exists, err := c.SnapInformer.GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		return err
+	}
+	snapshot := obj.(*api.Snapshot).DeepCopy()
+	// local backends can not be written by the operator. Imported Snapshots come with their metadata.
+	if snapshot.DeletionTimestamp != nil || snapshot.Spec.Local != nil || snapshot.Annotations[SnapshotImportedAnnotation] != "" {
+		return nil
+	}
+	postgres, err := c.pgLister.Postgreses(snapshot.Namespace).Get(snapshot.Spec.DatabaseName)
+	if kerr.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	m, err := c.backupMetadata(postgres)
+	if err != nil {
+		return err
+	}
+	m.Snapshot = &api.Snapshot{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: api.SchemeGroupVersion.String(),
+			Kind:       api.ResourceKindSnapshot,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        snapshot.Name,
+			Namespace:   snapshot.Namespace,
+			Labels:      snapshot.Labels,
+			Annotations: snapshot.Annotations,
+		},
+		Spec:   snapshot.Spec,
+		Status: snapshot.Status,
+	}
+	folderName, err := snapshot.Location()
+	if err != nil {
+		return err
+	}
+	return archive.WriteMetadata(c.Client, snapshot.Spec.Backend, snapshot.Namespace, path.Join(folderName, snapshot.Name), m)
+}
+
+// writeArchiveMetadata stores the backup metadata in the wal archive of a Postgres.
+func (c *Controller) writeArchiveMetadata(postgres *api.Postgres, baseBackup string) error {
+	m, err := c.backupMetadata(postgres)
+	if err != nil {
+		return err
+	}
+	m.BaseBackup = baseBackup
+	return archive.WriteMetadata(c.Client, *postgres.Spec.Archiver.Storage, postgres.Namespace, WalDataDir(postgres), m)
+}
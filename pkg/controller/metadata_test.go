@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"testing"
+
+	catalog "github.com/kubedb/apimachinery/apis/catalog/v1alpha1"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	extFake "github.com/kubedb/apimachinery/client/clientset/versioned/fake"
+	amc "github.com/kubedb/apimachinery/pkg/controller"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestBackupMetadata(t *testing.T) {
+	postgres := &api.Postgres{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "demo",
+			Annotations: map[string]string{
+				api.AnnotationInitialized:                          "",
+				validator.CloneFromAnnotation:                      "bar",
+				validator.CloneTargetTimeAnnotation:                "2019-05-06T10:00:00Z",
+				cloneSourceAnnotation:                              "demo/bar",
+				validator.BackupMetadataKeyAnnotation:              "foo-metadata-key",
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+				"app.example.com/owner":                            "team-a",
+			},
+		},
+		Spec: api.PostgresSpec{
+			Version:        "10.2-v2",
+			DatabaseSecret: &core.SecretVolumeSource{SecretName: "foo-auth"},
+			Init:           &api.InitSpec{ScriptSource: &api.ScriptSourceSpec{}},
+		},
+	}
+	c := &Controller{
+		Controller: &amc.Controller{
+			Client: fake.NewSimpleClientset(
+				&core.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "foo-auth", Namespace: "demo"},
+					Data:       map[string][]byte{"POSTGRES_PASSWORD": []byte("secret")},
+				},
+				&core.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "foo-metadata-key", Namespace: "demo"},
+					Data:       map[string][]byte{validator.BackupMetadataKey: []byte("backup-key")},
+				},
+			),
+			ExtClient: extFake.NewSimpleClientset(&catalog.PostgresVersion{
+				ObjectMeta: metav1.ObjectMeta{Name: "10.2-v2"},
+				Spec:       catalog.PostgresVersionSpec{Version: "10.2"},
+			}),
+		},
+		recorder: record.NewFakeRecorder(10),
+	}
+
+	m, err := c.backupMetadata(postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range operatorAnnotations {
+		if _, ok := m.Postgres.Annotations[k]; ok {
+			t.Errorf("expected annotation %v to be dropped", k)
+		}
+	}
+	if m.Postgres.Annotations["app.example.com/owner"] != "team-a" {
+		t.Error("expected user annotations to be kept")
+	}
+	if m.Postgres.Spec.Init != nil {
+		t.Error("expected the init source to be dropped")
+	}
+	if m.PostgresVersion.Spec.Version != "10.2" {
+		t.Errorf("expected postgres version 10.2, got %v", m.PostgresVersion.Spec.Version)
+	}
+	data, err := m.Secret.Data([]byte("backup-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data["POSTGRES_PASSWORD"]) != "secret" {
+		t.Errorf("expected the auth secret data, got %v", data)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 
 	"github.com/appscode/go/log"
+	promapi "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	le "github.com/kubedb/postgres/pkg/leader_election"
 	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kutil "kmodules.xyz/client-go"
@@ -33,7 +35,36 @@ func (c *Controller) addOrUpdateMonitor(postgres *api.Postgres) (kutil.VerbType,
 	if err != nil {
 		return kutil.VerbUnchanged, err
 	}
-	return agent.CreateOrUpdate(postgres.StatsService(), postgres.Spec.Monitor)
+	vt, err := agent.CreateOrUpdate(postgres.StatsService(), postgres.Spec.Monitor)
+	if err != nil {
+		return vt, err
+	}
+	if agent.GetType() == mona.AgentCoreOSPrometheus || agent.GetType() == mona.DeprecatedAgentCoreOSPrometheus {
+		err = c.ensureSidecarMetricsEndpoint(postgres)
+	}
+	return vt, err
+}
+
+// ensureSidecarMetricsEndpoint adds the metrics of the leader election sidecar, served on its health port,
+// to the ServiceMonitor of the stats service. The agent only creates an endpoint for the exporter port.
+func (c *Controller) ensureSidecarMetricsEndpoint(postgres *api.Postgres) error {
+	sm, err := c.promClient.ServiceMonitors(postgres.Spec.Monitor.Prometheus.Namespace).Get(postgres.StatsService().ServiceMonitorName(), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	for _, e := range sm.Spec.Endpoints {
+		if e.Port == le.HealthPortName {
+			return nil
+		}
+	}
+	sm.Spec.Endpoints = append(sm.Spec.Endpoints, promapi.Endpoint{
+		Port:        le.HealthPortName,
+		Interval:    postgres.Spec.Monitor.Prometheus.Interval,
+		Path:        "/metrics",
+		HonorLabels: true,
+	})
+	_, err = c.promClient.ServiceMonitors(sm.Namespace).Update(sm)
+	return err
 }
 
 func (c *Controller) deleteMonitor(postgres *api.Postgres) (kutil.VerbType, error) {
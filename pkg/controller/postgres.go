@@ -18,6 +18,7 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/reference"
 	kutil "kmodules.xyz/client-go"
 	core_util "kmodules.xyz/client-go/core/v1"
@@ -73,6 +74,19 @@ func (c *Controller) create(postgres *api.Postgres) error {
 	if err != nil {
 		return err
 	}
+	if ready, err := c.ensureArchiverPrecheck(postgres, postgresVersion); err != nil {
+		return err
+	} else if !ready {
+		return nil
+	}
+	if ready, err := c.ensureRecoveryTarget(postgres); err != nil {
+		return err
+	} else if !ready {
+		return nil
+	}
+	if err := c.ensureCloneSource(postgres); err != nil {
+		return err
+	}
 	vt2, err := c.ensurePostgresNode(postgres, postgresVersion)
 	if err != nil {
 		return err
@@ -101,6 +115,23 @@ func (c *Controller) create(postgres *api.Postgres) error {
 		return err
 	}
 
+	// a physical Snapshot has been restored by the database pods themselves
+	if snapshot, err := c.getPhysicalInitSnapshot(postgres); err != nil {
+		return err
+	} else if snapshot != nil {
+		if err := c.initializeFromBaseBackup(postgres, snapshot); err != nil {
+			return err
+		}
+	}
+	// a volume Snapshot has been restored by provisioning the data volumes from its VolumeSnapshot
+	if vs, err := c.getInitVolumeSnapshot(postgres); err != nil {
+		return err
+	} else if vs != nil {
+		if err := c.initializeFromVolumeSnapshot(postgres, vs); err != nil {
+			return err
+		}
+	}
+
 	if _, err := meta_util.GetString(postgres.Annotations, api.AnnotationInitialized); err == kutil.ErrNotFound &&
 		postgres.Spec.Init != nil &&
 		(postgres.Spec.Init.SnapshotSource != nil || postgres.Spec.Init.StashRestoreSession != nil) {
@@ -196,6 +227,14 @@ func (c *Controller) ensurePostgresNode(postgres *api.Postgres, postgresVersion
 		}
 	}
 
+	if err = c.ensureLeaderLockConfigMap(postgres); err != nil {
+		return kutil.VerbUnchanged, err
+	}
+
+	if err = c.ensureStatusConfigMap(postgres); err != nil {
+		return kutil.VerbUnchanged, err
+	}
+
 	vt, err := c.ensureCombinedNode(postgres, postgresVersion)
 	if err != nil {
 		return kutil.VerbUnchanged, err
@@ -209,12 +248,28 @@ func (c *Controller) ensureBackupScheduler(postgres *api.Postgres) error {
 	if err != nil {
 		return fmt.Errorf("failed to get PostgresVersion %v for %v/%v. Reason: %v", postgres.Spec.Version, postgres.Namespace, postgres.Name, err)
 	}
+	// Stash backups replace scheduled Snapshots
+	if stashBackupEnabled(postgres) {
+		ok, err := c.ensureStashBackup(postgres, postgresVersion.Spec.Version)
+		if err != nil {
+			return fmt.Errorf("failed to ensure stash backup. Reason: %v", err)
+		}
+		if ok {
+			c.cronController.StopBackupScheduling(postgres.ObjectMeta)
+			return nil
+		}
+	} else if err := c.deleteStashBackup(postgres); err != nil {
+		return err
+	}
+
 	// Setup Schedule backup
 	if postgres.Spec.BackupSchedule != nil {
 		err := c.cronController.ScheduleBackup(postgres, postgres.Spec.BackupSchedule, postgresVersion)
 		if err != nil {
 			return fmt.Errorf("failed to schedule snapshot. Reason: %v", err)
 		}
+		// retention policy may have changed
+		c.retentionQueue.GetQueue().Add(postgres.Namespace + "/" + postgres.Name)
 	} else {
 		c.cronController.StopBackupScheduling(postgres.ObjectMeta)
 	}
@@ -271,6 +326,10 @@ func (c *Controller) initializeFromSnapshot(postgres *api.Postgres) error {
 }
 
 func (c *Controller) terminate(postgres *api.Postgres) error {
+	if key, err := cache.MetaNamespaceKeyFunc(postgres); err == nil {
+		c.recoveryTargets.forget(key)
+	}
+
 	ref, rerr := reference.GetReference(clientsetscheme.Scheme, postgres)
 	if rerr != nil {
 		return rerr
@@ -310,6 +369,9 @@ func (c *Controller) terminate(postgres *api.Postgres) error {
 	}
 
 	c.cronController.StopBackupScheduling(postgres.ObjectMeta)
+	if err := c.deleteStashBackup(postgres); err != nil {
+		return err
+	}
 
 	if postgres.Spec.Monitor != nil {
 		if _, err := c.deleteMonitor(postgres); err != nil {
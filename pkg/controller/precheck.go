@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/appscode/go/log"
+	"github.com/appscode/go/types"
+	catalog "github.com/kubedb/apimachinery/apis/catalog/v1alpha1"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	"github.com/kubedb/postgres/pkg/archive"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	storage "kmodules.xyz/objectstore-api/osm"
+)
+
+const (
+	// If "true", the archive storage is checked by writing and deleting a probe object,
+	// before the database pods are created.
+	ArchiverPrecheckAnnotation = api.PostgresKey + "/archiver-precheck"
+
+	jobTypeArchiverPrecheck = "archiver-precheck"
+
+	eventReasonArchiverPrecheck       = "ArchiverPrecheck"
+	eventReasonArchiverPrecheckFailed = "ArchiverPrecheckFailed"
+
+	archiverPrecheckPollPeriod  = 10 * time.Second
+	archiverPrecheckRetryPeriod = time.Minute
+	recoveryTargetRetryPeriod   = 10 * time.Minute
+)
+
+// ensureArchiverPrecheck runs the archive storage precheck Job of a new Postgres.
+// It returns true, once the database pods can be created.
+func (c *Controller) ensureArchiverPrecheck(postgres *api.Postgres, postgresVersion *catalog.PostgresVersion) (bool, error) {
+	if precheck, _ := strconv.ParseBool(postgres.Annotations[ArchiverPrecheckAnnotation]); !precheck ||
+		postgres.Spec.Archiver == nil ||
+		postgres.Spec.Archiver.Storage == nil ||
+		postgres.Spec.Archiver.Storage.Local != nil {
+		return true, nil
+	}
+	// only the first start is guarded
+	if _, err := c.Client.AppsV1().StatefulSets(postgres.Namespace).Get(postgres.OffshootName(), metav1.GetOptions{}); err == nil {
+		return true, nil
+	} else if !kerr.IsNotFound(err) {
+		return false, err
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(postgres)
+	if err != nil {
+		return false, err
+	}
+	jobName := archiverPrecheckJobName(postgres)
+	job, err := c.Client.BatchV1().Jobs(postgres.Namespace).Get(jobName, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		if _, err := c.createArchiverPrecheckJob(postgres, postgresVersion); err != nil {
+			return false, err
+		}
+		c.recorder.Event(
+			postgres,
+			core.EventTypeNormal,
+			eventReasonArchiverPrecheck,
+			"Checking access to archive storage",
+		)
+		c.pgQueue.GetQueue().AddAfter(key, archiverPrecheckPollPeriod)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	switch {
+	case job.Status.Succeeded > 0:
+		if err := c.deleteArchiverPrecheckJob(postgres); err != nil {
+			return false, err
+		}
+		c.recorder.Event(
+			postgres,
+			core.EventTypeNormal,
+			eventReasonArchiverPrecheck,
+			"Archive storage is accessible",
+		)
+		return true, nil
+	case job.Status.Failed > types.Int32(job.Spec.BackoffLimit):
+		// the Job is removed, so that the check is repeated after the storage has been fixed
+		if err := c.deleteArchiverPrecheckJob(postgres); err != nil {
+			return false, err
+		}
+		c.recorder.Eventf(
+			postgres,
+			core.EventTypeWarning,
+			eventReasonArchiverPrecheckFailed,
+			`Failed to write to archive storage. See logs of Job "%v" for details. Retrying in %v`,
+			jobName,
+			archiverPrecheckRetryPeriod,
+		)
+		c.pgQueue.GetQueue().AddAfter(key, archiverPrecheckRetryPeriod)
+		return false, nil
+	}
+	c.pgQueue.GetQueue().AddAfter(key, archiverPrecheckPollPeriod)
+	return false, nil
+}
+
+// ensureRecoveryTarget checks before the first start of a Postgres initialized from a wal archive, that the archive
+// reaches the recovery target time. The admission webhook lists the base backups only, as listing the WAL segments
+// of a large archive takes longer than an admission request may. It returns true, once the database pods can be created.
+// A target outside of the archive fails the Postgres. The archive is listed again after recoveryTargetRetryPeriod,
+// as a running source may still archive up to the target.
+func (c *Controller) ensureRecoveryTarget(postgres *api.Postgres) (bool, error) {
+	init := postgres.Spec.Init
+	if init == nil || init.PostgresWAL == nil || init.PostgresWAL.Local != nil ||
+		init.PostgresWAL.PITR == nil || init.PostgresWAL.PITR.TargetTime == "" {
+		return true, nil
+	}
+	target, err := archive.ParseTargetTime(init.PostgresWAL.PITR.TargetTime)
+	if err != nil {
+		// let postgres decide about formats we do not know
+		return true, nil
+	}
+	// only the first start is guarded
+	if _, err := c.Client.AppsV1().StatefulSets(postgres.Namespace).Get(postgres.OffshootName(), metav1.GetOptions{}); err == nil {
+		return true, nil
+	} else if !kerr.IsNotFound(err) {
+		return false, err
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(postgres)
+	if err != nil {
+		return false, err
+	}
+	if wait, found := c.recoveryTargets.retryAfter(key, init.PostgresWAL.PITR.TargetTime, time.Now()); found {
+		c.pgQueue.GetQueue().AddAfter(key, wait)
+		return false, nil
+	}
+
+	backend := init.PostgresWAL.Backend
+	window, err := archive.List(c.Client, backend, postgres.Namespace, archive.Prefix(backend))
+	if err != nil {
+		log.Warningf("failed to list wal archive of Postgres %s/%s. Reason: %v", postgres.Namespace, postgres.Name, err)
+		return true, nil
+	}
+	reason := recoveryTargetFailure(init.PostgresWAL.PITR.TargetTime, target, window)
+	if reason == "" {
+		c.recoveryTargets.forget(key)
+		return true, nil
+	}
+	c.recoveryTargets.failed(key, init.PostgresWAL.PITR.TargetTime, time.Now())
+	c.pushFailureEvent(postgres, reason)
+	c.pgQueue.GetQueue().AddAfter(key, recoveryTargetRetryPeriod)
+	return false, nil
+}
+
+// recoveryTargetFailure returns why the recovery target is outside of the window of an archive, or "" if it is not.
+// An archive without base backups is left to the restore, which reports it.
+func recoveryTargetFailure(targetTime string, target time.Time, window *archive.Window) string {
+	if len(window.BaseBackups) == 0 || window.Contains(target) {
+		return ""
+	}
+	return fmt.Sprintf("'spec.init.postgresWAL.pitr.targetTime' %v is outside of the recoverable window %v - %v",
+		targetTime, window.From.UTC().Format(time.RFC3339), window.Until.UTC().Format(time.RFC3339))
+}
+
+// recoveryTargetChecks remembers the recovery targets, which were found outside of the archive of a new Postgres,
+// so that the archive is not listed on every reconcile. It is shared by the workers of the Postgres queue.
+type recoveryTargetChecks struct {
+	mu     sync.Mutex
+	checks map[string]recoveryTargetCheck
+}
+
+type recoveryTargetCheck struct {
+	target  string
+	checked time.Time
+}
+
+// retryAfter returns the time left until the same target of a Postgres is checked again.
+func (r *recoveryTargetChecks) retryAfter(key, target string, now time.Time) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	check, found := r.checks[key]
+	if !found || check.target != target {
+		return 0, false
+	}
+	if wait := check.checked.Add(recoveryTargetRetryPeriod).Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, false
+}
+
+func (r *recoveryTargetChecks) failed(key, target string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.checks == nil {
+		r.checks = map[string]recoveryTargetCheck{}
+	}
+	r.checks[key] = recoveryTargetCheck{target: target, checked: now}
+}
+
+func (r *recoveryTargetChecks) forget(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.checks, key)
+}
+
+func archiverPrecheckJobName(postgres *api.Postgres) string {
+	return postgres.OffshootName() + "-" + jobTypeArchiverPrecheck
+}
+
+func (c *Controller) createArchiverPrecheckJob(postgres *api.Postgres, postgresVersion *catalog.PostgresVersion) (*batch.Job, error) {
+	backend := *postgres.Spec.Archiver.Storage
+	bucket, err := backend.Container()
+	if err != nil {
+		return nil, err
+	}
+
+	jobName := archiverPrecheckJobName(postgres)
+	owner := metav1.OwnerReference{
+		APIVersion: api.SchemeGroupVersion.String(),
+		Kind:       api.ResourceKindPostgres,
+		Name:       postgres.Name,
+		UID:        postgres.UID,
+	}
+	// The kind label is left out, so that the Job controller of kubedb does not handle this Job.
+	labels := map[string]string{
+		api.LabelDatabaseName: postgres.Name,
+		api.AnnotationJobType: jobTypeArchiverPrecheck,
+	}
+
+	secret, err := storage.NewOSMSecret(c.Client, jobName, postgres.Namespace, backend)
+	if err != nil {
+		return nil, err
+	}
+	secret.Labels = labels
+	secret.OwnerReferences = []metav1.OwnerReference{owner}
+	if _, err := c.Client.CoreV1().Secrets(postgres.Namespace).Create(secret); err != nil && !kerr.IsAlreadyExists(err) {
+		return nil, err
+	}
+
+	job := &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            jobName,
+			Labels:          labels,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Spec: batch.JobSpec{
+			BackoffLimit: types.Int32P(0),
+			Template: core.PodTemplateSpec{
+				Spec: core.PodSpec{
+					Containers: []core.Container{
+						{
+							Name:            jobTypeArchiverPrecheck,
+							Image:           postgresVersion.Spec.Tools.Image,
+							ImagePullPolicy: core.PullIfNotPresent,
+							Args: []string{
+								"precheck",
+								fmt.Sprintf(`--bucket=%s`, bucket),
+								fmt.Sprintf(`--folder=%s`, archive.Prefix(backend)),
+								fmt.Sprintf(`--enable-analytics=%v`, c.EnableAnalytics),
+							},
+							VolumeMounts: []core.VolumeMount{
+								{
+									Name:      "osmconfig",
+									MountPath: storage.SecretMountPath,
+									ReadOnly:  true,
+								},
+							},
+						},
+					},
+					Volumes: []core.Volume{
+						{
+							Name: "osmconfig",
+							VolumeSource: core.VolumeSource{
+								Secret: &core.SecretVolumeSource{
+									SecretName: jobName,
+								},
+							},
+						},
+					},
+					RestartPolicy:    core.RestartPolicyNever,
+					NodeSelector:     postgres.Spec.PodTemplate.Spec.NodeSelector,
+					Tolerations:      postgres.Spec.PodTemplate.Spec.Tolerations,
+					ImagePullSecrets: postgres.Spec.PodTemplate.Spec.ImagePullSecrets,
+				},
+			},
+		},
+	}
+	return c.Client.BatchV1().Jobs(postgres.Namespace).Create(job)
+}
+
+func (c *Controller) deleteArchiverPrecheckJob(postgres *api.Postgres) error {
+	jobName := archiverPrecheckJobName(postgres)
+	deletePolicy := metav1.DeletePropagationBackground
+	if err := c.Client.BatchV1().Jobs(postgres.Namespace).Delete(jobName, &metav1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	}); err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	if err := c.Client.CoreV1().Secrets(postgres.Namespace).Delete(jobName, nil); err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
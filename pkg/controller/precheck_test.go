@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kubedb/postgres/pkg/archive"
+)
+
+func TestRecoveryTargetFailure(t *testing.T) {
+	from := time.Date(2019, 5, 6, 10, 0, 0, 0, time.UTC)
+	window := &archive.Window{
+		BaseBackups: []archive.BaseBackup{{Name: "base_000000010000000000000002", Finished: from}},
+		From:        from,
+		Until:       from.Add(time.Hour),
+	}
+	cases := []struct {
+		name   string
+		target time.Time
+		window *archive.Window
+		failed bool
+	}{
+		{"inside", from.Add(30 * time.Minute), window, false},
+		{"at the end", from.Add(time.Hour), window, false},
+		{"before the first base backup", from.Add(-time.Minute), window, true},
+		{"after the last segment", from.Add(2 * time.Hour), window, true},
+		{"no base backups", from.Add(2 * time.Hour), &archive.Window{}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			reason := recoveryTargetFailure(c.target.Format(time.RFC3339), c.target, c.window)
+			if failed := reason != ""; failed != c.failed {
+				t.Errorf("expected failed %v, got reason %q", c.failed, reason)
+			}
+		})
+	}
+}
+
+func TestRecoveryTargetChecks(t *testing.T) {
+	now := time.Date(2019, 5, 6, 10, 0, 0, 0, time.UTC)
+	var checks recoveryTargetChecks
+
+	if _, found := checks.retryAfter("demo/foo", "2019-05-06T09:00:00Z", now); found {
+		t.Fatal("expected an unchecked target to be listed")
+	}
+	checks.failed("demo/foo", "2019-05-06T09:00:00Z", now)
+
+	if wait, found := checks.retryAfter("demo/foo", "2019-05-06T09:00:00Z", now.Add(time.Minute)); !found || wait != recoveryTargetRetryPeriod-time.Minute {
+		t.Errorf("expected to wait %v for the failed target, got %v, %v", recoveryTargetRetryPeriod-time.Minute, wait, found)
+	}
+	if _, found := checks.retryAfter("demo/foo", "2019-05-06T09:30:00Z", now.Add(time.Minute)); found {
+		t.Error("expected a changed target to be listed")
+	}
+	if _, found := checks.retryAfter("demo/bar", "2019-05-06T09:00:00Z", now.Add(time.Minute)); found {
+		t.Error("expected the target of another Postgres to be listed")
+	}
+	if _, found := checks.retryAfter("demo/foo", "2019-05-06T09:00:00Z", now.Add(recoveryTargetRetryPeriod)); found {
+		t.Error("expected the failed target to be listed again after the retry period")
+	}
+
+	checks.forget("demo/foo")
+	if _, found := checks.retryAfter("demo/foo", "2019-05-06T09:00:00Z", now.Add(time.Minute)); found {
+		t.Error("expected a forgotten target to be listed")
+	}
+}
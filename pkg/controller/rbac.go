@@ -3,6 +3,7 @@ package controller
 import (
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	le "github.com/kubedb/postgres/pkg/leader_election"
+	"github.com/kubedb/postgres/pkg/volumesnapshot"
 	apps "k8s.io/api/apps/v1"
 	core "k8s.io/api/core/v1"
 	policy_v1beta1 "k8s.io/api/policy/v1beta1"
@@ -55,6 +56,23 @@ func (c *Controller) ensureRole(db *api.Postgres, pspName string) error {
 					Verbs:         []string{"get", "update"},
 					ResourceNames: []string{le.GetLeaderLockName(db.OffshootName())},
 				},
+				{
+					APIGroups:     []string{core.GroupName},
+					Resources:     []string{"configmaps"},
+					Verbs:         []string{"get", "patch"},
+					ResourceNames: []string{le.GetStatusConfigMapName(db.OffshootName())},
+				},
+				{
+					APIGroups:     []string{api.SchemeGroupVersion.Group},
+					Resources:     []string{api.ResourcePluralPostgres},
+					Verbs:         []string{"get"},
+					ResourceNames: []string{db.Name},
+				},
+				{
+					APIGroups: []string{core.GroupName},
+					Resources: []string{"events"},
+					Verbs:     []string{"create"},
+				},
 			}
 			if pspName != "" {
 				pspRule := rbac.PolicyRule{
@@ -86,7 +104,14 @@ func (c *Controller) ensureSnapshotRole(db *api.Postgres, pspName string) error
 		func(in *rbac.Role) *rbac.Role {
 			core_util.EnsureOwnerReference(&in.ObjectMeta, ref)
 			in.Labels = db.OffshootLabels()
-			in.Rules = []rbac.PolicyRule{}
+			in.Rules = []rbac.PolicyRule{
+				{
+					// VolumeSnapshots of volume Snapshots are taken by the snapshot Job itself
+					APIGroups: []string{volumesnapshot.Group},
+					Resources: []string{"volumesnapshots"},
+					Verbs:     []string{"create", "get"},
+				},
+			}
 			if pspName != "" {
 				pspRule := rbac.PolicyRule{
 					APIGroups:     []string{policy_v1beta1.GroupName},
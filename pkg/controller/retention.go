@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/appscode/go/log"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"kmodules.xyz/client-go/tools/queue"
+)
+
+const eventReasonSnapshotRetention = "SnapshotRetention"
+
+// initSnapshotRetentionWatcher applies the snapshot retention policy of a Postgres
+// every time one of its Snapshots completes.
+func (c *Controller) initSnapshotRetentionWatcher() {
+	c.retentionQueue = queue.New("SnapshotRetention", c.MaxNumRequeues, c.NumThreads, c.runSnapshotRetention)
+	c.SnapInformer.AddEventHandler(queue.NewFilteredHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSnapshot, ok1 := oldObj.(*api.Snapshot)
+			newSnapshot, ok2 := newObj.(*api.Snapshot)
+			if !ok1 || !ok2 {
+				return
+			}
+			if oldSnapshot.Status.Phase != newSnapshot.Status.Phase && newSnapshot.Status.Phase == api.SnapshotPhaseSucceeded {
+				c.retentionQueue.GetQueue().Add(newSnapshot.Namespace + "/" + newSnapshot.Spec.DatabaseName)
+			}
+		},
+	}, c.selector))
+}
+
+func (c *Controller) runSnapshotRetention(key string) error {
+	log.Debugln("started processing snapshot retention, key:", key)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	postgres, err := c.pgLister.Postgreses(namespace).Get(name)
+	if kerr.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	retain, window := -1, time.Duration(-1)
+	if v, err := strconv.Atoi(postgres.Annotations[validator.SnapshotRetainAnnotation]); err == nil {
+		retain = v
+	}
+	if v, err := time.ParseDuration(postgres.Annotations[validator.SnapshotRetainWindowAnnotation]); err == nil {
+		window = v
+	}
+	if retain < 0 && window < 0 {
+		return nil
+	}
+
+	// Only snapshots taken by the backup scheduler are subject to retention. Imported ones are kept.
+	scheduled := regexp.MustCompile(fmt.Sprintf(`^%s-\d{8}-\d{6}$`, regexp.QuoteMeta(postgres.Name)))
+	objs, err := c.SnapInformer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		return err
+	}
+	var snapshots []*api.Snapshot
+	for _, obj := range objs {
+		snapshot := obj.(*api.Snapshot)
+		if snapshot.Spec.DatabaseName != postgres.Name || !scheduled.MatchString(snapshot.Name) || snapshot.DeletionTimestamp != nil ||
+			snapshot.Annotations[SnapshotImportedAnnotation] != "" {
+			continue
+		}
+		if snapshot.Status.Phase == api.SnapshotPhaseSucceeded || snapshot.Status.Phase == api.SnapshotPhaseFailed {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[j].CreationTimestamp.Before(&snapshots[i].CreationTimestamp)
+	})
+
+	// A snapshot is kept, if it is one of the newest succeeded ones or younger than the window.
+	kept := 0
+	for _, snapshot := range snapshots {
+		if snapshot.Status.Phase == api.SnapshotPhaseSucceeded && kept < retain {
+			kept++
+			continue
+		}
+		if window >= 0 && time.Since(snapshot.CreationTimestamp.Time) < window {
+			continue
+		}
+
+		c.recorder.Eventf(
+			postgres,
+			core.EventTypeNormal,
+			eventReasonSnapshotRetention,
+			`Deleting Snapshot "%v" by retention policy`,
+			snapshot.Name,
+		)
+		// Snapshot data is deleted by WipeOutSnapshot, before the finalizer is removed.
+		err := c.ExtClient.KubedbV1alpha1().Snapshots(namespace).Delete(snapshot.Name, &metav1.DeleteOptions{})
+		if err != nil && !kerr.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/appscode/go/log"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	le "github.com/kubedb/postgres/pkg/leader_election"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	core_util "kmodules.xyz/client-go/core/v1"
+	"kmodules.xyz/client-go/tools/queue"
+)
+
+const eventReasonRoleMismatch = "RoleMismatch"
+
+// initRoleWatcher watches leader lock ConfigMaps and database pods, so that the role labels
+// used by the primary Service can be corrected when the leader failed to set them.
+func (c *Controller) initRoleWatcher() {
+	tweakListOptions := func(options *metav1.ListOptions) {
+		options.LabelSelector = c.selector.String()
+	}
+	c.lockInformer = c.KubeInformerFactory.InformerFor(&core.ConfigMap{}, func(client kubernetes.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+		return coreinformers.NewFilteredConfigMapInformer(
+			client,
+			c.WatchNamespace,
+			resyncPeriod,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+			tweakListOptions,
+		)
+	})
+	c.podInformer = c.KubeInformerFactory.InformerFor(&core.Pod{}, func(client kubernetes.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+		return coreinformers.NewFilteredPodInformer(
+			client,
+			c.WatchNamespace,
+			resyncPeriod,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+			tweakListOptions,
+		)
+	})
+	c.podLister = corelisters.NewPodLister(c.podInformer.GetIndexer())
+	c.roleQueue = queue.New("Role", c.MaxNumRequeues, c.NumThreads, c.runRole)
+
+	c.lockInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueRole,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldCM, ok1 := oldObj.(*core.ConfigMap)
+			newCM, ok2 := newObj.(*core.ConfigMap)
+			if !ok1 || !ok2 {
+				return
+			}
+			// Renewals only change the timestamps. Resync is kept to retry failed corrections.
+			if oldCM.ResourceVersion == newCM.ResourceVersion ||
+				leaderLockHolder(oldCM) != leaderLockHolder(newCM) {
+				c.enqueueRole(newObj)
+			}
+		},
+	})
+	c.podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueRole,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, ok1 := oldObj.(*core.Pod)
+			newPod, ok2 := newObj.(*core.Pod)
+			if !ok1 || !ok2 {
+				return
+			}
+			if oldPod.Labels[api.LabelRole] != newPod.Labels[api.LabelRole] {
+				c.enqueueRole(newObj)
+			}
+		},
+		DeleteFunc: c.enqueueRole,
+	})
+}
+
+// enqueueRole enqueues the key of the Postgres object that owns a leader lock or a database pod.
+func (c *Controller) enqueueRole(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	meta, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+	name := meta.GetLabels()[api.LabelDatabaseName]
+	if name == "" {
+		return
+	}
+	c.roleQueue.GetQueue().Add(meta.GetNamespace() + "/" + name)
+}
+
+func (c *Controller) runRole(key string) error {
+	log.Debugln("started processing role labels, key:", key)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	postgres, err := c.pgLister.Postgreses(namespace).Get(name)
+	if kerr.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if postgres.DeletionTimestamp != nil {
+		return nil
+	}
+
+	obj, exists, err := c.lockInformer.GetIndexer().GetByKey(namespace + "/" + le.GetLeaderLockName(postgres.OffshootName()))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	record, err := leaderElectionRecord(obj.(*core.ConfigMap))
+	if err != nil {
+		return err
+	}
+	if record == nil || record.HolderIdentity == "" {
+		// leader election has not finished yet
+		return nil
+	}
+	leaseDuration := time.Duration(record.LeaseDurationSeconds) * time.Second
+	if time.Now().After(record.RenewTime.Add(leaseDuration)) {
+		// lease has expired and candidates are campaigning. The next leader will update the labels.
+		return nil
+	}
+	// Give a freshly elected leader the chance to update the labels itself.
+	if grace := record.AcquireTime.Add(leaseDuration); time.Now().Before(grace) {
+		c.roleQueue.GetQueue().AddAfter(key, time.Until(grace))
+		return nil
+	}
+
+	pods, err := c.podLister.Pods(namespace).List(labels.SelectorFromSet(postgres.OffshootSelectors()))
+	if err != nil {
+		return err
+	}
+
+	// Demote others before promoting the leader, so that there is never more than one pod
+	// selected by the primary Service.
+	for _, pod := range pods {
+		if pod.Name != record.HolderIdentity {
+			if err := c.ensureRoleLabel(postgres, pod, le.RoleReplica, record.HolderIdentity); err != nil {
+				return err
+			}
+		}
+	}
+	for _, pod := range pods {
+		if pod.Name == record.HolderIdentity {
+			if err := c.ensureRoleLabel(postgres, pod, le.RolePrimary, record.HolderIdentity); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Controller) ensureRoleLabel(postgres *api.Postgres, pod *core.Pod, role, leader string) error {
+	current := pod.Labels[api.LabelRole]
+	if current == role || pod.DeletionTimestamp != nil {
+		return nil
+	}
+	if _, _, err := core_util.PatchPod(c.Client, pod, func(in *core.Pod) *core.Pod {
+		if in.Labels == nil {
+			in.Labels = map[string]string{}
+		}
+		in.Labels[api.LabelRole] = role
+		return in
+	}); err != nil {
+		return err
+	}
+
+	// A pod that has not been labeled yet is still starting up, that is not a mismatch.
+	if current != "" {
+		c.recorder.Eventf(
+			postgres,
+			core.EventTypeWarning,
+			eventReasonRoleMismatch,
+			`Pod "%v" was labeled with role "%v" while leader lock is held by "%v". Changed role to "%v"`,
+			pod.Name,
+			current,
+			leader,
+			role,
+		)
+	}
+	return nil
+}
+
+func leaderElectionRecord(cm *core.ConfigMap) (*resourcelock.LeaderElectionRecord, error) {
+	data, found := cm.Annotations[resourcelock.LeaderElectionRecordAnnotationKey]
+	if !found {
+		return nil, nil
+	}
+	var record resourcelock.LeaderElectionRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func leaderLockHolder(cm *core.ConfigMap) string {
+	record, err := leaderElectionRecord(cm)
+	if err != nil || record == nil {
+		return ""
+	}
+	return record.HolderIdentity
+}
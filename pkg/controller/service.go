@@ -6,6 +6,7 @@ import (
 	"github.com/appscode/go/log"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	"github.com/kubedb/apimachinery/pkg/eventer"
+	le "github.com/kubedb/postgres/pkg/leader_election"
 	core "k8s.io/api/core/v1"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -223,6 +224,12 @@ func (c *Controller) ensureStatsService(postgres *api.Postgres) (kutil.VerbType,
 				Port:       postgres.Spec.Monitor.Prometheus.Port,
 				TargetPort: intstr.FromString(api.PrometheusExporterPortName),
 			},
+			{
+				Name:       le.HealthPortName,
+				Protocol:   core.ProtocolTCP,
+				Port:       le.HealthPort,
+				TargetPort: intstr.FromString(le.HealthPortName),
+			},
 		})
 		return in
 	})
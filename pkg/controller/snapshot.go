@@ -2,9 +2,16 @@ package controller
 
 import (
 	"fmt"
+	"path/filepath"
 
+	"github.com/appscode/go/types"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	amv "github.com/kubedb/apimachinery/pkg/validator"
+	"github.com/kubedb/postgres/pkg/archive"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func (c *Controller) ValidateSnapshot(snapshot *api.Snapshot) error {
@@ -14,7 +21,25 @@ func (c *Controller) ValidateSnapshot(snapshot *api.Snapshot) error {
 		return fmt.Errorf(`object 'DatabaseName' is missing in '%v'`, snapshot.Spec)
 	}
 
-	if _, err := c.pgLister.Postgreses(snapshot.Namespace).Get(databaseName); err != nil {
+	// The importer completes an imported Snapshot right after creating it. Retry until then.
+	if _, found := snapshot.Annotations[SnapshotImportedAnnotation]; found {
+		return kerr.NewServiceUnavailable(fmt.Sprintf(`waiting for import of Snapshot "%s/%s"`, snapshot.Namespace, snapshot.Name))
+	}
+
+	postgres, err := c.pgLister.Postgreses(snapshot.Namespace).Get(databaseName)
+	if err != nil {
+		return err
+	}
+	if err := validateSnapshotType(snapshotType(snapshot, postgres)); err != nil {
+		return err
+	}
+	if err := validateSnapshotFormat(snapshot, postgres); err != nil {
+		return err
+	}
+	if err := validateSnapshotSource(snapshot, postgres); err != nil {
+		return err
+	}
+	if err := validateVolumeSnapshot(snapshot, postgres); err != nil {
 		return err
 	}
 
@@ -22,10 +47,155 @@ func (c *Controller) ValidateSnapshot(snapshot *api.Snapshot) error {
 }
 
 func (c *Controller) WipeOutSnapshot(snapshot *api.Snapshot) error {
-	// wipeOut not possible for local backend.
-	// Ref: https://github.com/kubedb/project/issues/261
+	if snapshotType(snapshot, nil) == SnapshotTypeVolume {
+		if err := c.wipeOutVolumeSnapshot(snapshot); err != nil {
+			return err
+		}
+	}
 	if snapshot.Spec.Local != nil {
+		return c.wipeOutLocalSnapshot(snapshot)
+	}
+	folder, err := snapshotFolder(snapshot)
+	if err != nil {
+		return err
+	}
+	return archive.DeleteSnapshot(c.Client, snapshot.Spec.Backend, snapshot.Namespace, folder)
+}
+
+// snapshotFolder returns the folder of a Snapshot in its backend. Imported Snapshots are wiped out
+// where their data was found, even if that is the folder of another namespace or Postgres, because
+// the imported Snapshot owns that data like any other Snapshot owns its own.
+func snapshotFolder(snapshot *api.Snapshot) (string, error) {
+	location, err := snapshotLocation(snapshot)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(location, snapshot.Name), nil
+}
+
+// wipeOutLocalSnapshot removes the folder of a local Snapshot through a Job, as the operator can not mount
+// the volume. It returns an error until the Job has succeeded, so that the Snapshot is kept terminating.
+// A failed Job is kept and not retried, until it is deleted by the user.
+func (c *Controller) wipeOutLocalSnapshot(snapshot *api.Snapshot) error {
+	jobName := wipeOutJobName(snapshot)
+	job, err := c.Client.BatchV1().Jobs(snapshot.Namespace).Get(jobName, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		image, err := c.snapshotToolsImage(snapshot)
+		if err != nil {
+			return err
+		}
+		if job, err = newWipeOutJob(snapshot, image); err != nil {
+			return err
+		}
+		if _, err := c.Client.BatchV1().Jobs(snapshot.Namespace).Create(job); err != nil {
+			return err
+		}
+		return fmt.Errorf(`waiting for Job "%s/%s" to remove local Snapshot data`, job.Namespace, job.Name)
+	} else if err != nil {
+		return err
+	}
+
+	switch {
+	case job.Status.Succeeded > 0:
+		deletePolicy := metav1.DeletePropagationBackground
+		if err := c.Client.BatchV1().Jobs(job.Namespace).Delete(job.Name, &metav1.DeleteOptions{
+			PropagationPolicy: &deletePolicy,
+		}); err != nil && !kerr.IsNotFound(err) {
+			return err
+		}
 		return nil
+	case job.Status.Failed > types.Int32(job.Spec.BackoffLimit):
+		return fmt.Errorf(`job "%s/%s" failed to remove local Snapshot data, delete the Job to retry`, job.Namespace, job.Name)
+	default:
+		return fmt.Errorf(`waiting for Job "%s/%s" to remove local Snapshot data`, job.Namespace, job.Name)
+	}
+}
+
+func wipeOutJobName(snapshot *api.Snapshot) string {
+	return fmt.Sprintf("%s-wipeout", snapshot.OffshootName())
+}
+
+// newWipeOutJob returns the Job, which removes the folder of a local Snapshot.
+// It is owned by the Snapshot, so that it is garbage collected with the Snapshot.
+func newWipeOutJob(snapshot *api.Snapshot, image string) (*batch.Job, error) {
+	folder, err := snapshotFolder(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	local := snapshot.Spec.Local
+	return &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      wipeOutJobName(snapshot),
+			Namespace: snapshot.Namespace,
+			// The kind label is left out, so that the Job controller of kubedb does not handle this Job.
+			Labels: map[string]string{
+				api.LabelDatabaseName: snapshot.Spec.DatabaseName,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: api.SchemeGroupVersion.String(),
+					Kind:       api.ResourceKindSnapshot,
+					Name:       snapshot.Name,
+					UID:        snapshot.UID,
+				},
+			},
+		},
+		Spec: batch.JobSpec{
+			BackoffLimit: types.Int32P(2),
+			Template: core.PodTemplateSpec{
+				Spec: core.PodSpec{
+					Containers: []core.Container{
+						{
+							Name:            "wipeout-snapshot",
+							Image:           image,
+							ImagePullPolicy: core.PullIfNotPresent,
+							Command:         []string{"rm", "-rf", filepath.Join(local.MountPath, folder)},
+							VolumeMounts: []core.VolumeMount{
+								{
+									Name:      "local",
+									MountPath: local.MountPath,
+									SubPath:   local.SubPath,
+								},
+							},
+						},
+					},
+					Volumes: []core.Volume{
+						{
+							Name:         "local",
+							VolumeSource: local.VolumeSource,
+						},
+					},
+					RestartPolicy:    core.RestartPolicyNever,
+					NodeSelector:     snapshot.Spec.PodTemplate.Spec.NodeSelector,
+					Tolerations:      snapshot.Spec.PodTemplate.Spec.Tolerations,
+					ImagePullSecrets: snapshot.Spec.PodTemplate.Spec.ImagePullSecrets,
+				},
+			},
+		},
+	}, nil
+}
+
+// snapshotToolsImage returns the tools image of the Postgres a Snapshot was taken from.
+// The Postgres may already be paused or deleted, when its Snapshots are wiped out.
+func (c *Controller) snapshotToolsImage(snapshot *api.Snapshot) (string, error) {
+	var version string
+	if postgres, err := c.ExtClient.KubedbV1alpha1().Postgreses(snapshot.Namespace).Get(snapshot.Spec.DatabaseName, metav1.GetOptions{}); err == nil {
+		version = string(postgres.Spec.Version)
+	} else if !kerr.IsNotFound(err) {
+		return "", err
+	} else if drmn, err := c.ExtClient.KubedbV1alpha1().DormantDatabases(snapshot.Namespace).Get(snapshot.Spec.DatabaseName, metav1.GetOptions{}); err == nil &&
+		drmn.Spec.Origin.Spec.Postgres != nil {
+		version = string(drmn.Spec.Origin.Spec.Postgres.Version)
+	} else if err != nil && !kerr.IsNotFound(err) {
+		return "", err
+	}
+	if version == "" {
+		return "", fmt.Errorf(`failed to find the version of Postgres "%s/%s" to wipe out Snapshot "%s"`,
+			snapshot.Namespace, snapshot.Spec.DatabaseName, snapshot.Name)
+	}
+	postgresVersion, err := c.ExtClient.CatalogV1alpha1().PostgresVersions().Get(version, metav1.GetOptions{})
+	if err != nil {
+		return "", err
 	}
-	return c.DeleteSnapshotData(snapshot)
+	return postgresVersion.Spec.Tools.Image, nil
 }
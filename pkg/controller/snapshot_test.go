@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	catalog "github.com/kubedb/apimachinery/apis/catalog/v1alpha1"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	extFake "github.com/kubedb/apimachinery/client/clientset/versioned/fake"
+	amc "github.com/kubedb/apimachinery/pkg/controller"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	store "kmodules.xyz/objectstore-api/api/v1"
+)
+
+func TestSnapshotFolder(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		backend     store.Backend
+		folder      string
+	}{
+		{
+			name:    "own folder",
+			backend: store.Backend{S3: &store.S3Spec{Bucket: "backups", Prefix: "pg"}},
+			folder:  "pg/kubedb/demo/foo/snap",
+		},
+		{
+			name:        "imported from another Postgres",
+			annotations: map[string]string{SnapshotLocationAnnotation: "pg/kubedb/other/bar"},
+			backend:     store.Backend{S3: &store.S3Spec{Bucket: "backups", Prefix: "pg"}},
+			folder:      "pg/kubedb/other/bar/snap",
+		},
+		{
+			name:        "imported to a local backend",
+			annotations: map[string]string{SnapshotLocationAnnotation: "kubedb/other/bar"},
+			backend:     store.Backend{Local: &store.LocalSpec{MountPath: "/repo"}},
+			folder:      "kubedb/other/bar/snap",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			snapshot := &api.Snapshot{
+				ObjectMeta: metav1.ObjectMeta{Name: "snap", Namespace: "demo", Annotations: c.annotations},
+				Spec: api.SnapshotSpec{
+					DatabaseName: "foo",
+					Backend:      c.backend,
+				},
+			}
+			folder, err := snapshotFolder(snapshot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if folder != c.folder {
+				t.Errorf("expected folder %q, got %q", c.folder, folder)
+			}
+		})
+	}
+}
+
+func TestWipeOutLocalSnapshot(t *testing.T) {
+	snapshot := &api.Snapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "snap",
+			Namespace:   "demo",
+			UID:         "snap-uid",
+			Annotations: map[string]string{SnapshotLocationAnnotation: "kubedb/other/bar"},
+		},
+		Spec: api.SnapshotSpec{
+			DatabaseName: "foo",
+			Backend: store.Backend{
+				Local: &store.LocalSpec{
+					MountPath: "/repo",
+					VolumeSource: core.VolumeSource{
+						PersistentVolumeClaim: &core.PersistentVolumeClaimVolumeSource{ClaimName: "repo"},
+					},
+				},
+			},
+		},
+	}
+	c := &Controller{
+		Controller: &amc.Controller{
+			Client: fake.NewSimpleClientset(),
+			ExtClient: extFake.NewSimpleClientset(
+				&api.Postgres{
+					ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "demo"},
+					Spec:       api.PostgresSpec{Version: "10.2-v2"},
+				},
+				&catalog.PostgresVersion{
+					ObjectMeta: metav1.ObjectMeta{Name: "10.2-v2"},
+					Spec:       catalog.PostgresVersionSpec{Tools: catalog.PostgresVersionTools{Image: "kubedb/postgres-tools:10.2-v2"}},
+				},
+			),
+		},
+		recorder: record.NewFakeRecorder(10),
+	}
+	jobs := c.Client.BatchV1().Jobs("demo")
+	setStatus := func(status batch.JobStatus) {
+		job, err := jobs.Get(wipeOutJobName(snapshot), metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		job.Status = status
+		if _, err := jobs.Update(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// created
+	if err := c.wipeOutLocalSnapshot(snapshot); err == nil {
+		t.Fatal("expected to wait for the created Job")
+	}
+	job, err := jobs.Get(wipeOutJobName(snapshot), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := job.Spec.Template.Spec.Containers[0]
+	if expected := []string{"rm", "-rf", "/repo/kubedb/other/bar/snap"}; !reflect.DeepEqual(container.Command, expected) {
+		t.Errorf("expected command %v, got %v", expected, container.Command)
+	}
+	if container.Image != "kubedb/postgres-tools:10.2-v2" {
+		t.Errorf("unexpected image %q", container.Image)
+	}
+	if claim := job.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim; claim == nil || claim.ClaimName != "repo" {
+		t.Errorf("expected the volume of the local backend, got %v", job.Spec.Template.Spec.Volumes)
+	}
+	if refs := job.OwnerReferences; len(refs) != 1 || refs[0].Kind != api.ResourceKindSnapshot || refs[0].UID != snapshot.UID {
+		t.Errorf("expected the Job to be owned by the Snapshot, got %v", refs)
+	}
+
+	// pending
+	setStatus(batch.JobStatus{Active: 1, Failed: 1})
+	if err := c.wipeOutLocalSnapshot(snapshot); err == nil {
+		t.Fatal("expected to wait for the active Job")
+	}
+
+	// failed
+	setStatus(batch.JobStatus{Failed: 3})
+	for i := 0; i < 2; i++ {
+		if err := c.wipeOutLocalSnapshot(snapshot); err == nil {
+			t.Fatal("expected an error for the failed Job")
+		}
+		job, err := jobs.Get(wipeOutJobName(snapshot), metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status.Failed != 3 {
+			t.Fatalf("expected the failed Job to be kept, got status %v", job.Status)
+		}
+	}
+
+	// succeeded
+	setStatus(batch.JobStatus{Failed: 1, Succeeded: 1})
+	if err := c.wipeOutLocalSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jobs.Get(wipeOutJobName(snapshot), metav1.GetOptions{}); !kerr.IsNotFound(err) {
+		t.Errorf("expected the succeeded Job to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	"github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	le "github.com/kubedb/postgres/pkg/leader_election"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	core_util "kmodules.xyz/client-go/core/v1"
+)
+
+const (
+	// Node a Snapshot is taken from, "primary", "replica" or the name of a database pod. Logical Snapshots
+	// are taken from the primary, physical and volume Snapshots from a replica by default. Set on a Snapshot, or on
+	// a Postgres as default for all of its Snapshots, including the scheduled ones.
+	SnapshotSourceAnnotation = api.PostgresKey + "/snapshot-source"
+	// Database pod a Snapshot was taken from. Snapshots fall back to the primary, if no healthy
+	// standby replica is available.
+	SnapshotSourceNodeAnnotation = api.PostgresKey + "/snapshot-source-node"
+
+	SnapshotSourcePrimary = "primary"
+	SnapshotSourceReplica = "replica"
+
+	eventReasonSnapshotSourceFallback = "SnapshotSourceFallback"
+)
+
+// snapshotSource returns the node a Snapshot should be taken from, falling back to the default of its Postgres.
+func snapshotSource(snapshot *api.Snapshot, postgres *api.Postgres) string {
+	if s := snapshot.Annotations[SnapshotSourceAnnotation]; s != "" {
+		return s
+	}
+	if s := postgres.Annotations[SnapshotSourceAnnotation]; s != "" {
+		return s
+	}
+	if t := snapshotType(snapshot, postgres); t == SnapshotTypePhysical || t == SnapshotTypeVolume {
+		return SnapshotSourceReplica
+	}
+	return SnapshotSourcePrimary
+}
+
+func validateSnapshotSource(snapshot *api.Snapshot, postgres *api.Postgres) error {
+	source := snapshotSource(snapshot, postgres)
+	if source == SnapshotSourcePrimary || source == SnapshotSourceReplica {
+		return nil
+	}
+	replicas := int32(1)
+	if postgres.Spec.Replicas != nil {
+		replicas = *postgres.Spec.Replicas
+	}
+	for i := int32(0); i < replicas; i++ {
+		if source == fmt.Sprintf("%s-%d", postgres.OffshootName(), i) {
+			return nil
+		}
+	}
+	return fmt.Errorf(`annotation "%v" must be "%v", "%v" or a pod of Postgres "%v", found "%v"`,
+		SnapshotSourceAnnotation, SnapshotSourcePrimary, SnapshotSourceReplica, postgres.Name, source)
+}
+
+// standbys returns the ready replicas of a Postgres, ordered by name. If connections are required,
+// only hot standby replicas are returned, as warm standby replicas don't accept connections at all.
+func (c *Controller) standbys(postgres *api.Postgres, connect bool) ([]*core.Pod, error) {
+	if connect && (postgres.Spec.StandbyMode == nil || *postgres.Spec.StandbyMode != api.HotPostgresStandbyMode) {
+		return nil, nil
+	}
+	pods, err := c.podLister.Pods(postgres.Namespace).List(labels.SelectorFromSet(postgres.OffshootSelectors()))
+	if err != nil {
+		return nil, err
+	}
+	var standbys []*core.Pod
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Labels[api.LabelRole] != le.RoleReplica {
+			continue
+		}
+		if ready, _ := core_util.PodRunningAndReady(*pod); ready {
+			standbys = append(standbys, pod)
+		}
+	}
+	sort.Slice(standbys, func(i, j int) bool {
+		return standbys[i].Name < standbys[j].Name
+	})
+	return standbys, nil
+}
+
+// primaryPod returns the name of the database pod labeled as primary, if there is one.
+func (c *Controller) primaryPod(postgres *api.Postgres) string {
+	pods, err := c.podLister.Pods(postgres.Namespace).List(labels.SelectorFromSet(postgres.OffshootSelectors()))
+	if err != nil {
+		return ""
+	}
+	for _, pod := range pods {
+		if pod.Labels[api.LabelRole] == le.RolePrimary {
+			return pod.Name
+		}
+	}
+	return ""
+}
+
+// snapshotSourceHost returns the host and the node a Snapshot is taken from and records the node on the Snapshot.
+// Replicas are addressed through the governing Service, so that one dump reads from a single node.
+// Volume Snapshots don't connect to the database and are taken from warm standby replicas, too.
+func (c *Controller) snapshotSourceHost(snapshot *api.Snapshot, postgres *api.Postgres) (string, string, error) {
+	source := snapshotSource(snapshot, postgres)
+	connect := snapshotType(snapshot, postgres) != SnapshotTypeVolume
+
+	host, node := postgres.ServiceName(), c.primaryPod(postgres)
+	if source != SnapshotSourcePrimary {
+		standbys, err := c.standbys(postgres, connect)
+		if err != nil {
+			return "", "", err
+		}
+		var standby *core.Pod
+		for _, pod := range standbys {
+			if source == SnapshotSourceReplica || source == pod.Name {
+				standby = pod
+				break
+			}
+		}
+		if standby != nil {
+			host = fmt.Sprintf("%s.%s.%s", standby.Name, c.GoverningService, standby.Namespace)
+			node = standby.Name
+		} else {
+			c.recorder.Eventf(
+				snapshot,
+				core.EventTypeWarning,
+				eventReasonSnapshotSourceFallback,
+				`No healthy standby replica "%v" found. Taking Snapshot from primary`,
+				source,
+			)
+		}
+	}
+
+	_, _, err := util.PatchSnapshot(c.ExtClient.KubedbV1alpha1(), snapshot, func(in *api.Snapshot) *api.Snapshot {
+		if node == "" {
+			delete(in.Annotations, SnapshotSourceNodeAnnotation)
+			return in
+		}
+		in.Annotations = core_util.UpsertMap(in.Annotations, map[string]string{
+			SnapshotSourceNodeAnnotation: node,
+		})
+		return in
+	})
+	return host, node, err
+}
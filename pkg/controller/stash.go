@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"strconv"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	amc "github.com/kubedb/apimachinery/pkg/controller"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/reference"
+	core_util "kmodules.xyz/client-go/core/v1"
+	appcat "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+	ofst "kmodules.xyz/offshoot-api/api/v1"
+	stash "stash.appscode.dev/stash/apis/stash/v1alpha1"
+	stashv1beta1 "stash.appscode.dev/stash/apis/stash/v1beta1"
+)
+
+const eventReasonStashBackup = "StashBackup"
+
+// stashBackupEnabled returns whether a Postgres is backed up with Stash instead of Snapshots.
+func stashBackupEnabled(postgres *api.Postgres) bool {
+	enabled, _ := strconv.ParseBool(postgres.Annotations[validator.StashBackupAnnotation])
+	return enabled && postgres.Spec.BackupSchedule != nil
+}
+
+func stashBackupName(postgres *api.Postgres) string {
+	return postgres.OffshootName() + "-stash"
+}
+
+func stashBackupTask(postgres *api.Postgres, version string) string {
+	if task := postgres.Annotations[validator.StashBackupTaskAnnotation]; task != "" {
+		return task
+	}
+	return "postgres-backup-" + version
+}
+
+// ensureStashBackup generates the Stash Repository and BackupConfiguration of a Postgres. It returns false,
+// if Stash is not installed, so that the Postgres is backed up with Snapshots instead.
+func (c *Controller) ensureStashBackup(postgres *api.Postgres, version string) (bool, error) {
+	if !amc.FoundStashCRDs(c.ApiExtKubeClient) {
+		c.recorder.Event(
+			postgres,
+			core.EventTypeWarning,
+			eventReasonStashBackup,
+			"Stash is not installed. Taking Snapshots instead",
+		)
+		return false, nil
+	}
+	ref, err := reference.GetReference(clientsetscheme.Scheme, postgres)
+	if err != nil {
+		return false, err
+	}
+	name := stashBackupName(postgres)
+
+	repository, err := c.StashClient.StashV1alpha1().Repositories(postgres.Namespace).Get(name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		repository = &stash.Repository{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: postgres.Namespace,
+			},
+		}
+	} else if err != nil {
+		return false, err
+	}
+	core_util.EnsureOwnerReference(&repository.ObjectMeta, ref)
+	repository.Labels = core_util.UpsertMap(repository.Labels, postgres.OffshootLabels())
+	repository.Spec.Backend = *postgres.Spec.BackupSchedule.Backend.DeepCopy()
+	repository.Spec.WipeOut = postgres.Spec.TerminationPolicy == api.TerminationPolicyWipeOut
+	if repository.ResourceVersion == "" {
+		_, err = c.StashClient.StashV1alpha1().Repositories(postgres.Namespace).Create(repository)
+	} else {
+		_, err = c.StashClient.StashV1alpha1().Repositories(postgres.Namespace).Update(repository)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	config, err := c.StashClient.StashV1beta1().BackupConfigurations(postgres.Namespace).Get(name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		config = &stashv1beta1.BackupConfiguration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: postgres.Namespace,
+			},
+		}
+	} else if err != nil {
+		return false, err
+	}
+	core_util.EnsureOwnerReference(&config.ObjectMeta, ref)
+	config.Labels = core_util.UpsertMap(config.Labels, postgres.OffshootLabels())
+	config.Spec.Schedule = postgres.Spec.BackupSchedule.CronExpression
+	config.Spec.Repository = core.LocalObjectReference{Name: name}
+	config.Spec.Task = stashv1beta1.TaskRef{Name: stashBackupTask(postgres, version)}
+	config.Spec.Target = &stashv1beta1.BackupTarget{
+		Ref: stashv1beta1.TargetRef{
+			APIVersion: appcat.SchemeGroupVersion.String(),
+			Kind:       appcat.ResourceKindApp,
+			Name:       postgres.AppBindingMeta().Name(),
+		},
+	}
+	config.Spec.RetentionPolicy.Name = "keep-last"
+	config.Spec.RetentionPolicy.KeepLast = 0
+	config.Spec.RetentionPolicy.Prune = false
+	if retain, err := strconv.Atoi(postgres.Annotations[validator.SnapshotRetainAnnotation]); err == nil {
+		config.Spec.RetentionPolicy.KeepLast = retain
+		config.Spec.RetentionPolicy.Prune = true
+	}
+	podSpec := postgres.Spec.BackupSchedule.PodTemplate.Spec
+	config.Spec.RuntimeSettings.Pod = nil
+	if podSpec.NodeSelector != nil || podSpec.Tolerations != nil || podSpec.ImagePullSecrets != nil {
+		config.Spec.RuntimeSettings.Pod = &ofst.PodRuntimeSettings{
+			NodeSelector:     podSpec.NodeSelector,
+			Tolerations:      podSpec.Tolerations,
+			ImagePullSecrets: podSpec.ImagePullSecrets,
+		}
+	}
+	if config.ResourceVersion == "" {
+		_, err = c.StashClient.StashV1beta1().BackupConfigurations(postgres.Namespace).Create(config)
+		if err == nil {
+			c.recorder.Eventf(
+				postgres,
+				core.EventTypeNormal,
+				eventReasonStashBackup,
+				`Created Stash BackupConfiguration "%v"`,
+				name,
+			)
+		}
+	} else {
+		_, err = c.StashClient.StashV1beta1().BackupConfigurations(postgres.Namespace).Update(config)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// deleteStashBackup deletes the Stash objects generated for a Postgres. The backed up data is kept,
+// unless the Repository was generated for TerminationPolicy WipeOut.
+func (c *Controller) deleteStashBackup(postgres *api.Postgres) error {
+	if !amc.FoundStashCRDs(c.ApiExtKubeClient) {
+		return nil
+	}
+	name := stashBackupName(postgres)
+
+	config, err := c.StashClient.StashV1beta1().BackupConfigurations(postgres.Namespace).Get(name, metav1.GetOptions{})
+	if err == nil && ownedBy(config.OwnerReferences, postgres) {
+		err = c.StashClient.StashV1beta1().BackupConfigurations(postgres.Namespace).Delete(name, &metav1.DeleteOptions{})
+	}
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	repository, err := c.StashClient.StashV1alpha1().Repositories(postgres.Namespace).Get(name, metav1.GetOptions{})
+	if err == nil && ownedBy(repository.OwnerReferences, postgres) {
+		err = c.StashClient.StashV1alpha1().Repositories(postgres.Namespace).Delete(name, &metav1.DeleteOptions{})
+	}
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func ownedBy(refs []metav1.OwnerReference, postgres *api.Postgres) bool {
+	for _, ref := range refs {
+		if ref.UID == postgres.UID {
+			return true
+		}
+	}
+	return false
+}
@@ -5,17 +5,21 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/appscode/go/log"
 	"github.com/appscode/go/types"
 	catalog "github.com/kubedb/apimachinery/apis/catalog/v1alpha1"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	"github.com/kubedb/apimachinery/pkg/eventer"
+	"github.com/kubedb/postgres/pkg/archive"
 	"github.com/kubedb/postgres/pkg/leader_election"
+	"github.com/kubedb/postgres/pkg/volumesnapshot"
 	apps "k8s.io/api/apps/v1"
 	core "k8s.io/api/core/v1"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/reference"
 	kutil "kmodules.xyz/client-go"
@@ -51,6 +55,28 @@ func (c *Controller) ensureStatefulSet(
 		replicas = types.Int32(postgres.Spec.Replicas)
 	}
 
+	initSnapshot, err := c.getPhysicalInitSnapshot(postgres)
+	if err != nil {
+		return kutil.VerbUnchanged, err
+	}
+	var restoreArgs []string
+	if initSnapshot != nil {
+		if restoreArgs, err = c.restoreBaseBackupArgs(initSnapshot); err != nil {
+			return kutil.VerbUnchanged, err
+		}
+		if err := c.ensureRestoreBaseBackupSecret(postgres, initSnapshot); err != nil {
+			return kutil.VerbUnchanged, err
+		}
+	}
+	initVolumeSnapshot, err := c.getInitVolumeSnapshot(postgres)
+	if err != nil {
+		return kutil.VerbUnchanged, err
+	}
+	var dataSource *core.TypedLocalObjectReference
+	if initVolumeSnapshot != nil {
+		dataSource = volumesnapshot.DataSource(initVolumeSnapshot.GetName())
+	}
+
 	statefulSet, vt, err := app_util.CreateOrPatchStatefulSet(c.Client, statefulSetMeta, func(in *apps.StatefulSet) *apps.StatefulSet {
 		in.Labels = postgres.OffshootLabels()
 		in.Annotations = postgres.Spec.PodTemplate.Controller.Annotations
@@ -81,8 +107,8 @@ func (c *Controller) ensureStatefulSet(
 				},
 				Image:          postgresVersion.Spec.DB.Image,
 				Resources:      postgres.Spec.PodTemplate.Spec.Resources,
-				LivenessProbe:  postgres.Spec.PodTemplate.Spec.LivenessProbe,
-				ReadinessProbe: postgres.Spec.PodTemplate.Spec.ReadinessProbe,
+				LivenessProbe:  livenessProbe(postgres, in),
+				ReadinessProbe: readinessProbe(postgres, in),
 				Lifecycle:      postgres.Spec.PodTemplate.Spec.Lifecycle,
 				SecurityContext: &core.SecurityContext{
 					Privileged: types.BoolP(false),
@@ -126,9 +152,19 @@ func (c *Controller) ensureStatefulSet(
 			if initSource != nil && initSource.ScriptSource != nil {
 				in = upsertInitScript(in, postgres.Spec.Init.ScriptSource.VolumeSource)
 			}
+			if initSnapshot != nil {
+				in = upsertRestoreBaseBackup(in, postgres, postgresVersion, initSnapshot, restoreArgs)
+			}
+			if initVolumeSnapshot != nil {
+				in = upsertRestoreVolumeSnapshot(in, postgres, postgresVersion)
+			}
+		} else {
+			// replicas added later are not started from the data of the VolumeSnapshot
+			in.Spec.Template.Spec.InitContainers = core_util.EnsureContainerDeleted(in.Spec.Template.Spec.InitContainers, restoreVolumeSnapshotContainer)
 		}
 
-		in = upsertDataVolume(in, postgres)
+		in = upsertDataVolume(in, postgres, dataSource)
+		in = upsertBackupEncryption(in, postgres)
 		in = upsertCustomConfig(in, postgres)
 
 		if c.EnableRBAC {
@@ -179,6 +215,11 @@ func (c *Controller) CheckStatefulSetPodStatus(statefulSet *apps.StatefulSet) er
 }
 
 func (c *Controller) ensureCombinedNode(postgres *api.Postgres, postgresVersion *catalog.PostgresVersion) (kutil.VerbType, error) {
+	postgres, err := withCloneSource(postgres)
+	if err != nil {
+		return kutil.VerbUnchanged, err
+	}
+
 	standbyMode := api.WarmPostgresStandbyMode
 	streamingMode := api.AsynchronousPostgresStreamingMode
 
@@ -266,7 +307,39 @@ func (c *Controller) ensureCombinedNode(postgres *api.Postgres, postgresVersion
 				envList = append(envList,
 					core.EnvVar{
 						Name:  "ARCHIVE_FILE_PREFIX",
-						Value: archiverStorage.Local.MountPath,
+						Value: path.Join(archiverStorage.Local.MountPath, WalDataDir(postgres)),
+					},
+				)
+			}
+			if schedule := postgres.Annotations[leader_election.BaseBackupScheduleAnnotation]; schedule != "" {
+				envList = append(envList,
+					core.EnvVar{
+						Name:  leader_election.BaseBackupScheduleEnv,
+						Value: schedule,
+					},
+				)
+			}
+			if steps := postgres.Annotations[leader_election.BaseBackupDeltaMaxStepsAnnotation]; steps != "" {
+				envList = append(envList,
+					core.EnvVar{
+						Name:  leader_election.DeltaMaxStepsEnv,
+						Value: steps,
+					},
+				)
+			}
+			if retain := postgres.Annotations[leader_election.ArchiveRetainBaseBackupsAnnotation]; retain != "" {
+				envList = append(envList,
+					core.EnvVar{
+						Name:  leader_election.ArchiveRetainBaseBackupsEnv,
+						Value: retain,
+					},
+				)
+			}
+			if window, err := time.ParseDuration(postgres.Annotations[leader_election.ArchiveRetainWindowAnnotation]); err == nil {
+				envList = append(envList,
+					core.EnvVar{
+						Name:  leader_election.ArchiveRetainWindowEnv,
+						Value: strconv.Itoa(int(window.Seconds())),
 					},
 				)
 			}
@@ -276,9 +349,15 @@ func (c *Controller) ensureCombinedNode(postgres *api.Postgres, postgresVersion
 	if postgres.Spec.Init != nil {
 		wal := postgres.Spec.Init.PostgresWAL
 		if wal != nil {
-			envList = append(envList, walRecoveryConfig(wal)...)
+			envList = append(envList, walRecoveryConfig(wal, postgres.Annotations)...)
 		}
 	}
+	cloneEnvList, err := cloneEnv(postgres)
+	if err != nil {
+		return kutil.VerbUnchanged, err
+	}
+	envList = append(envList, cloneEnvList...)
+	envList = append(envList, backupEncryptionEnv(postgres)...)
 
 	return c.ensureStatefulSet(postgres, postgresVersion, envList)
 }
@@ -373,6 +452,11 @@ func upsertPort(statefulSet *apps.StatefulSet) *apps.StatefulSet {
 				ContainerPort: PostgresPort,
 				Protocol:      core.ProtocolTCP,
 			},
+			{
+				Name:          leader_election.HealthPortName,
+				ContainerPort: leader_election.HealthPort,
+				Protocol:      core.ProtocolTCP,
+			},
 		}
 		return portList
 	}
@@ -387,6 +471,59 @@ func upsertPort(statefulSet *apps.StatefulSet) *apps.StatefulSet {
 	return statefulSet
 }
 
+// livenessProbe returns the user provided liveness probe. If there is none, the leader_election sidecar is
+// probed. It does not check postgres itself, so that a long running restore does not restart the container.
+func livenessProbe(postgres *api.Postgres, statefulSet *apps.StatefulSet) *core.Probe {
+	if postgres.Spec.PodTemplate.Spec.LivenessProbe != nil {
+		return postgres.Spec.PodTemplate.Spec.LivenessProbe
+	}
+	if !hasProbe(statefulSet, func(c core.Container) *core.Probe { return c.LivenessProbe }) {
+		return nil
+	}
+	return healthProbe("/healthz")
+}
+
+// readinessProbe returns the user provided readiness probe. If there is none, the pod is ready
+// once postgres accepts connections and a replica is within the allowed replication lag.
+func readinessProbe(postgres *api.Postgres, statefulSet *apps.StatefulSet) *core.Probe {
+	if postgres.Spec.PodTemplate.Spec.ReadinessProbe != nil {
+		return postgres.Spec.PodTemplate.Spec.ReadinessProbe
+	}
+	if !hasProbe(statefulSet, func(c core.Container) *core.Probe { return c.ReadinessProbe }) {
+		return nil
+	}
+	return healthProbe("/readyz")
+}
+
+// hasProbe returns whether the postgres container of a StatefulSet may be probed by default. Default probes
+// are added to new StatefulSets only, as changing the pod template of an existing StatefulSet restarts all
+// database pods. A StatefulSet created without probes is left without them.
+func hasProbe(statefulSet *apps.StatefulSet, probe func(core.Container) *core.Probe) bool {
+	if statefulSet.CreationTimestamp.IsZero() {
+		return true
+	}
+	for _, c := range statefulSet.Spec.Template.Spec.Containers {
+		if c.Name == api.ResourceSingularPostgres {
+			return probe(c) != nil
+		}
+	}
+	return true
+}
+
+func healthProbe(path string) *core.Probe {
+	return &core.Probe{
+		Handler: core.Handler{
+			HTTPGet: &core.HTTPGetAction{
+				Path: path,
+				Port: intstr.FromString(leader_election.HealthPortName),
+			},
+		},
+		PeriodSeconds:    10,
+		TimeoutSeconds:   10,
+		FailureThreshold: 3,
+	}
+}
+
 func (c *Controller) upsertMonitoringContainer(statefulSet *apps.StatefulSet, postgres *api.Postgres, postgresVersion *catalog.PostgresVersion) *apps.StatefulSet {
 	if postgres.GetMonitoringVendor() == mona.VendorPrometheus {
 		container := core.Container{
@@ -533,7 +670,7 @@ func upsertInitScript(statefulSet *apps.StatefulSet, script core.VolumeSource) *
 	return statefulSet
 }
 
-func upsertDataVolume(statefulSet *apps.StatefulSet, postgres *api.Postgres) *apps.StatefulSet {
+func upsertDataVolume(statefulSet *apps.StatefulSet, postgres *api.Postgres, dataSource *core.TypedLocalObjectReference) *apps.StatefulSet {
 	if postgres.Spec.Archiver != nil || postgres.Spec.Init != nil {
 		// Add a PV
 		if postgres.Spec.Archiver != nil &&
@@ -623,6 +760,13 @@ func upsertDataVolume(statefulSet *apps.StatefulSet, postgres *api.Postgres) *ap
 						"volume.beta.kubernetes.io/storage-class": *pvcSpec.StorageClassName,
 					}
 				}
+				// the data source of the volume claim template can not be changed once the StatefulSet is created
+				claim.Spec.DataSource = dataSource
+				for _, vct := range statefulSet.Spec.VolumeClaimTemplates {
+					if vct.Name == claim.Name {
+						claim.Spec.DataSource = vct.Spec.DataSource
+					}
+				}
 				statefulSet.Spec.VolumeClaimTemplates = core_util.UpsertVolumeClaim(statefulSet.Spec.VolumeClaimTemplates, claim)
 			}
 			break
@@ -658,7 +802,7 @@ func upsertCustomConfig(statefulSet *apps.StatefulSet, postgres *api.Postgres) *
 	return statefulSet
 }
 
-func walRecoveryConfig(wal *api.PostgresWALSourceSpec) []core.EnvVar {
+func walRecoveryConfig(wal *api.PostgresWALSourceSpec, annotations map[string]string) []core.EnvVar {
 	envList := []core.EnvVar{
 		{
 			Name:  "RESTORE",
@@ -710,6 +854,14 @@ func walRecoveryConfig(wal *api.PostgresWALSourceSpec) []core.EnvVar {
 				Value: archiveSource,
 			},
 		)
+		if legacyPrefix := legacyLocalArchivePrefix(wal.Local); legacyPrefix != "" {
+			envList = append(envList,
+				core.EnvVar{
+					Name:  "RESTORE_FILE_LEGACY_PREFIX",
+					Value: legacyPrefix,
+				},
+			)
+		}
 	}
 
 	if wal.PITR != nil {
@@ -752,5 +904,38 @@ func walRecoveryConfig(wal *api.PostgresWALSourceSpec) []core.EnvVar {
 				}...)
 		}
 	}
+
+	// recovery targets that spec.init.postgresWAL.pitr has no field for
+	lsn := annotations[archive.RecoveryTargetLSNAnnotation]
+	name := annotations[archive.RecoveryTargetNameAnnotation]
+	if (lsn != "" || name != "") && wal.PITR == nil {
+		envList = append(envList,
+			[]core.EnvVar{
+				{
+					Name:  "PITR",
+					Value: "true",
+				},
+				{
+					Name:  "TARGET_INCLUSIVE",
+					Value: "true",
+				},
+			}...)
+	}
+	if lsn != "" {
+		envList = append(envList,
+			core.EnvVar{
+				Name:  "TARGET_LSN",
+				Value: lsn,
+			},
+		)
+	}
+	if name != "" {
+		envList = append(envList,
+			core.EnvVar{
+				Name:  "TARGET_NAME",
+				Value: name,
+			},
+		)
+	}
 	return envList
 }
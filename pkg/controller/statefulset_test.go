@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDefaultProbes(t *testing.T) {
+	statefulSet := func(probe *core.Probe) *apps.StatefulSet {
+		return &apps.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.Now()},
+			Spec: apps.StatefulSetSpec{
+				Template: core.PodTemplateSpec{
+					Spec: core.PodSpec{
+						Containers: []core.Container{
+							{Name: api.ResourceSingularPostgres, LivenessProbe: probe, ReadinessProbe: probe},
+						},
+					},
+				},
+			},
+		}
+	}
+	userProbe := &core.Probe{
+		Handler: core.Handler{Exec: &core.ExecAction{Command: []string{"pg_isready"}}},
+	}
+
+	for _, c := range []struct {
+		name        string
+		userProbe   *core.Probe
+		statefulSet *apps.StatefulSet
+		liveness    *core.Probe
+		readiness   *core.Probe
+	}{
+		{"new statefulset", nil, &apps.StatefulSet{}, healthProbe("/healthz"), healthProbe("/readyz")},
+		{"existing statefulset without probes", nil, statefulSet(nil), nil, nil},
+		{"existing statefulset with default probes", nil, statefulSet(healthProbe("/healthz")), healthProbe("/healthz"), healthProbe("/readyz")},
+		{"user probe of existing statefulset without probes", userProbe, statefulSet(nil), userProbe, userProbe},
+		{"user probe of new statefulset", userProbe, &apps.StatefulSet{}, userProbe, userProbe},
+	} {
+		postgres := &api.Postgres{}
+		postgres.Spec.PodTemplate.Spec.LivenessProbe = c.userProbe
+		postgres.Spec.PodTemplate.Spec.ReadinessProbe = c.userProbe
+
+		if probe := livenessProbe(postgres, c.statefulSet); !reflect.DeepEqual(probe, c.liveness) {
+			t.Errorf("%s: expected liveness probe %+v, got %+v", c.name, c.liveness, probe)
+		}
+		if probe := readinessProbe(postgres, c.statefulSet); !reflect.DeepEqual(probe, c.readiness) {
+			t.Errorf("%s: expected readiness probe %+v, got %+v", c.name, c.readiness, probe)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/appscode/go/types"
+	catalog "github.com/kubedb/apimachinery/apis/catalog/v1alpha1"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	"github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	"github.com/kubedb/apimachinery/pkg/eventer"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	"github.com/kubedb/postgres/pkg/volumesnapshot"
+	apps "k8s.io/api/apps/v1"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kutil "kmodules.xyz/client-go"
+	core_util "kmodules.xyz/client-go/core/v1"
+	meta_util "kmodules.xyz/client-go/meta"
+)
+
+const (
+	// Consistency of a volume Snapshot, only "crash" is supported. A crash-consistent VolumeSnapshot is
+	// restored like after a crash of the database. A VolumeSnapshot taken between pg_start_backup and
+	// pg_stop_backup would need the WAL written until pg_stop_backup, which is not part of the volume.
+	// Set on a Snapshot, or on a Postgres as default for all of its Snapshots.
+	SnapshotConsistencyAnnotation = api.PostgresKey + "/snapshot-consistency"
+	// VolumeSnapshotClass of volume Snapshots, the default class if not set. Set on a Snapshot, or on a Postgres.
+	VolumeSnapshotClassAnnotation = api.PostgresKey + "/volume-snapshot-class"
+
+	SnapshotConsistencyCrash = "crash"
+
+	restoreVolumeSnapshotContainer = "restore-volume-snapshot"
+)
+
+// snapshotConsistency returns the consistency of a volume Snapshot, falling back to the default of its Postgres.
+func snapshotConsistency(snapshot *api.Snapshot, postgres *api.Postgres) string {
+	if c := snapshot.Annotations[SnapshotConsistencyAnnotation]; c != "" {
+		return c
+	}
+	if c := postgres.Annotations[SnapshotConsistencyAnnotation]; c != "" {
+		return c
+	}
+	return SnapshotConsistencyCrash
+}
+
+// volumeSnapshotClass returns the VolumeSnapshotClass of a volume Snapshot, falling back to the default of its Postgres.
+func volumeSnapshotClass(snapshot *api.Snapshot, postgres *api.Postgres) string {
+	if c := snapshot.Annotations[VolumeSnapshotClassAnnotation]; c != "" {
+		return c
+	}
+	return postgres.Annotations[VolumeSnapshotClassAnnotation]
+}
+
+func validateVolumeSnapshot(snapshot *api.Snapshot, postgres *api.Postgres) error {
+	if snapshotType(snapshot, postgres) != SnapshotTypeVolume {
+		return nil
+	}
+	if postgres.Spec.StorageType == api.StorageTypeEphemeral {
+		return fmt.Errorf(`volume Snapshots require StorageType "%v"`, api.StorageTypeDurable)
+	}
+	if consistency := snapshotConsistency(snapshot, postgres); consistency != SnapshotConsistencyCrash {
+		return fmt.Errorf(`annotation "%v" must be "%v", found "%v"`, SnapshotConsistencyAnnotation, SnapshotConsistencyCrash, consistency)
+	}
+	return nil
+}
+
+// volumeSnapshotName returns the name of the VolumeSnapshot of a volume Snapshot.
+func volumeSnapshotName(snapshot *api.Snapshot) string {
+	return snapshot.Name
+}
+
+// getVolumeSnapshotter returns the Job that takes the VolumeSnapshot of the data volume of the source
+// pod of a Snapshot. The Job fails without retry, as the VolumeSnapshot can not be created twice.
+func (c *Controller) getVolumeSnapshotter(snapshot *api.Snapshot, postgres *api.Postgres, postgresVersion *catalog.PostgresVersion) (*batch.Job, error) {
+	_, node, err := c.snapshotSourceHost(snapshot, postgres)
+	if err != nil {
+		return nil, err
+	}
+	if node == "" {
+		return nil, fmt.Errorf(`no database pod of Postgres "%v/%v" found`, postgres.Namespace, postgres.Name)
+	}
+
+	jobLabel := postgres.OffshootLabels()
+	jobLabel[api.LabelDatabaseKind] = api.ResourceKindPostgres
+	jobLabel[api.AnnotationJobType] = api.JobTypeBackup
+
+	args := []string{
+		"volume-snapshot",
+		fmt.Sprintf(`--namespace=%s`, snapshot.Namespace),
+		fmt.Sprintf(`--name=%s`, volumeSnapshotName(snapshot)),
+		fmt.Sprintf(`--claim=data-%s`, node),
+		fmt.Sprintf(`--labels=%s=%s,%s=%s`, api.LabelDatabaseKind, api.ResourceKindPostgres, api.LabelDatabaseName, postgres.Name),
+	}
+	if class := volumeSnapshotClass(snapshot, postgres); class != "" {
+		args = append(args, fmt.Sprintf(`--class=%s`, class))
+	}
+
+	job := &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-%s", api.DatabaseNamePrefix, snapshot.OffshootName()),
+			Labels:      jobLabel,
+			Annotations: snapshot.Spec.PodTemplate.Controller.Annotations,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: api.SchemeGroupVersion.String(),
+					Kind:       api.ResourceKindSnapshot,
+					Name:       snapshot.Name,
+					UID:        snapshot.UID,
+				},
+			},
+		},
+		Spec: batch.JobSpec{
+			BackoffLimit: types.Int32P(0),
+			Template: core.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: snapshot.Spec.PodTemplate.Annotations,
+				},
+				Spec: core.PodSpec{
+					Containers: []core.Container{
+						{
+							Name:      api.JobTypeBackup,
+							Image:     postgresVersion.Spec.DB.Image,
+							Args:      args,
+							Resources: snapshot.Spec.PodTemplate.Spec.Resources,
+						},
+					},
+					RestartPolicy:     core.RestartPolicyNever,
+					NodeSelector:      snapshot.Spec.PodTemplate.Spec.NodeSelector,
+					Affinity:          snapshot.Spec.PodTemplate.Spec.Affinity,
+					SchedulerName:     snapshot.Spec.PodTemplate.Spec.SchedulerName,
+					Tolerations:       snapshot.Spec.PodTemplate.Spec.Tolerations,
+					PriorityClassName: snapshot.Spec.PodTemplate.Spec.PriorityClassName,
+					Priority:          snapshot.Spec.PodTemplate.Spec.Priority,
+					SecurityContext:   snapshot.Spec.PodTemplate.Spec.SecurityContext,
+					ImagePullSecrets: core_util.MergeLocalObjectReferences(
+						snapshot.Spec.PodTemplate.Spec.ImagePullSecrets,
+						postgres.Spec.PodTemplate.Spec.ImagePullSecrets,
+					),
+				},
+			},
+		},
+	}
+
+	if c.EnableRBAC {
+		if snapshot.Spec.PodTemplate.Spec.ServiceAccountName == "" {
+			job.Spec.Template.Spec.ServiceAccountName = postgres.SnapshotSAName()
+			if err := c.ensureSnapshotRBAC(postgres); err != nil {
+				return nil, err
+			}
+		} else {
+			job.Spec.Template.Spec.ServiceAccountName = snapshot.Spec.PodTemplate.Spec.ServiceAccountName
+		}
+	}
+	return job, nil
+}
+
+// wipeOutVolumeSnapshot deletes the VolumeSnapshot of a volume Snapshot.
+func (c *Controller) wipeOutVolumeSnapshot(snapshot *api.Snapshot) error {
+	err := c.DynamicClient.Resource(volumesnapshot.Resource).Namespace(snapshot.Namespace).Delete(volumeSnapshotName(snapshot), &metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// getInitVolumeSnapshot returns the VolumeSnapshot a Postgres is initialized from, if it has not been
+// initialized yet. It is named by InitVolumeSnapshotAnnotation or is the one of a volume Snapshot in spec.init.
+func (c *Controller) getInitVolumeSnapshot(postgres *api.Postgres) (*unstructured.Unstructured, error) {
+	if _, err := meta_util.GetString(postgres.Annotations, api.AnnotationInitialized); err != kutil.ErrNotFound {
+		return nil, nil
+	}
+	name := postgres.Annotations[validator.InitVolumeSnapshotAnnotation]
+	if name == "" {
+		if postgres.Spec.Init == nil || postgres.Spec.Init.SnapshotSource == nil {
+			return nil, nil
+		}
+		snapshotSource := postgres.Spec.Init.SnapshotSource
+		namespace := snapshotSource.Namespace
+		if namespace == "" {
+			namespace = postgres.Namespace
+		}
+		snapshot, err := c.ExtClient.KubedbV1alpha1().Snapshots(namespace).Get(snapshotSource.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if snapshotType(snapshot, nil) != SnapshotTypeVolume {
+			return nil, nil
+		}
+		if namespace != postgres.Namespace {
+			return nil, fmt.Errorf(`volume Snapshot "%v/%v" can only be restored in namespace "%v"`, namespace, snapshot.Name, namespace)
+		}
+		name = volumeSnapshotName(snapshot)
+	}
+
+	vs, err := c.DynamicClient.Resource(volumesnapshot.Resource).Namespace(postgres.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if ready, err := volumesnapshot.Ready(vs); err != nil || !ready {
+		return nil, fmt.Errorf(`VolumeSnapshot "%v/%v" is not ready to use. Reason: %v`, postgres.Namespace, name, err)
+	}
+	return vs, nil
+}
+
+// upsertRestoreVolumeSnapshot adds the init container that prepares the data directory of a data volume
+// provisioned from a VolumeSnapshot. The volume is marked with the UID of the Postgres, so that a data
+// directory is prepared once and volume snapshots of the Postgres itself are recognized. The data directory
+// is recovered like after a crash, as VolumeSnapshots are crash-consistent.
+func upsertRestoreVolumeSnapshot(
+	statefulSet *apps.StatefulSet,
+	postgres *api.Postgres,
+	postgresVersion *catalog.PostgresVersion,
+) *apps.StatefulSet {
+	script := strings.Join([]string{
+		`set -e`,
+		`marker="/var/pv/.kubedb-restored-$POSTGRES_UID"`,
+		`if [ -e "$PGDATA/PG_VERSION" ] && [ ! -e "$marker" ]; then`,
+		`  rm -f "$PGDATA/postmaster.pid"`,
+		`  touch "$PGDATA/kubedb-snapshot-restored"`,
+		`  chown -R postgres:postgres "$PGDATA"`,
+		`  touch "$marker"`,
+		`fi`,
+	}, "\n")
+	container := core.Container{
+		Name:            restoreVolumeSnapshotContainer,
+		Image:           postgresVersion.Spec.DB.Image,
+		ImagePullPolicy: core.PullIfNotPresent,
+		Command:         []string{"/bin/sh", "-c", script},
+		Env: []core.EnvVar{
+			{
+				Name:  "PGDATA",
+				Value: "/var/pv/data",
+			},
+			{
+				Name:  "POSTGRES_UID",
+				Value: string(postgres.UID),
+			},
+		},
+		VolumeMounts: []core.VolumeMount{
+			{
+				Name:      "data",
+				MountPath: "/var/pv",
+			},
+		},
+	}
+	statefulSet.Spec.Template.Spec.InitContainers = core_util.UpsertContainer(statefulSet.Spec.Template.Spec.InitContainers, container)
+	return statefulSet
+}
+
+// initializeFromVolumeSnapshot marks a Postgres initialized from a VolumeSnapshot. The data volumes have
+// been provisioned from the VolumeSnapshot, once the database pods are running.
+func (c *Controller) initializeFromVolumeSnapshot(postgres *api.Postgres, vs *unstructured.Unstructured) error {
+	pg, _, err := util.PatchPostgres(c.ExtClient.KubedbV1alpha1(), postgres, func(in *api.Postgres) *api.Postgres {
+		in.Annotations = core_util.UpsertMap(in.Annotations, map[string]string{
+			api.AnnotationInitialized: "",
+		})
+		return in
+	})
+	if err != nil {
+		return err
+	}
+	postgres.Annotations = pg.Annotations
+	c.recorder.Eventf(
+		postgres,
+		core.EventTypeNormal,
+		eventer.EventReasonSuccessfulInitialize,
+		`Initialized from VolumeSnapshot "%v/%v"`,
+		vs.GetNamespace(),
+		vs.GetName(),
+	)
+	return nil
+}
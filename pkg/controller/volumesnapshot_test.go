@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	catalog "github.com/kubedb/apimachinery/apis/catalog/v1alpha1"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	extFake "github.com/kubedb/apimachinery/client/clientset/versioned/fake"
+	amc "github.com/kubedb/apimachinery/pkg/controller"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func annotatedSnapshot(annotations map[string]string) *api.Snapshot {
+	return &api.Snapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "snap",
+			Namespace:   "demo",
+			Annotations: annotations,
+		},
+		Spec: api.SnapshotSpec{DatabaseName: "foo"},
+	}
+}
+
+func TestValidateVolumeSnapshot(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		snapshot    map[string]string
+		postgres    map[string]string
+		storageType api.StorageType
+		consistency string
+		valid       bool
+	}{
+		{"logical snapshot", nil, nil, api.StorageTypeEphemeral, SnapshotConsistencyCrash, true},
+		{"volume snapshot", map[string]string{SnapshotTypeAnnotation: SnapshotTypeVolume}, nil, api.StorageTypeDurable, SnapshotConsistencyCrash, true},
+		{"volume default of postgres", nil, map[string]string{SnapshotTypeAnnotation: SnapshotTypeVolume}, api.StorageTypeDurable, SnapshotConsistencyCrash, true},
+		{"crash consistency of snapshot",
+			map[string]string{SnapshotTypeAnnotation: SnapshotTypeVolume, SnapshotConsistencyAnnotation: SnapshotConsistencyCrash},
+			map[string]string{SnapshotConsistencyAnnotation: "backup"},
+			api.StorageTypeDurable, SnapshotConsistencyCrash, true},
+		{"backup consistency of snapshot",
+			map[string]string{SnapshotTypeAnnotation: SnapshotTypeVolume, SnapshotConsistencyAnnotation: "backup"},
+			nil, api.StorageTypeDurable, "backup", false},
+		{"backup consistency of postgres",
+			map[string]string{SnapshotTypeAnnotation: SnapshotTypeVolume},
+			map[string]string{SnapshotConsistencyAnnotation: "backup"},
+			api.StorageTypeDurable, "backup", false},
+		{"ephemeral storage", map[string]string{SnapshotTypeAnnotation: SnapshotTypeVolume}, nil, api.StorageTypeEphemeral, SnapshotConsistencyCrash, false},
+		{"invalid consistency",
+			map[string]string{SnapshotTypeAnnotation: SnapshotTypeVolume, SnapshotConsistencyAnnotation: "application"},
+			nil, api.StorageTypeDurable, "application", false},
+	} {
+		snapshot := annotatedSnapshot(c.snapshot)
+		postgres := &api.Postgres{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "demo", Annotations: c.postgres},
+			Spec:       api.PostgresSpec{StorageType: c.storageType},
+		}
+		if err := validateVolumeSnapshot(snapshot, postgres); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid=%v, got error: %v", c.name, c.valid, err)
+		}
+		if consistency := snapshotConsistency(snapshot, postgres); consistency != c.consistency {
+			t.Errorf("%s: expected consistency %v, got %v", c.name, c.consistency, consistency)
+		}
+	}
+}
+
+func TestVolumeSnapshotClass(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		snapshot map[string]string
+		postgres map[string]string
+		class    string
+	}{
+		{"default class", nil, nil, ""},
+		{"class of postgres", nil, map[string]string{VolumeSnapshotClassAnnotation: "csi-gce-pd"}, "csi-gce-pd"},
+		{"class of snapshot",
+			map[string]string{VolumeSnapshotClassAnnotation: "csi-hostpath"},
+			map[string]string{VolumeSnapshotClassAnnotation: "csi-gce-pd"},
+			"csi-hostpath"},
+	} {
+		postgres := &api.Postgres{ObjectMeta: metav1.ObjectMeta{Annotations: c.postgres}}
+		if class := volumeSnapshotClass(annotatedSnapshot(c.snapshot), postgres); class != c.class {
+			t.Errorf("%s: expected class %q, got %q", c.name, c.class, class)
+		}
+	}
+}
+
+func TestUpsertRestoreVolumeSnapshot(t *testing.T) {
+	postgres := &api.Postgres{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "demo", UID: "1234"}}
+	postgresVersion := &catalog.PostgresVersion{
+		Spec: catalog.PostgresVersionSpec{DB: catalog.PostgresVersionDatabase{Image: "kubedb/postgres:10.2-v2"}},
+	}
+
+	statefulSet := &apps.StatefulSet{}
+	for i := 0; i < 2; i++ {
+		statefulSet = upsertRestoreVolumeSnapshot(statefulSet, postgres, postgresVersion)
+	}
+	containers := statefulSet.Spec.Template.Spec.InitContainers
+	if len(containers) != 1 || containers[0].Name != restoreVolumeSnapshotContainer {
+		t.Fatalf("expected one init container %v, got %+v", restoreVolumeSnapshotContainer, containers)
+	}
+	env := map[string]string{}
+	for _, e := range containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["POSTGRES_UID"] != "1234" {
+		t.Errorf("unexpected environment %v", env)
+	}
+	// the data directory is recovered like after a crash, without a backup label, which would require the
+	// WAL written until the end of the backup
+	script := strings.Join(containers[0].Command, " ")
+	if strings.Contains(script, "backup_label") || !strings.Contains(script, "kubedb-snapshot-restored") {
+		t.Errorf("unexpected restore script %q", script)
+	}
+	if containers[0].Image != postgresVersion.Spec.DB.Image {
+		t.Errorf("expected image %v, got %v", postgresVersion.Spec.DB.Image, containers[0].Image)
+	}
+}
+
+func TestGetInitVolumeSnapshotWithoutVolumeSnapshot(t *testing.T) {
+	c := &Controller{
+		Controller: &amc.Controller{
+			Client: fake.NewSimpleClientset(),
+			ExtClient: extFake.NewSimpleClientset(
+				annotatedSnapshot(nil),
+				&api.Snapshot{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "volume",
+						Namespace:   "prod",
+						Annotations: map[string]string{SnapshotTypeAnnotation: SnapshotTypeVolume},
+					},
+				},
+			),
+		},
+		recorder: record.NewFakeRecorder(10),
+	}
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		init        *api.InitSpec
+		valid       bool
+	}{
+		{"no init", nil, nil, true},
+		{"initialized", map[string]string{api.AnnotationInitialized: "", validator.InitVolumeSnapshotAnnotation: "foo-snapshot"}, nil, true},
+		{"logical snapshot", nil, &api.InitSpec{SnapshotSource: &api.SnapshotSourceSpec{Name: "snap"}}, true},
+		{"volume snapshot of other namespace", nil, &api.InitSpec{SnapshotSource: &api.SnapshotSourceSpec{Namespace: "prod", Name: "volume"}}, false},
+		{"missing snapshot", nil, &api.InitSpec{SnapshotSource: &api.SnapshotSourceSpec{Name: "missing"}}, false},
+	} {
+		postgres := &api.Postgres{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "demo", Annotations: tc.annotations},
+			Spec:       api.PostgresSpec{Init: tc.init},
+		}
+		vs, err := c.getInitVolumeSnapshot(postgres)
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: expected valid=%v, got error: %v", tc.name, tc.valid, err)
+		}
+		if vs != nil {
+			t.Errorf("%s: expected no VolumeSnapshot, got %v", tc.name, vs.GetName())
+		}
+	}
+}
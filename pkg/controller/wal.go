@@ -2,15 +2,37 @@ package controller
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/appscode/go/log"
+	"github.com/appscode/go/types"
 	"github.com/graymeta/stow"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	"github.com/kubedb/postgres/pkg/archive"
+	le "github.com/kubedb/postgres/pkg/leader_election"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"kmodules.xyz/client-go/tools/queue"
+	store "kmodules.xyz/objectstore-api/api/v1"
 	"kmodules.xyz/objectstore-api/osm"
 )
 
+const (
+	// Period in which the base backups of the wal archives are listed to publish their recoverable window.
+	archiveListPeriod = 5 * time.Minute
+	// Period in which the WAL segments are listed to publish the end of the recoverable window.
+	walListPeriod = 30 * time.Minute
+)
+
+// WalDataDir returns the directory of the wal archive of a Postgres inside its bucket or,
+// for local storage, relative to the mount path. All pods of a Postgres archive into the same
+// directory, so that the history of every timeline is kept in one place across failovers.
 func WalDataDir(postgres *api.Postgres) string {
 	spec := postgres.Spec.Archiver.Storage
 	if spec.S3 != nil {
@@ -22,11 +44,22 @@ func WalDataDir(postgres *api.Postgres) string {
 	} else if spec.Swift != nil {
 		return filepath.Join(spec.Swift.Prefix, api.DatabaseNamePrefix, postgres.Namespace, postgres.Name, "archive")
 	} else if spec.Local != nil {
-		return os.Getenv("RESTORE_FILE_PREFIX") //never gets called
+		return filepath.Join(api.DatabaseNamePrefix, postgres.Namespace, postgres.Name, "archive")
 	}
 	return ""
 }
 
+// legacyLocalArchivePrefix returns the prefix of the directories a local archive was kept in before it
+// was moved to the directory of its database, given the backend of that directory. Each pod used to
+// archive into a directory named after its hostname, which is the name of the database and an ordinal.
+func legacyLocalArchivePrefix(local *store.LocalSpec) string {
+	parts := strings.Split(strings.Trim(filepath.Clean(local.SubPath), "/"), "/")
+	if len(parts) != 4 || parts[0] != api.DatabaseNamePrefix || parts[3] != "archive" {
+		return ""
+	}
+	return filepath.Join("/", local.MountPath, parts[2])
+}
+
 func (c *Controller) wipeOutWalData(meta metav1.ObjectMeta, spec *api.PostgresSpec) error {
 	if spec == nil {
 		return fmt.Errorf("wipeout wal data failed. Reason: invalid postgres spec")
@@ -42,8 +75,7 @@ func (c *Controller) wipeOutWalData(meta metav1.ObjectMeta, spec *api.PostgresSp
 		return nil
 	}
 	if postgres.Spec.Archiver.Storage.Local != nil {
-		// Do not remove local Data.
-		return nil
+		return c.wipeOutLocalWalData(postgres)
 	}
 	cfg, err := osm.NewOSMContext(c.Client, *postgres.Spec.Archiver.Storage, postgres.Namespace)
 	if err != nil {
@@ -83,3 +115,156 @@ func (c *Controller) wipeOutWalData(meta metav1.ObjectMeta, spec *api.PostgresSp
 
 	return nil
 }
+
+// wipeOutLocalWalData removes a local wal archive through a Job, as the operator can not mount the volume.
+// It returns an error until the Job has succeeded, so that the caller retries.
+func (c *Controller) wipeOutLocalWalData(postgres *api.Postgres) error {
+	jobName := fmt.Sprintf("%s-wipeout-archive", postgres.OffshootName())
+	job, err := c.Client.BatchV1().Jobs(postgres.Namespace).Get(jobName, metav1.GetOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		if job.Status.Succeeded == 0 && job.Status.Failed <= types.Int32(job.Spec.BackoffLimit) {
+			return fmt.Errorf(`waiting for Job "%s/%s" to remove local wal archive`, job.Namespace, job.Name)
+		}
+		deletePolicy := metav1.DeletePropagationBackground
+		if err := c.Client.BatchV1().Jobs(job.Namespace).Delete(job.Name, &metav1.DeleteOptions{
+			PropagationPolicy: &deletePolicy,
+		}); err != nil && !kerr.IsNotFound(err) {
+			return err
+		}
+		if job.Status.Succeeded == 0 {
+			return fmt.Errorf(`job "%s/%s" failed to remove local wal archive`, job.Namespace, job.Name)
+		}
+		return nil
+	}
+
+	postgresVersion, err := c.ExtClient.CatalogV1alpha1().PostgresVersions().Get(string(postgres.Spec.Version), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	local := postgres.Spec.Archiver.Storage.Local
+	job = &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: postgres.Namespace,
+			// The kind label is left out, so that the Job controller of kubedb does not handle this Job.
+			Labels: map[string]string{
+				api.LabelDatabaseName: postgres.Name,
+			},
+		},
+		Spec: batch.JobSpec{
+			BackoffLimit: types.Int32P(2),
+			Template: core.PodTemplateSpec{
+				Spec: core.PodSpec{
+					Containers: []core.Container{
+						{
+							Name:            "wipeout-archive",
+							Image:           postgresVersion.Spec.DB.Image,
+							ImagePullPolicy: core.PullIfNotPresent,
+							Command:         []string{"rm", "-rf", filepath.Join(local.MountPath, WalDataDir(postgres))},
+							VolumeMounts: []core.VolumeMount{
+								{
+									Name:      "local-archive",
+									MountPath: local.MountPath,
+								},
+							},
+						},
+					},
+					Volumes: []core.Volume{
+						{
+							Name:         "local-archive",
+							VolumeSource: local.VolumeSource,
+						},
+					},
+					RestartPolicy:    core.RestartPolicyNever,
+					NodeSelector:     postgres.Spec.PodTemplate.Spec.NodeSelector,
+					Tolerations:      postgres.Spec.PodTemplate.Spec.Tolerations,
+					ImagePullSecrets: postgres.Spec.PodTemplate.Spec.ImagePullSecrets,
+				},
+			},
+		},
+	}
+	if _, err := c.Client.BatchV1().Jobs(postgres.Namespace).Create(job); err != nil {
+		return err
+	}
+	return fmt.Errorf(`waiting for Job "%s/%s" to remove local wal archive`, job.Namespace, job.Name)
+}
+
+// publishArchiveWindows publishes base backups and the recoverable window of every archived Postgres.
+// It runs in a single goroutine, which owns walListed.
+func (c *Controller) publishArchiveWindows() {
+	postgreses, err := c.pgLister.List(labels.Everything())
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	listed := map[string]time.Time{}
+	for _, postgres := range postgreses {
+		if postgres.DeletionTimestamp != nil ||
+			postgres.Spec.Archiver == nil ||
+			postgres.Spec.Archiver.Storage == nil ||
+			postgres.Spec.Archiver.Storage.Local != nil {
+			continue
+		}
+		key := postgres.Namespace + "/" + postgres.Name
+		walListed, err := c.publishArchiveWindow(postgres, c.walListed[key])
+		listed[key] = walListed
+		if err != nil {
+			log.Errorf("failed to list wal archive of Postgres %s/%s. Reason: %v", postgres.Namespace, postgres.Name, err)
+			continue
+		}
+		if postgres.Annotations[validator.BackupDrillAnnotation] == validator.BackupDrillArchive {
+			queue.Enqueue(c.drillQueue.GetQueue(), postgres)
+		}
+	}
+	c.walListed = listed
+}
+
+// publishArchiveWindow publishes the base backups and the recoverable window of a Postgres in its status ConfigMap.
+// The WAL segments are listed only once per walListPeriod or when the base backups have changed, as that pages
+// through the whole archive. It returns the time the WAL segments were last listed.
+func (c *Controller) publishArchiveWindow(postgres *api.Postgres, walListed time.Time) (time.Time, error) {
+	status, err := le.GetStatus(c.Client, postgres.Namespace, postgres.OffshootName())
+	if err != nil {
+		return walListed, err
+	}
+	backend := *postgres.Spec.Archiver.Storage
+	window, err := archive.ListBaseBackups(c.Client, backend, postgres.Namespace, WalDataDir(postgres))
+	if err != nil {
+		return walListed, err
+	}
+	backups := strings.Join(window.BaseBackupNames(), ",")
+	if backups != status[archive.BaseBackupsKey] || time.Since(walListed) >= walListPeriod {
+		if err := archive.ListSegments(c.Client, backend, postgres.Namespace, WalDataDir(postgres), window); err != nil {
+			return walListed, err
+		}
+		walListed = time.Now()
+	} else if until, err := time.Parse(time.RFC3339, status[archive.RecoverableUntilKey]); err == nil && until.After(window.Until) {
+		// keep the end of the archive found when the segments were listed last
+		window.Until = until
+	}
+
+	update := map[string]string{
+		archive.BaseBackupsKey:        backups,
+		archive.RecoverableFromKey:    "",
+		archive.RecoverableUntilKey:   "",
+		archive.LastBaseBackupKey:     "",
+		archive.LastBaseBackupTimeKey: "",
+	}
+	if len(window.BaseBackups) > 0 {
+		last := window.BaseBackups[len(window.BaseBackups)-1]
+		update[archive.RecoverableFromKey] = window.From.UTC().Format(time.RFC3339)
+		update[archive.RecoverableUntilKey] = window.Until.UTC().Format(time.RFC3339)
+		update[archive.LastBaseBackupKey] = last.Name
+		update[archive.LastBaseBackupTimeKey] = last.Finished.UTC().Format(time.RFC3339)
+	}
+	if last := update[archive.LastBaseBackupKey]; last != "" && last != status[archive.MetadataBaseBackupKey] {
+		if err := c.writeArchiveMetadata(postgres, last); err != nil {
+			return walListed, fmt.Errorf("failed to write backup metadata. Reason: %v", err)
+		}
+		update[archive.MetadataBaseBackupKey] = last
+	}
+	return walListed, le.UpdateStatus(c.Client, postgres.Namespace, postgres.OffshootName(), update)
+}
@@ -0,0 +1,26 @@
+package controller
+
+import (
+	"testing"
+
+	store "kmodules.xyz/objectstore-api/api/v1"
+)
+
+func TestLegacyLocalArchivePrefix(t *testing.T) {
+	for _, c := range []struct {
+		subPath string
+		prefix  string
+	}{
+		{"kubedb/demo/foo/archive", "/var/pv/foo"},
+		{"/kubedb/demo/foo/archive", "/var/pv/foo"},
+		{"kubedb/demo/foo/archive/", "/var/pv/foo"},
+		{"foo-0", ""},
+		{"", ""},
+		{"backup/demo/foo/archive", ""},
+	} {
+		local := &store.LocalSpec{MountPath: "/var/pv", SubPath: c.subPath}
+		if prefix := legacyLocalArchivePrefix(local); prefix != c.prefix {
+			t.Errorf("sub path %q: expected prefix %q, got %q", c.subPath, c.prefix, prefix)
+		}
+	}
+}
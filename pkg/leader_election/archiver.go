@@ -0,0 +1,237 @@
+package leader_election
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kubedb/apimachinery/pkg/eventer"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ArchiverCheckPeriodEnv = "ARCHIVER_CHECK_PERIOD"
+
+	// State of archive_command in the status ConfigMap, taken from pg_stat_archiver by the primary.
+	ArchiverHealthyKey        = "archiver-healthy"
+	ArchiverLastFailedWALKey  = "archiver-last-failed-wal"
+	ArchiverLastFailedTimeKey = "archiver-last-failed-time"
+
+	EventReasonArchiverHealthy   = "ArchiverHealthy"
+	EventReasonArchiverUnhealthy = "ArchiverUnhealthy"
+)
+
+var (
+	archivedCountDesc = prometheus.NewDesc("postgres_archiver_archived_count",
+		"Number of WAL files that have been successfully archived.", nil, nil)
+	failedCountDesc = prometheus.NewDesc("postgres_archiver_failed_count",
+		"Number of failed attempts for archiving WAL files.", nil, nil)
+	lastArchiveAgeDesc = prometheus.NewDesc("postgres_archiver_last_archive_age_seconds",
+		"Time since the last WAL file was successfully archived.", nil, nil)
+	pendingSegmentsDesc = prometheus.NewDesc("postgres_archiver_pending_wal_segments",
+		"Number of WAL segments waiting to be archived.", nil, nil)
+	archiverHealthyDesc = prometheus.NewDesc("postgres_archiver_healthy",
+		"1 if the last archive attempt succeeded, 0 otherwise.", nil, nil)
+)
+
+// archiverCollector exports the last state of pg_stat_archiver. Only the primary archives WAL files,
+// so nothing is exported by replicas, where the counters would be zero and the archiver unhealthy.
+type archiverCollector struct {
+	checker *healthChecker
+
+	mu      sync.Mutex
+	stats   *archiverStats
+	pending int
+}
+
+func newArchiverCollector(checker *healthChecker) *archiverCollector {
+	return &archiverCollector{checker: checker}
+}
+
+func (c *archiverCollector) update(stats *archiverStats, pending int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats, c.pending = stats, pending
+}
+
+func (c *archiverCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- archivedCountDesc
+	ch <- failedCountDesc
+	ch <- lastArchiveAgeDesc
+	ch <- pendingSegmentsDesc
+	ch <- archiverHealthyDesc
+}
+
+func (c *archiverCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	stats, pending := c.stats, c.pending
+	c.mu.Unlock()
+	if stats == nil || !c.checker.isPrimary() {
+		return
+	}
+
+	healthy := 0.0
+	if stats.healthy() {
+		healthy = 1
+	}
+	ch <- prometheus.MustNewConstMetric(archivedCountDesc, prometheus.GaugeValue, float64(stats.archivedCount))
+	ch <- prometheus.MustNewConstMetric(failedCountDesc, prometheus.GaugeValue, float64(stats.failedCount))
+	if stats.lastArchivedTime.Valid {
+		ch <- prometheus.MustNewConstMetric(lastArchiveAgeDesc, prometheus.GaugeValue, time.Since(stats.lastArchivedTime.Time).Seconds())
+	}
+	ch <- prometheus.MustNewConstMetric(pendingSegmentsDesc, prometheus.GaugeValue, float64(pending))
+	ch <- prometheus.MustNewConstMetric(archiverHealthyDesc, prometheus.GaugeValue, healthy)
+}
+
+type archiverStats struct {
+	archivedCount    int64
+	failedCount      int64
+	lastArchivedTime pq.NullTime
+	lastFailedWAL    string
+	lastFailedTime   pq.NullTime
+}
+
+// healthy returns false, if the last attempt to archive a WAL file has failed.
+func (s *archiverStats) healthy() bool {
+	if !s.lastFailedTime.Valid {
+		return true
+	}
+	return s.lastArchivedTime.Valid && s.lastArchivedTime.Time.After(s.lastFailedTime.Time)
+}
+
+// watchArchiver polls pg_stat_archiver while this pod is the primary, exports the result as metrics
+// and records changes of the archiver health in the status ConfigMap.
+func watchArchiver(ctx context.Context, period time.Duration, checker *healthChecker, notifier *notifier) {
+	collector := newArchiverCollector(checker)
+	if err := prometheus.Register(collector); err != nil {
+		log.Println("failed to register archiver metrics. Reason:", err)
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	reported := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !checker.isPrimary() {
+				continue
+			}
+			stats, err := queryArchiver(ctx, period)
+			if err != nil {
+				log.Println("failed to query pg_stat_archiver. Reason:", err)
+				continue
+			}
+
+			collector.update(stats, countReadySegments())
+
+			state := fmt.Sprintf("%t/%s", stats.healthy(), stats.lastFailedWAL)
+			if state != reported && notifier.archiver(stats) {
+				reported = state
+			}
+		}
+	}
+}
+
+func queryArchiver(ctx context.Context, timeout time.Duration) (*archiverStats, error) {
+	db, err := sql.Open("postgres", localConnectionString(timeout))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stats archiverStats
+	err = db.QueryRowContext(ctx, `SELECT archived_count, failed_count, last_archived_time,
+		COALESCE(last_failed_wal, ''), last_failed_time FROM pg_stat_archiver`).Scan(
+		&stats.archivedCount,
+		&stats.failedCount,
+		&stats.lastArchivedTime,
+		&stats.lastFailedWAL,
+		&stats.lastFailedTime,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// countReadySegments counts the WAL segments that postgres has handed over to archive_command but
+// that have not been archived yet. The WAL directory is called pg_xlog before Postgres 10.
+func countReadySegments() int {
+	pgData := os.Getenv("PGDATA")
+	count := 0
+	for _, dir := range []string{"pg_wal", "pg_xlog"} {
+		files, err := filepath.Glob(filepath.Join(pgData, dir, "archive_status", "*.ready"))
+		if err == nil {
+			count += len(files)
+		}
+	}
+	return count
+}
+
+// archiverStatus returns the status ConfigMap entries of the archiver health.
+func archiverStatus(stats *archiverStats) map[string]string {
+	status := map[string]string{
+		ArchiverHealthyKey:        strconv.FormatBool(stats.healthy()),
+		ArchiverLastFailedWALKey:  stats.lastFailedWAL,
+		ArchiverLastFailedTimeKey: "",
+	}
+	if stats.lastFailedTime.Valid {
+		status[ArchiverLastFailedTimeKey] = stats.lastFailedTime.Time.UTC().Format(time.RFC3339)
+	}
+	return status
+}
+
+// archiver records the archiver health in the status ConfigMap and emits an event when it changes.
+// It returns true, if the state was recorded.
+func (n *notifier) archiver(stats *archiverStats) bool {
+	current, err := GetStatus(n.kubeClient, n.namespace, n.name)
+	if err != nil {
+		log.Println("failed to get status to record archiver health. Reason:", err)
+		return false
+	}
+	status := archiverStatus(stats)
+	if _, changed := mergeStatus(current, status); !changed {
+		return true
+	}
+	healthChanged := current[ArchiverHealthyKey] != status[ArchiverHealthyKey]
+
+	if err := UpdateStatus(n.kubeClient, n.namespace, n.name, status); err != nil {
+		log.Println("failed to record archiver health. Reason:", err)
+		return false
+	}
+
+	if !healthChanged {
+		return true
+	}
+	postgres, err := n.extClient.KubedbV1alpha1().Postgreses(n.namespace).Get(n.name, metav1.GetOptions{})
+	if err != nil {
+		log.Println("failed to get postgres to report archiver health. Reason:", err)
+		return true
+	}
+	eventType, reason := core.EventTypeNormal, EventReasonArchiverHealthy
+	message := "WAL archiving is working"
+	if !stats.healthy() {
+		eventType, reason = core.EventTypeWarning, EventReasonArchiverUnhealthy
+		message = fmt.Sprintf(`Failed to archive WAL file "%s" %v ago. WAL files are kept in pg_wal until archiving succeeds`,
+			stats.lastFailedWAL, time.Since(stats.lastFailedTime.Time).Round(time.Second))
+	}
+	if _, err := eventer.CreateEvent(n.kubeClient, eventComponent, postgres, eventType, reason, message); err != nil {
+		log.Println("failed to create event. Reason:", err)
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package leader_election
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestArchiverStatus(t *testing.T) {
+	archived := pq.NullTime{Time: time.Date(2019, 5, 6, 10, 5, 0, 0, time.UTC), Valid: true}
+	failed := pq.NullTime{Time: time.Date(2019, 5, 6, 10, 0, 0, 0, time.UTC), Valid: true}
+
+	for _, c := range []struct {
+		name  string
+		stats archiverStats
+		want  map[string]string
+	}{
+		{"never failed",
+			archiverStats{lastArchivedTime: archived},
+			map[string]string{ArchiverHealthyKey: "true", ArchiverLastFailedWALKey: "", ArchiverLastFailedTimeKey: ""}},
+		{"archived after failure",
+			archiverStats{lastArchivedTime: archived, lastFailedWAL: "000000010000000000000003", lastFailedTime: failed},
+			map[string]string{ArchiverHealthyKey: "true", ArchiverLastFailedWALKey: "000000010000000000000003", ArchiverLastFailedTimeKey: "2019-05-06T10:00:00Z"}},
+		{"failing",
+			archiverStats{lastFailedWAL: "000000010000000000000003", lastFailedTime: failed},
+			map[string]string{ArchiverHealthyKey: "false", ArchiverLastFailedWALKey: "000000010000000000000003", ArchiverLastFailedTimeKey: "2019-05-06T10:00:00Z"}},
+	} {
+		if got := archiverStatus(&c.stats); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func collect(c prometheus.Collector) []prometheus.Metric {
+	ch := make(chan prometheus.Metric, 10)
+	c.Collect(ch)
+	close(ch)
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func TestArchiverCollector(t *testing.T) {
+	checker := newHealthChecker(3)
+	collector := newArchiverCollector(checker)
+
+	checker.setPrimary(true)
+	if metrics := collect(collector); len(metrics) != 0 {
+		t.Errorf("expected no metrics before pg_stat_archiver was queried, got %v", len(metrics))
+	}
+
+	collector.update(&archiverStats{
+		archivedCount:    3,
+		lastArchivedTime: pq.NullTime{Time: time.Now(), Valid: true},
+	}, 1)
+	if metrics := collect(collector); len(metrics) != 5 {
+		t.Errorf("expected 5 metrics on the primary, got %v", len(metrics))
+	}
+
+	checker.setPrimary(false)
+	if metrics := collect(collector); len(metrics) != 0 {
+		t.Errorf("expected no metrics on a replica, got %v", len(metrics))
+	}
+}
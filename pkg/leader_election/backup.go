@@ -0,0 +1,128 @@
+package leader_election
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	"github.com/kubedb/apimachinery/pkg/eventer"
+	cron "gopkg.in/robfig/cron.v2"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Cron schedule of wal-g base backups taken by the primary. Requires spec.archiver.
+	BaseBackupScheduleAnnotation = api.PostgresKey + "/base-backup-schedule"
+	// Number of delta backups wal-g may take on top of a full base backup. Full backups only, if not set.
+	BaseBackupDeltaMaxStepsAnnotation = api.PostgresKey + "/base-backup-delta-max-steps"
+
+	// Retention of the wal archive, applied after every base backup. When both are set,
+	// base backups are kept as long as either policy needs them.
+	ArchiveRetainBaseBackupsAnnotation = api.PostgresKey + "/archive-retain-base-backups"
+	ArchiveRetainWindowAnnotation      = api.PostgresKey + "/archive-retain-window"
+
+	// Result of the last scheduled base backup in the status ConfigMap, written by the primary.
+	// The base backups in the archive are published by the operator.
+	ScheduledBaseBackupKey       = "scheduled-base-backup"
+	ScheduledBaseBackupTimeKey   = "scheduled-base-backup-time"
+	ScheduledBaseBackupStatusKey = "scheduled-base-backup-status"
+
+	BaseBackupScheduleEnv = "BASE_BACKUP_SCHEDULE"
+	DeltaMaxStepsEnv      = "WALG_DELTA_MAX_STEPS"
+
+	ArchiveRetainBaseBackupsEnv = "ARCHIVE_RETAIN_BASE_BACKUPS"
+	ArchiveRetainWindowEnv      = "ARCHIVE_RETAIN_WINDOW_SECONDS"
+
+	BaseBackupSucceeded = "Succeeded"
+	BaseBackupFailed    = "Failed"
+
+	EventReasonBaseBackupSucceeded = "BaseBackupSucceeded"
+	EventReasonBaseBackupFailed    = "BaseBackupFailed"
+)
+
+// baseBackupScheduler pushes a base backup to the wal archive on every tick of the schedule,
+// as long as this pod is the primary. Ticks are skipped while a backup is still running.
+type baseBackupScheduler struct {
+	checker  *healthChecker
+	notifier *notifier
+	running  int32
+}
+
+func runBaseBackups(ctx context.Context, schedule string, checker *healthChecker, notifier *notifier) {
+	s := &baseBackupScheduler{checker: checker, notifier: notifier}
+
+	c := cron.New()
+	if _, err := c.AddFunc(schedule, s.backup); err != nil {
+		log.Println("invalid base backup schedule. Reason:", err)
+		return
+	}
+	c.Start()
+	<-ctx.Done()
+	c.Stop()
+}
+
+func (s *baseBackupScheduler) backup() {
+	if !s.checker.isPrimary() {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		log.Println("previous base backup is still running, skipping this one")
+		return
+	}
+	defer atomic.StoreInt32(&s.running, 0)
+
+	if err := checkPostgres(context.Background(), true, 10*time.Second); err != nil {
+		s.notifier.baseBackup("", fmt.Errorf("local postgres is not ready. Reason: %v", err))
+		return
+	}
+
+	log.Println("Pushing base backup")
+	var stdout bytes.Buffer
+	cmd := exec.Command("su-exec", "postgres", "/scripts/primary/base-backup.sh")
+	cmd.Stdout = io.MultiWriter(&stdout, os.Stdout)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		s.notifier.baseBackup("", err)
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	s.notifier.baseBackup(strings.TrimSpace(lines[len(lines)-1]), nil)
+}
+
+// baseBackup records the result of a base backup in the status ConfigMap and as an event.
+func (n *notifier) baseBackup(name string, backupErr error) {
+	postgres, err := n.extClient.KubedbV1alpha1().Postgreses(n.namespace).Get(n.name, metav1.GetOptions{})
+	if err != nil {
+		log.Println("failed to get postgres to record base backup. Reason:", err)
+		return
+	}
+
+	status := BaseBackupSucceeded
+	eventType, reason := core.EventTypeNormal, EventReasonBaseBackupSucceeded
+	message := fmt.Sprintf(`Pushed base backup "%s"`, name)
+	if backupErr != nil {
+		status = BaseBackupFailed
+		eventType, reason = core.EventTypeWarning, EventReasonBaseBackupFailed
+		message = fmt.Sprintf("Failed to push base backup. Reason: %v", backupErr)
+	}
+
+	if err := UpdateStatus(n.kubeClient, n.namespace, n.name, map[string]string{
+		ScheduledBaseBackupKey:       name,
+		ScheduledBaseBackupTimeKey:   time.Now().UTC().Format(time.RFC3339),
+		ScheduledBaseBackupStatusKey: status,
+	}); err != nil {
+		log.Println("failed to record base backup. Reason:", err)
+	}
+	if _, err := eventer.CreateEvent(n.kubeClient, eventComponent, postgres, eventType, reason, message); err != nil {
+		log.Println("failed to create event. Reason:", err)
+	}
+}
@@ -0,0 +1,175 @@
+package leader_election
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	core_util "kmodules.xyz/client-go/core/v1"
+)
+
+// fencingLock wraps a resource lock and remembers the last time the lease record was
+// successfully written by this candidate. Create and Update are only called while
+// acquiring or renewing, so a successful write means we hold the lease at that moment.
+type fencingLock struct {
+	resourcelock.Interface
+
+	mu        sync.RWMutex
+	lastRenew time.Time
+}
+
+var _ resourcelock.Interface = &fencingLock{}
+
+func newFencingLock(lock resourcelock.Interface) *fencingLock {
+	return &fencingLock{Interface: lock}
+}
+
+func (l *fencingLock) Create(ler resourcelock.LeaderElectionRecord) error {
+	if err := l.Interface.Create(ler); err != nil {
+		return err
+	}
+	l.touch()
+	return nil
+}
+
+func (l *fencingLock) Update(ler resourcelock.LeaderElectionRecord) error {
+	if err := l.Interface.Update(ler); err != nil {
+		return err
+	}
+	l.touch()
+	return nil
+}
+
+func (l *fencingLock) touch() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastRenew = time.Now()
+}
+
+func (l *fencingLock) sinceLastRenew() time.Duration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return time.Since(l.lastRenew)
+}
+
+// watchLease fences the local primary as soon as the lease has not been renewed within renewDeadline.
+// The leader elector gives up after the same deadline, but only once its own renew loop notices.
+// The watchdog does not depend on that loop, so a partitioned primary stops accepting writes
+// before any replica can acquire the lease (leaseDuration > renewDeadline).
+func watchLease(ctx context.Context, lock *fencingLock, renewDeadline, retryPeriod time.Duration) {
+	ticker := time.NewTicker(retryPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if since := lock.sinceLastRenew(); since > renewDeadline {
+				fence(fmt.Sprintf("lease was not renewed for %v (renewDeadline %v)", since.Round(time.Second), renewDeadline))
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+var fenceOnce sync.Once
+
+// fence stops the local Postgres server so that a primary which lost its lease can not accept writes anymore.
+// A fast shutdown is tried first. If it does not finish in time, the server is stopped in immediate mode.
+func fence(reason string) {
+	fenceOnce.Do(func() {
+		log.Printf("Fencing local postgres. Reason: %s\n", reason)
+
+		pgData := os.Getenv("PGDATA")
+		if _, err := os.Stat(fmt.Sprintf("%s/postmaster.pid", pgData)); os.IsNotExist(err) {
+			// postgres is not running, nothing to fence
+			return
+		}
+
+		cmd := exec.Command("su-exec", "postgres", "pg_ctl", "-D", pgData, "-m", "fast", "-t", "10", "-w", "stop")
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if err == nil {
+			return
+		}
+		log.Println("fast shutdown failed. Reason:", err)
+
+		cmd = exec.Command("su-exec", "postgres", "pg_ctl", "-D", pgData, "-m", "immediate", "-w", "stop")
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Println("immediate shutdown failed. Reason:", err)
+		}
+	})
+}
+
+// setRoleLabels labels the pod holding the lease as primary and every other pod as replica.
+func setRoleLabels(kubeClient kubernetes.Interface, namespace, statefulSetName, leader string) error {
+	statefulSet, err := kubeClient.AppsV1().StatefulSets(namespace).Get(statefulSetName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	pods, err := kubeClient.CoreV1().Pods(namespace).List(metav1.ListOptions{
+		LabelSelector: metav1.FormatLabelSelector(statefulSet.Spec.Selector),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Demote others before promoting the leader, so that there is never more than one pod
+	// selected by the primary Service.
+	for i := range pods.Items {
+		if pods.Items[i].Name != leader {
+			if err := setRoleLabel(kubeClient, &pods.Items[i], RoleReplica); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range pods.Items {
+		if pods.Items[i].Name == leader {
+			if err := setRoleLabel(kubeClient, &pods.Items[i], RolePrimary); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func setRoleLabel(kubeClient kubernetes.Interface, pod *core.Pod, role string) error {
+	if pod.Labels[api.LabelRole] == role {
+		return nil
+	}
+	_, _, err := core_util.PatchPod(kubeClient, pod, func(in *core.Pod) *core.Pod {
+		if in.Labels == nil {
+			in.Labels = map[string]string{}
+		}
+		in.Labels[api.LabelRole] = role
+		return in
+	})
+	return err
+}
+
+// demoteStalePrimaries retries until no pod other than the new leader is labeled as primary.
+// Promotion must not happen before that, otherwise the primary Service may select two writable pods.
+func demoteStalePrimaries(kubeClient kubernetes.Interface, namespace, statefulSetName, leader string, retryPeriod, timeout time.Duration) error {
+	return wait.PollImmediate(retryPeriod, timeout, func() (bool, error) {
+		if err := setRoleLabels(kubeClient, namespace, statefulSetName, leader); err != nil {
+			log.Println("failed to update role labels. Reason:", err)
+			return false, nil
+		}
+		return true, nil
+	})
+}
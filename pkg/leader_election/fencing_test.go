@@ -0,0 +1,42 @@
+package leader_election
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+type fakeLock struct {
+	resourcelock.Interface
+	err error
+}
+
+func (l *fakeLock) Create(ler resourcelock.LeaderElectionRecord) error { return l.err }
+func (l *fakeLock) Update(ler resourcelock.LeaderElectionRecord) error { return l.err }
+
+func TestFencingLock(t *testing.T) {
+	inner := &fakeLock{}
+	lock := newFencingLock(inner)
+
+	if since := lock.sinceLastRenew(); since < time.Hour {
+		t.Errorf("expected lease to be never renewed, but last renew was %v ago", since)
+	}
+
+	if err := lock.Update(resourcelock.LeaderElectionRecord{}); err != nil {
+		t.Fatal(err)
+	}
+	if since := lock.sinceLastRenew(); since > time.Second {
+		t.Errorf("expected lease to be renewed just now, but last renew was %v ago", since)
+	}
+
+	lock.lastRenew = time.Now().Add(-time.Minute)
+	inner.err = errors.New("apiserver unreachable")
+	if err := lock.Update(resourcelock.LeaderElectionRecord{}); err == nil {
+		t.Fatal("expected error from Update")
+	}
+	if since := lock.sinceLastRenew(); since < time.Minute {
+		t.Errorf("failed renew must not reset the timer, but last renew was %v ago", since)
+	}
+}
@@ -0,0 +1,213 @@
+package leader_election
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	HealthCheckPeriodEnv           = "HEALTH_CHECK_PERIOD"
+	HealthCheckFailureThresholdEnv = "HEALTH_CHECK_FAILURE_THRESHOLD"
+
+	// SQLSTATE returned while the server is starting up or running as a warm standby.
+	pqCannotConnectNow = "57P03"
+)
+
+// healthChecker periodically checks the local Postgres instance.
+// Failures are only counted after the instance has been healthy once,
+// so that initdb, restore and base backup on a fresh pod are not treated as failures.
+type healthChecker struct {
+	mu          sync.RWMutex
+	primary     bool
+	started     bool
+	everHealthy bool
+	failures    int
+	lastErr     error
+
+	threshold int
+}
+
+func newHealthChecker(threshold int) *healthChecker {
+	return &healthChecker{threshold: threshold}
+}
+
+func (h *healthChecker) setStarted() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.started = true
+}
+
+func (h *healthChecker) isStarted() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.started
+}
+
+func (h *healthChecker) setPrimary(primary bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.primary = primary
+	h.failures = 0
+}
+
+func (h *healthChecker) isPrimary() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.primary
+}
+
+func (h *healthChecker) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastErr = err
+	if err == nil {
+		h.everHealthy = true
+		h.failures = 0
+	} else if h.everHealthy {
+		h.failures++
+	}
+}
+
+// readyToLead returns false while the local instance is unhealthy or still restoring.
+// Before this process has started the local instance, there is nothing to check yet,
+// and the pod must be allowed to campaign to bootstrap the cluster.
+func (h *healthChecker) readyToLead() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if !h.started {
+		return true
+	}
+	return h.everHealthy && h.lastErr == nil
+}
+
+// failing returns true once the check has failed threshold times in a row.
+func (h *healthChecker) failing() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.failures >= h.threshold
+}
+
+// run checks the local instance every period. If this pod is the leader and the check keeps failing,
+// giveUp is called to release the lease.
+func (h *healthChecker) run(ctx context.Context, period time.Duration, giveUp func()) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !h.isStarted() {
+				continue
+			}
+
+			primary := h.isPrimary()
+			err := checkPostgres(ctx, primary, period)
+			if err != nil {
+				log.Println("health check failed. Reason:", err)
+			}
+			h.record(err)
+
+			if primary && h.failing() {
+				log.Printf("local postgres failed %d health checks in a row, giving up leadership\n", h.threshold)
+				giveUp()
+				return
+			}
+		}
+	}
+}
+
+// checkPostgres checks that the local server accepts connections.
+// On a primary it also makes sure that the server is out of recovery and is able to assign a transaction id,
+// which fails when the server can not write WAL anymore.
+func checkPostgres(ctx context.Context, primary bool, timeout time.Duration) error {
+	db, err := sql.Open("postgres", localConnectionString(timeout))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var inRecovery bool
+	if err := db.QueryRowContext(ctx, "SELECT pg_is_in_recovery()").Scan(&inRecovery); err != nil {
+		if e, ok := err.(*pq.Error); ok && e.Code == pqCannotConnectNow && !primary {
+			// warm standby does not accept connections, but the server is up and replaying WAL
+			return nil
+		}
+		return err
+	}
+	if !primary {
+		return nil
+	}
+	if inRecovery {
+		return errors.New("primary is still in recovery")
+	}
+	var xid int64
+	return db.QueryRowContext(ctx, "SELECT txid_current()").Scan(&xid)
+}
+
+func localConnectionString(timeout time.Duration) string {
+	user := os.Getenv("POSTGRES_USER")
+	if user == "" {
+		user = "postgres"
+	}
+	return fmt.Sprintf("host=127.0.0.1 port=5432 user=%s password=%s dbname=postgres sslmode=disable connect_timeout=%d",
+		user, os.Getenv("POSTGRES_PASSWORD"), int(timeout.Seconds()))
+}
+
+// healthAwareLock refuses to acquire the lease while the local instance is not ready to lead.
+// Renewing an already held lease is not affected, the health checker releases it instead.
+type healthAwareLock struct {
+	resourcelock.Interface
+
+	checker *healthChecker
+	holder  string
+}
+
+var _ resourcelock.Interface = &healthAwareLock{}
+
+func newHealthAwareLock(lock resourcelock.Interface, checker *healthChecker) *healthAwareLock {
+	return &healthAwareLock{Interface: lock, checker: checker}
+}
+
+func (l *healthAwareLock) Get() (*resourcelock.LeaderElectionRecord, error) {
+	record, err := l.Interface.Get()
+	if err == nil {
+		l.holder = record.HolderIdentity
+	}
+	return record, err
+}
+
+func (l *healthAwareLock) Create(ler resourcelock.LeaderElectionRecord) error {
+	if !l.checker.readyToLead() {
+		return errors.New("local postgres is not healthy, not campaigning for leadership")
+	}
+	return l.Interface.Create(ler)
+}
+
+func (l *healthAwareLock) Update(ler resourcelock.LeaderElectionRecord) error {
+	if l.holder != l.Identity() && !l.checker.readyToLead() {
+		return errors.New("local postgres is not healthy, not campaigning for leadership")
+	}
+	return l.Interface.Update(ler)
+}
+
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,37 @@
+package leader_election
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHealthChecker(t *testing.T) {
+	h := newHealthChecker(2)
+	if !h.readyToLead() {
+		t.Error("pod must be allowed to campaign before the local instance is started")
+	}
+
+	h.setStarted()
+	h.record(errors.New("restoring"))
+	if h.readyToLead() {
+		t.Error("pod must not campaign while the local instance is restoring")
+	}
+	if h.failing() {
+		t.Error("failures before the first successful check must not count")
+	}
+
+	h.record(nil)
+	if !h.readyToLead() {
+		t.Error("healthy pod must be allowed to campaign")
+	}
+
+	h.setPrimary(true)
+	h.record(errors.New("disk full"))
+	if h.failing() {
+		t.Error("single failure must not exceed the threshold")
+	}
+	h.record(errors.New("disk full"))
+	if !h.failing() {
+		t.Error("expected checker to report failure after reaching the threshold")
+	}
+}
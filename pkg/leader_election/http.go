@@ -0,0 +1,142 @@
+package leader_election
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/tools/leaderelection"
+)
+
+const (
+	HealthPort     = 8008
+	HealthPortName = "health"
+
+	// Maximum replay lag of a replica in seconds before it is reported as not ready. Disabled when not set.
+	MaxReplicationLagEnv = "MAX_REPLICATION_LAG"
+)
+
+// healthServer serves the probe endpoints of a database pod.
+//
+//	/healthz  the sidecar is alive and, if it is the leader, still holds the lease
+//	/readyz   the local instance accepts connections and a replica is within lag limits
+//	/primary  this pod is the leader and the instance is ready
+//	/replica  this pod is a replica and the instance is ready
+//	/metrics  metrics of the sidecar in prometheus format
+//
+// /healthz does not look at postgres at all, so that a long restore never restarts the container.
+type healthServer struct {
+	checker  *healthChecker
+	watchDog *leaderelection.HealthzAdaptor
+	maxLag   time.Duration
+	timeout  time.Duration
+}
+
+func newHealthServer(checker *healthChecker, watchDog *leaderelection.HealthzAdaptor) *healthServer {
+	return &healthServer{
+		checker:  checker,
+		watchDog: watchDog,
+		maxLag:   time.Duration(envInt(MaxReplicationLagEnv, 0)) * time.Second,
+		timeout:  5 * time.Second,
+	}
+}
+
+func (s *healthServer) ListenAndServe() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, s.watchDog.Check(r))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		_, err := s.ready(r.Context())
+		respond(w, err)
+	})
+	mux.HandleFunc("/primary", func(w http.ResponseWriter, r *http.Request) {
+		primary, err := s.ready(r.Context())
+		if err == nil && !primary {
+			err = errors.New("not primary")
+		}
+		respond(w, err)
+	})
+	mux.HandleFunc("/replica", func(w http.ResponseWriter, r *http.Request) {
+		primary, err := s.ready(r.Context())
+		if err == nil && primary {
+			err = errors.New("not replica")
+		}
+		respond(w, err)
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(fmt.Sprintf(":%d", HealthPort), mux)
+}
+
+func respond(w http.ResponseWriter, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "ok")
+}
+
+// ready returns whether this pod currently acts as primary, and an error if the instance is not ready.
+func (s *healthServer) ready(ctx context.Context) (bool, error) {
+	if !s.checker.isStarted() {
+		return false, errors.New("postgres is not started yet")
+	}
+
+	primary := s.checker.isPrimary()
+	inRecovery, lag, err := instanceStatus(ctx, s.timeout)
+	if err != nil {
+		return primary, err
+	}
+	if primary && inRecovery {
+		return primary, errors.New("primary is still in recovery")
+	}
+	if !primary && s.maxLag > 0 && lag > s.maxLag {
+		return primary, fmt.Errorf("replication lag %v exceeds %v", lag, s.maxLag)
+	}
+	return primary, nil
+}
+
+// instanceStatus returns whether the local server is in recovery and, if so, how far replay is behind.
+// A warm standby does not accept connections, its lag can not be measured and is reported as zero.
+func instanceStatus(ctx context.Context, timeout time.Duration) (bool, time.Duration, error) {
+	db, err := sql.Open("postgres", localConnectionString(timeout))
+	if err != nil {
+		return false, 0, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var inRecovery bool
+	var version int
+	if err := db.QueryRowContext(ctx, "SELECT pg_is_in_recovery(), current_setting('server_version_num')::int").Scan(&inRecovery, &version); err != nil {
+		if e, ok := err.(*pq.Error); ok && e.Code == pqCannotConnectNow {
+			return true, 0, nil
+		}
+		return false, 0, err
+	}
+	if !inRecovery {
+		return false, 0, nil
+	}
+
+	// Replay is up to date when everything received has been replayed, otherwise
+	// lag is the age of the last replayed transaction.
+	query := `SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END`
+	if version < 100000 {
+		query = `SELECT CASE WHEN pg_last_xlog_receive_location() = pg_last_xlog_replay_location() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END`
+	}
+	var seconds float64
+	if err := db.QueryRowContext(ctx, query).Scan(&seconds); err != nil {
+		return true, 0, err
+	}
+	return true, time.Duration(seconds * float64(time.Second)), nil
+}
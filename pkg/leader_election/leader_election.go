@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/appscode/go/ioutil"
+	cs "github.com/kubedb/apimachinery/client/clientset/versioned"
 	core "k8s.io/api/core/v1"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -20,7 +21,6 @@ import (
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/client-go/tools/record"
-	core_util "kmodules.xyz/client-go/core/v1"
 	"kmodules.xyz/client-go/tools/clientcmd"
 )
 
@@ -56,11 +56,18 @@ func RunLeaderElection() {
 		log.Fatalln(err)
 	}
 	clientcmd.Fix(config)
+	// Requests must not outlive the renew deadline, otherwise a partitioned primary may hang in a renew call.
+	config.Timeout = time.Duration(renewDeadline) * time.Second
 
 	kubeClient, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	extClient, err := cs.NewForConfig(config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	notifier := newNotifier(kubeClient, extClient, namespace, statefulSetName)
 
 	configMap := &core.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
@@ -72,20 +79,43 @@ func RunLeaderElection() {
 		log.Fatalln(err)
 	}
 
-	resLock := &resourcelock.ConfigMapLock{
+	checker := newHealthChecker(envInt(HealthCheckFailureThresholdEnv, 3))
+	resLock := newFencingLock(newHealthAwareLock(&resourcelock.ConfigMapLock{
 		ConfigMapMeta: configMap.ObjectMeta,
 		Client:        kubeClient.CoreV1(),
 		LockConfig: resourcelock.ResourceLockConfig{
 			Identity:      hostname,
 			EventRecorder: &record.FakeRecorder{},
 		},
-	}
+	}, checker))
 
 	runningFirstTime := true
+	currentLeader := ""
+
+	// cancelling ctx releases the lease, so that a healthy replica can take over immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	go checker.run(ctx, time.Duration(envInt(HealthCheckPeriodEnv, 10))*time.Second, cancel)
 
+	if os.Getenv("ARCHIVE") == "wal-g" {
+		go watchArchiver(ctx, time.Duration(envInt(ArchiverCheckPeriodEnv, 60))*time.Second, checker, notifier)
+		go watchRestorePoints(ctx, checker, notifier)
+	}
+	if schedule := os.Getenv(BaseBackupScheduleEnv); schedule != "" && os.Getenv("ARCHIVE") == "wal-g" {
+		go runBaseBackups(ctx, schedule, checker, notifier)
+	}
+
+	watchDog := leaderelection.NewLeaderHealthzAdaptor(time.Duration(renewDeadline) * time.Second)
 	go func() {
-		leaderelection.RunOrDie(context.Background(), leaderelection.LeaderElectionConfig{
-			Lock: resLock,
+		if err := newHealthServer(checker, watchDog).ListenAndServe(); err != nil {
+			log.Println("health server stopped. Reason:", err)
+		}
+	}()
+
+	go func() {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            resLock,
+			ReleaseOnCancel: true,
+			WatchDog:        watchDog,
 			// ref: https://github.com/kubernetes/apiserver/blob/kubernetes-1.12.0/pkg/apis/config/v1alpha1/defaults.go#L26-L52
 			LeaseDuration: time.Duration(leaseDuration) * time.Second,
 			RenewDeadline: time.Duration(renewDeadline) * time.Second,
@@ -93,33 +123,33 @@ func RunLeaderElection() {
 			Callbacks: leaderelection.LeaderCallbacks{
 				OnStartedLeading: func(ctx context.Context) {
 					fmt.Println("Got leadership, now do your jobs")
+					checker.setPrimary(true)
+					go watchLease(ctx, resLock, time.Duration(renewDeadline)*time.Second, time.Duration(retryPeriod)*time.Second)
 				},
 				OnStoppedLeading: func() {
 					fmt.Println("Lost leadership, now quit")
+					fence("lost leadership")
+					// the demotion is reported by the new leader, a partitioned primary could not deliver it
 					os.Exit(1)
 				},
 				OnNewLeader: func(identity string) {
-					statefulSet, err := kubeClient.AppsV1().StatefulSets(namespace).Get(statefulSetName, metav1.GetOptions{})
-					if err != nil {
-						log.Fatalln(err)
-					}
-
-					pods, err := kubeClient.CoreV1().Pods(namespace).List(metav1.ListOptions{
-						LabelSelector: metav1.FormatLabelSelector(statefulSet.Spec.Selector),
-					})
-					if err != nil {
-						log.Fatalln(err)
-					}
-
-					for _, pod := range pods.Items {
-						role := RoleReplica
-						if pod.Name == identity {
-							role = RolePrimary
+					oldLeader := currentLeader
+					currentLeader = identity
+
+					if identity == hostname && !runningFirstTime {
+						// Make sure the old primary is out of the primary Service before promoting this pod.
+						if err := demoteStalePrimaries(
+							kubeClient,
+							namespace,
+							statefulSetName,
+							identity,
+							time.Duration(retryPeriod)*time.Second,
+							time.Duration(leaseDuration)*time.Second,
+						); err != nil {
+							log.Fatalln("failed to demote stale primary. Reason:", err)
 						}
-						_, _, err = core_util.PatchPod(kubeClient, &pod, func(in *core.Pod) *core.Pod {
-							in.Labels["kubedb.com/role"] = role
-							return in
-						})
+					} else if err := setRoleLabels(kubeClient, namespace, statefulSetName, identity); err != nil {
+						log.Println("failed to update role labels. Reason:", err)
 					}
 
 					role := RoleReplica
@@ -129,6 +159,10 @@ func RunLeaderElection() {
 
 					if runningFirstTime {
 						runningFirstTime = false
+						checker.setStarted()
+						if identity == hostname {
+							go notifier.elected(hostname)
+						}
 						go func() {
 							// su-exec postgres /scripts/primary/run.sh
 							cmd := exec.Command("su-exec", "postgres", fmt.Sprintf("/scripts/%s/run.sh", role))
@@ -145,6 +179,10 @@ func RunLeaderElection() {
 							if !ioutil.WriteString("/tmp/pg-failover-trigger", "") {
 								log.Fatalln("Failed to create trigger file")
 							}
+							go func() {
+								notifier.demoted(oldLeader, fmt.Sprintf(`pod "%s" took over leadership`, hostname))
+								notifier.promoted(oldLeader, hostname, time.Duration(leaseDuration)*time.Second*4)
+							}()
 						}
 					}
 				},
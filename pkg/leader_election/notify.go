@@ -0,0 +1,202 @@
+package leader_election
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	cs "github.com/kubedb/apimachinery/client/clientset/versioned"
+	"github.com/kubedb/apimachinery/pkg/eventer"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Comma separated list of URLs that receive a Notification on every leadership change.
+	NotificationWebhooksAnnotation = api.PostgresKey + "/notification-webhooks"
+
+	EventReasonLeaderElected = "LeaderElected"
+	EventReasonPromoted      = "Promoted"
+	EventReasonDemoted       = "Demoted"
+
+	eventComponent = "postgres-leader-election"
+)
+
+// Notification is the JSON payload posted to notification webhooks.
+type Notification struct {
+	Reason     string      `json:"reason"`
+	Namespace  string      `json:"namespace"`
+	Postgres   string      `json:"postgres"`
+	OldPrimary string      `json:"oldPrimary,omitempty"`
+	NewPrimary string      `json:"newPrimary,omitempty"`
+	Timeline   int64       `json:"timeline,omitempty"`
+	LSN        string      `json:"lsn,omitempty"`
+	Message    string      `json:"message"`
+	Time       metav1.Time `json:"time"`
+}
+
+// notifier records leadership changes as events on the Postgres object and
+// posts them to the webhooks configured on it.
+type notifier struct {
+	kubeClient kubernetes.Interface
+	extClient  cs.Interface
+	namespace  string
+	name       string
+	httpClient *http.Client
+}
+
+func newNotifier(kubeClient kubernetes.Interface, extClient cs.Interface, namespace, name string) *notifier {
+	return &notifier{
+		kubeClient: kubeClient,
+		extClient:  extClient,
+		namespace:  namespace,
+		name:       name,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// elected is sent when this pod bootstraps the cluster as primary.
+func (n *notifier) elected(hostname string) {
+	n.notify(core.EventTypeNormal, Notification{
+		Reason:     EventReasonLeaderElected,
+		NewPrimary: hostname,
+		Message:    fmt.Sprintf(`Pod "%s" is elected as primary`, hostname),
+	})
+}
+
+// promoted waits until the local instance has left recovery and sends the new timeline and WAL position.
+func (n *notifier) promoted(oldPrimary, hostname string, timeout time.Duration) {
+	note := Notification{
+		Reason:     EventReasonPromoted,
+		OldPrimary: oldPrimary,
+		NewPrimary: hostname,
+	}
+	err := wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+		timeline, lsn, err := walPosition(context.Background(), 5*time.Second)
+		if err != nil {
+			return false, nil
+		}
+		note.Timeline, note.LSN = timeline, lsn
+		return true, nil
+	})
+	if err != nil {
+		note.Message = fmt.Sprintf(`Pod "%s" took over leadership from "%s", but did not finish promotion within %v`, hostname, oldPrimary, timeout)
+		n.notify(core.EventTypeWarning, note)
+		return
+	}
+	note.Message = fmt.Sprintf(`Pod "%s" is promoted to primary, replacing "%s". Timeline %d, LSN %s`, hostname, oldPrimary, note.Timeline, note.LSN)
+	n.notify(core.EventTypeWarning, note)
+}
+
+// demoted is sent by the new primary for the primary it replaced, as a primary that lost its lease
+// may be partitioned from the API server and the webhooks.
+func (n *notifier) demoted(oldPrimary, reason string) {
+	if oldPrimary == "" {
+		return
+	}
+	n.notify(core.EventTypeWarning, Notification{
+		Reason:     EventReasonDemoted,
+		OldPrimary: oldPrimary,
+		Message:    fmt.Sprintf(`Pod "%s" stepped down as primary. Reason: %s`, oldPrimary, reason),
+	})
+}
+
+func (n *notifier) notify(eventType string, note Notification) {
+	note.Namespace = n.namespace
+	note.Postgres = n.name
+	note.Time = metav1.Now()
+
+	postgres, err := n.extClient.KubedbV1alpha1().Postgreses(n.namespace).Get(n.name, metav1.GetOptions{})
+	if err != nil {
+		log.Println("failed to get postgres for notification. Reason:", err)
+		return
+	}
+	if _, err := eventer.CreateEvent(n.kubeClient, eventComponent, postgres, eventType, note.Reason, note.Message); err != nil {
+		log.Println("failed to create event. Reason:", err)
+	}
+
+	for _, url := range webhookURLs(postgres) {
+		if err := n.post(url, note); err != nil {
+			log.Printf("failed to notify webhook %s. Reason: %v\n", url, err)
+		}
+	}
+}
+
+func (n *notifier) post(url string, note Notification) error {
+	body, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+	return wait.ExponentialBackoff(wait.Backoff{Duration: time.Second, Factor: 2, Steps: 3}, func() (bool, error) {
+		resp, err := n.httpClient.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("attempt to notify webhook %s failed. Reason: %v\n", url, err)
+			return false, nil
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("webhook %s responded with %s\n", url, resp.Status)
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+func webhookURLs(postgres *api.Postgres) []string {
+	var urls []string
+	for _, url := range strings.Split(postgres.Annotations[NotificationWebhooksAnnotation], ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// walPosition returns the timeline and current WAL position of the local primary.
+// The timeline is taken from the name of the current WAL segment, which switches immediately on promotion.
+func walPosition(ctx context.Context, timeout time.Duration) (int64, string, error) {
+	db, err := sql.Open("postgres", localConnectionString(timeout))
+	if err != nil {
+		return 0, "", err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var inRecovery bool
+	var version int
+	if err := db.QueryRowContext(ctx, "SELECT pg_is_in_recovery(), current_setting('server_version_num')::int").Scan(&inRecovery, &version); err != nil {
+		return 0, "", err
+	}
+	if inRecovery {
+		return 0, "", fmt.Errorf("postgres is still in recovery")
+	}
+
+	query := "SELECT pg_walfile_name(pg_current_wal_lsn()), pg_current_wal_lsn()::text"
+	if version < 100000 {
+		query = "SELECT pg_xlogfile_name(pg_current_xlog_location()), pg_current_xlog_location()::text"
+	}
+	var walFile, lsn string
+	if err := db.QueryRowContext(ctx, query).Scan(&walFile, &lsn); err != nil {
+		return 0, "", err
+	}
+	if len(walFile) < 8 {
+		return 0, "", fmt.Errorf("unexpected WAL file name %q", walFile)
+	}
+	timeline, err := strconv.ParseInt(walFile[:8], 16, 64)
+	if err != nil {
+		return 0, "", err
+	}
+	return timeline, lsn, nil
+}
@@ -0,0 +1,47 @@
+package leader_election
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	extFake "github.com/kubedb/apimachinery/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDemoted(t *testing.T) {
+	var received []Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var note Notification
+		if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+			t.Error(err)
+		}
+		received = append(received, note)
+	}))
+	defer server.Close()
+
+	postgres := &api.Postgres{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "foo",
+			Namespace:   "demo",
+			Annotations: map[string]string{NotificationWebhooksAnnotation: server.URL},
+		},
+	}
+	n := newNotifier(fake.NewSimpleClientset(), extFake.NewSimpleClientset(postgres), "demo", "foo")
+
+	n.demoted("", `pod "foo-1" took over leadership`)
+	if len(received) != 0 {
+		t.Fatalf("expected no notification without an old primary, got %v", received)
+	}
+
+	n.demoted("foo-0", `pod "foo-1" took over leadership`)
+	if len(received) != 1 {
+		t.Fatalf("expected one notification, got %v", len(received))
+	}
+	if note := received[0]; note.Reason != EventReasonDemoted || note.OldPrimary != "foo-0" || note.Postgres != "foo" || note.Namespace != "demo" {
+		t.Errorf("unexpected notification %+v", note)
+	}
+}
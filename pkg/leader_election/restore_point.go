@@ -0,0 +1,130 @@
+package leader_election
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	"github.com/kubedb/apimachinery/pkg/eventer"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Name of a restore point to create on the primary, e.g. before a risky migration.
+	// A new restore point is created every time the value changes.
+	RestorePointAnnotation = api.PostgresKey + "/restore-point"
+
+	// Last restore point in the status ConfigMap, written by the primary.
+	LastRestorePointKey     = "last-restore-point"
+	LastRestorePointLSNKey  = "last-restore-point-lsn"
+	LastRestorePointTimeKey = "last-restore-point-time"
+
+	EventReasonRestorePointCreated = "RestorePointCreated"
+	EventReasonRestorePointFailed  = "RestorePointFailed"
+
+	restorePointCheckPeriod = 10 * time.Second
+)
+
+// watchRestorePoints creates the requested restore point while this pod is the primary.
+// A restore point is created once per name, even if it can not be recorded in the status ConfigMap.
+// A failed request is not repeated until the requested name changes.
+func watchRestorePoints(ctx context.Context, checker *healthChecker, notifier *notifier) {
+	ticker := time.NewTicker(restorePointCheckPeriod)
+	defer ticker.Stop()
+
+	created, failed := "", ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !checker.isPrimary() {
+				continue
+			}
+			postgres, err := notifier.extClient.KubedbV1alpha1().Postgreses(notifier.namespace).Get(notifier.name, metav1.GetOptions{})
+			if err != nil {
+				log.Println("failed to get postgres to check for restore points. Reason:", err)
+				continue
+			}
+			name := postgres.Annotations[RestorePointAnnotation]
+			if name == "" || name == created || name == failed {
+				continue
+			}
+			// the restore point may have been created by a previous primary
+			status, err := GetStatus(notifier.kubeClient, notifier.namespace, notifier.name)
+			if err != nil {
+				log.Println("failed to get status to check for restore points. Reason:", err)
+				continue
+			}
+			if name == status[LastRestorePointKey] {
+				created = name
+				continue
+			}
+
+			lsn, err := createRestorePoint(ctx, name, restorePointCheckPeriod)
+			if err != nil {
+				failed = name
+			} else {
+				created = name
+			}
+			notifier.restorePoint(postgres, name, lsn, err)
+		}
+	}
+}
+
+// createRestorePoint creates a named restore point and switches to a new WAL segment,
+// so that the restore point is archived right away.
+func createRestorePoint(ctx context.Context, name string, timeout time.Duration) (string, error) {
+	db, err := sql.Open("postgres", localConnectionString(timeout))
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var version int
+	if err := db.QueryRowContext(ctx, "SELECT current_setting('server_version_num')::int").Scan(&version); err != nil {
+		return "", err
+	}
+	var lsn string
+	if err := db.QueryRowContext(ctx, "SELECT pg_create_restore_point($1)::text", name).Scan(&lsn); err != nil {
+		return "", err
+	}
+	switchWAL := "SELECT pg_switch_wal()"
+	if version < 100000 {
+		switchWAL = "SELECT pg_switch_xlog()"
+	}
+	if _, err := db.ExecContext(ctx, switchWAL); err != nil {
+		log.Println("failed to switch WAL after creating restore point. Reason:", err)
+	}
+	return lsn, nil
+}
+
+// restorePoint records a created restore point in the status ConfigMap.
+func (n *notifier) restorePoint(postgres *api.Postgres, name, lsn string, createErr error) {
+	if createErr != nil {
+		message := fmt.Sprintf(`Failed to create restore point "%s". Reason: %v`, name, createErr)
+		if _, err := eventer.CreateEvent(n.kubeClient, eventComponent, postgres, core.EventTypeWarning, EventReasonRestorePointFailed, message); err != nil {
+			log.Println("failed to create event. Reason:", err)
+		}
+		return
+	}
+
+	if err := UpdateStatus(n.kubeClient, n.namespace, n.name, map[string]string{
+		LastRestorePointKey:     name,
+		LastRestorePointLSNKey:  lsn,
+		LastRestorePointTimeKey: time.Now().UTC().Format(time.RFC3339),
+	}); err != nil {
+		log.Println("failed to record restore point. Reason:", err)
+	}
+	message := fmt.Sprintf(`Created restore point "%s" at LSN %s`, name, lsn)
+	if _, err := eventer.CreateEvent(n.kubeClient, eventComponent, postgres, core.EventTypeNormal, EventReasonRestorePointCreated, message); err != nil {
+		log.Println("failed to create event. Reason:", err)
+	}
+}
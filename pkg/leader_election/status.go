@@ -0,0 +1,66 @@
+package leader_election
+
+import (
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	core_util "kmodules.xyz/client-go/core/v1"
+)
+
+// GetStatusConfigMapName returns the ConfigMap that holds the backup and archive status of a Postgres.
+// The status is kept out of the Postgres object, as every change of its annotations triggers a reconcile.
+func GetStatusConfigMapName(offshootName string) string {
+	return fmt.Sprintf("%s-status", offshootName)
+}
+
+// GetStatus returns the entries of the status ConfigMap of a Postgres.
+func GetStatus(client kubernetes.Interface, namespace, offshootName string) (map[string]string, error) {
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(GetStatusConfigMapName(offshootName), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if cm.Data == nil {
+		return map[string]string{}, nil
+	}
+	return cm.Data, nil
+}
+
+// UpdateStatus sets entries of the status ConfigMap of a Postgres, which the operator creates
+// along with the database pods. Entries with an empty value are removed.
+func UpdateStatus(client kubernetes.Interface, namespace, offshootName string, status map[string]string) error {
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(GetStatusConfigMapName(offshootName), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if _, changed := mergeStatus(cm.Data, status); !changed {
+		return nil
+	}
+	_, _, err = core_util.PatchConfigMap(client, cm, func(in *core.ConfigMap) *core.ConfigMap {
+		in.Data, _ = mergeStatus(in.Data, status)
+		return in
+	})
+	return err
+}
+
+// mergeStatus returns a copy of data with the entries of status applied, and whether anything changed.
+func mergeStatus(data, status map[string]string) (map[string]string, bool) {
+	out := make(map[string]string, len(data)+len(status))
+	for k, v := range data {
+		out[k] = v
+	}
+	changed := false
+	for k, v := range status {
+		if v == "" {
+			if _, found := out[k]; found {
+				delete(out, k)
+				changed = true
+			}
+		} else if out[k] != v {
+			out[k] = v
+			changed = true
+		}
+	}
+	return out, changed
+}
@@ -0,0 +1,43 @@
+package leader_election
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeStatus(t *testing.T) {
+	data := map[string]string{
+		ScheduledBaseBackupKey:       "base_000000010000000000000002",
+		ScheduledBaseBackupStatusKey: BaseBackupSucceeded,
+	}
+
+	out, changed := mergeStatus(data, map[string]string{
+		ScheduledBaseBackupKey:       "base_000000010000000000000002",
+		ScheduledBaseBackupStatusKey: BaseBackupSucceeded,
+	})
+	if changed {
+		t.Error("unchanged status must not be reported as changed")
+	}
+	if !reflect.DeepEqual(out, data) {
+		t.Errorf("expected %v, got %v", data, out)
+	}
+
+	out, changed = mergeStatus(data, map[string]string{
+		ScheduledBaseBackupKey:       "",
+		ScheduledBaseBackupStatusKey: BaseBackupFailed,
+	})
+	if !changed {
+		t.Error("changed status must be reported as changed")
+	}
+	expected := map[string]string{ScheduledBaseBackupStatusKey: BaseBackupFailed}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %v, got %v", expected, out)
+	}
+	if data[ScheduledBaseBackupKey] == "" {
+		t.Error("input must not be modified")
+	}
+
+	if _, changed := mergeStatus(nil, map[string]string{ScheduledBaseBackupKey: ""}); changed {
+		t.Error("removing a missing entry must not be reported as changed")
+	}
+}
@@ -0,0 +1,167 @@
+package restore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/appscode/go/log"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	cs "github.com/kubedb/apimachinery/client/clientset/versioned"
+	"github.com/kubedb/postgres/pkg/archive"
+	"github.com/kubedb/postgres/pkg/controller"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	store "kmodules.xyz/objectstore-api/api/v1"
+)
+
+type ImportOptions struct {
+	// File with the backend the Snapshots were taken to, as in spec.backupSchedule
+	BackendFile string
+	// Namespace of the imported Snapshots and of the storage secret
+	Namespace string
+	// Only import Snapshots taken in this namespace or of this Postgres, if set
+	FromNamespace string
+	FromPostgres  string
+	// Postgres the imported Snapshots belong to, the original one if empty
+	Postgres string
+	// Only print the Snapshots that would be created
+	DryRun bool
+	// Whether the status subresource of KubeDB CRDs is enabled
+	EnableStatusSubresource bool
+}
+
+// Import creates a succeeded Snapshot for every Snapshot found in a backend, which does not exist yet.
+// Snapshots, which are imported into another namespace or for another Postgres, keep the folder they
+// were stored in, so that no data needs to be moved.
+func Import(kubeClient kubernetes.Interface, extClient cs.Interface, opt ImportOptions, out io.Writer) error {
+	backend, err := readBackend(opt.BackendFile)
+	if err != nil {
+		return err
+	}
+	namespace := opt.Namespace
+	if namespace == "" {
+		namespace = core.NamespaceDefault
+	}
+	folders, err := archive.ListSnapshots(kubeClient, *backend, namespace)
+	if err != nil {
+		return fmt.Errorf("failed to list Snapshots. Reason: %v", err)
+	}
+
+	var found []*archive.SnapshotFolder
+	for _, folder := range folders {
+		if (opt.FromNamespace == "" || folder.Namespace == opt.FromNamespace) &&
+			(opt.FromPostgres == "" || folder.Postgres == opt.FromPostgres) {
+			found = append(found, folder)
+		}
+	}
+	if len(found) == 0 {
+		return fmt.Errorf(`no Snapshots found in "%v"`, path.Join(archive.Prefix(*backend), api.DatabaseNamePrefix))
+	}
+	if opt.Postgres != "" && found[0].Location != found[len(found)-1].Location {
+		return errors.New("found Snapshots of more than one Postgres, select the one to link to a Postgres")
+	}
+
+	var snapshots []*api.Snapshot
+	for _, folder := range found {
+		snapshot, err := snapshotFor(kubeClient, *backend, folder, namespace, opt.Postgres)
+		if err != nil {
+			return fmt.Errorf(`failed to import Snapshot "%v" of "%v". Reason: %v`, folder.Name, folder.Location, err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	if opt.DryRun {
+		objs := make([]runtime.Object, 0, len(snapshots))
+		for _, snapshot := range snapshots {
+			objs = append(objs, snapshot)
+		}
+		return printObjects(out, objs...)
+	}
+	return importSnapshots(extClient, snapshots, opt.EnableStatusSubresource, out)
+}
+
+// importSnapshots creates the Snapshots, which do not exist yet. A Snapshot found in more than one
+// folder is only imported from the first one.
+func importSnapshots(extClient cs.Interface, snapshots []*api.Snapshot, enableStatusSubresource bool, out io.Writer) error {
+	for _, snapshot := range snapshots {
+		if _, err := extClient.KubedbV1alpha1().Snapshots(snapshot.Namespace).Get(snapshot.Name, metav1.GetOptions{}); err == nil {
+			log.Infof(`skipping Snapshot "%v/%v", which already exists`, snapshot.Namespace, snapshot.Name)
+			continue
+		} else if !kerr.IsNotFound(err) {
+			return err
+		}
+		if err := ImportSnapshot(extClient, snapshot, enableStatusSubresource); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Snapshot %q imported\n", snapshot.Name)
+	}
+	return nil
+}
+
+// snapshotFor returns the Snapshot of a folder for a Postgres in namespace. It is taken from the
+// backup metadata, if stored with the Snapshot, or else derived from the files of the folder.
+func snapshotFor(kubeClient kubernetes.Interface, backend store.Backend, folder *archive.SnapshotFolder, namespace, postgres string) (*api.Snapshot, error) {
+	location := path.Join(folder.Location, folder.Name)
+	in := &api.Snapshot{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: api.SchemeGroupVersion.String(),
+			Kind:       api.ResourceKindSnapshot,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        folder.Name,
+			Annotations: map[string]string{},
+		},
+		Spec: api.SnapshotSpec{
+			DatabaseName: folder.Postgres,
+		},
+		Status: api.SnapshotStatus{
+			StartTime:      &metav1.Time{Time: folder.StartTime},
+			CompletionTime: &metav1.Time{Time: folder.CompletionTime},
+		},
+	}
+	if folder.Metadata {
+		m, err := archive.ReadMetadata(kubeClient, backend, namespace, location)
+		if err != nil {
+			return nil, err
+		}
+		if m.Snapshot != nil {
+			in = m.Snapshot
+		}
+	}
+
+	snapshot := newImportedSnapshot(in, backend, strings.Trim(archive.Prefix(backend), "/"))
+	snapshot.Namespace = namespace
+	if postgres != "" {
+		snapshot.Spec.DatabaseName = postgres
+	}
+	snapshot.Labels[api.LabelDatabaseKind] = api.ResourceKindPostgres
+	snapshot.Labels[api.LabelDatabaseName] = snapshot.Spec.DatabaseName
+	// the type and format may have been the default of the original Postgres
+	switch {
+	case folder.Physical:
+		snapshot.Annotations[controller.SnapshotTypeAnnotation] = controller.SnapshotTypePhysical
+	case folder.Directory:
+		snapshot.Annotations[controller.SnapshotFormatAnnotation] = controller.SnapshotFormatDirectory
+	}
+	delete(snapshot.Annotations, controller.SnapshotLocationAnnotation)
+	if own, err := snapshot.Location(); err != nil {
+		return nil, err
+	} else if own != folder.Location {
+		snapshot.Annotations[controller.SnapshotLocationAnnotation] = folder.Location
+	}
+
+	_, found := snapshot.Annotations[controller.SnapshotDatabasesAnnotation]
+	if snapshot.Annotations[controller.SnapshotFormatAnnotation] == controller.SnapshotFormatDirectory && !found {
+		databases, err := archive.SnapshotDatabases(kubeClient, backend, namespace, location)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Annotations[controller.SnapshotDatabasesAnnotation] = strings.Join(databases, ",")
+	}
+	return snapshot, nil
+}
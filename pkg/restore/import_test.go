@@ -0,0 +1,156 @@
+package restore
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	extFake "github.com/kubedb/apimachinery/client/clientset/versioned/fake"
+	"github.com/kubedb/postgres/pkg/archive"
+	"github.com/kubedb/postgres/pkg/controller"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	store "kmodules.xyz/objectstore-api/api/v1"
+)
+
+var s3Backend = store.Backend{
+	StorageSecretName: "s3-secret",
+	S3:                &store.S3Spec{Bucket: "kubedb", Prefix: "/backups/"},
+}
+
+func snapshotFolder(namespace, postgres, name string) *archive.SnapshotFolder {
+	return &archive.SnapshotFolder{
+		Namespace:      namespace,
+		Postgres:       postgres,
+		Name:           name,
+		Location:       "backups/kubedb/" + namespace + "/" + postgres,
+		StartTime:      time.Date(2019, 5, 6, 10, 0, 0, 0, time.UTC),
+		CompletionTime: time.Date(2019, 5, 6, 10, 5, 0, 0, time.UTC),
+	}
+}
+
+func TestSnapshotFor(t *testing.T) {
+	physical := snapshotFolder("demo", "foo", "snap")
+	physical.Physical = true
+
+	for _, c := range []struct {
+		name      string
+		folder    *archive.SnapshotFolder
+		namespace string
+		postgres  string
+		database  string
+		location  string
+		physical  bool
+	}{
+		{"own folder", snapshotFolder("demo", "foo", "snap"), "demo", "", "foo", "", false},
+		{"other namespace", snapshotFolder("demo", "foo", "snap"), "prod", "", "foo", "backups/kubedb/demo/foo", false},
+		{"other postgres", snapshotFolder("demo", "foo", "snap"), "demo", "bar", "bar", "backups/kubedb/demo/foo", false},
+		{"physical", physical, "demo", "", "foo", "", true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			snapshot, err := snapshotFor(fake.NewSimpleClientset(), s3Backend, c.folder, c.namespace, c.postgres)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if snapshot.Name != c.folder.Name || snapshot.Namespace != c.namespace {
+				t.Errorf(`expected Snapshot "%v/%v", got "%v/%v"`, c.namespace, c.folder.Name, snapshot.Namespace, snapshot.Name)
+			}
+			if snapshot.Spec.DatabaseName != c.database || snapshot.Labels[api.LabelDatabaseName] != c.database {
+				t.Errorf("expected database %v, got %v", c.database, snapshot.Spec.DatabaseName)
+			}
+			if snapshot.Spec.S3 == nil || snapshot.Spec.S3.Prefix != "backups" {
+				t.Errorf("expected the prefix of the backend, got %+v", snapshot.Spec.S3)
+			}
+			if location := snapshot.Annotations[controller.SnapshotLocationAnnotation]; location != c.location {
+				t.Errorf("expected location %q, got %q", c.location, location)
+			}
+			if physical := snapshot.Annotations[controller.SnapshotTypeAnnotation] == controller.SnapshotTypePhysical; physical != c.physical {
+				t.Errorf("expected physical=%v", c.physical)
+			}
+			if snapshot.Annotations[controller.SnapshotImportedAnnotation] != "true" {
+				t.Error("expected the Snapshot to be marked imported")
+			}
+			if !snapshot.Status.CompletionTime.Time.Equal(c.folder.CompletionTime) {
+				t.Errorf("expected completion time %v, got %v", c.folder.CompletionTime, snapshot.Status.CompletionTime)
+			}
+		})
+	}
+}
+
+func TestImportedSnapshot(t *testing.T) {
+	in := &api.Snapshot{
+		ObjectMeta: metav1.ObjectMeta{Name: "snap", Namespace: "demo"},
+		Spec:       api.SnapshotSpec{DatabaseName: "foo"},
+	}
+	for _, c := range []struct {
+		name   string
+		prefix string
+		want   string
+		valid  bool
+	}{
+		{"with prefix", "backups/kubedb/demo/foo/snap", "backups", true},
+		{"without prefix", "/kubedb/demo/foo/snap/", "", true},
+		{"other snapshot", "backups/kubedb/demo/foo/other", "", false},
+		{"partial folder", "backups/xkubedb/demo/foo/snap", "", false},
+	} {
+		backend := *s3Backend.DeepCopy()
+		backend.S3.Prefix = c.prefix
+		snapshot, err := importedSnapshot(in, backend)
+		if (err == nil) != c.valid {
+			t.Errorf("%s: expected valid=%v, got error: %v", c.name, c.valid, err)
+			continue
+		}
+		if err == nil && snapshot.Spec.S3.Prefix != c.want {
+			t.Errorf("%s: expected prefix %q, got %q", c.name, c.want, snapshot.Spec.S3.Prefix)
+		}
+	}
+}
+
+func TestImportSnapshots(t *testing.T) {
+	existing := &api.Snapshot{
+		ObjectMeta: metav1.ObjectMeta{Name: "snap-1", Namespace: "demo"},
+		Spec:       api.SnapshotSpec{DatabaseName: "foo"},
+	}
+	extClient := extFake.NewSimpleClientset(existing)
+
+	var snapshots []*api.Snapshot
+	for _, folder := range []*archive.SnapshotFolder{
+		snapshotFolder("demo", "foo", "snap-1"),
+		snapshotFolder("demo", "foo", "snap-2"),
+		// the same Snapshot name in the folder of another Postgres
+		snapshotFolder("demo", "bar", "snap-2"),
+	} {
+		snapshot, err := snapshotFor(fake.NewSimpleClientset(), s3Backend, folder, "demo", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	out := &bytes.Buffer{}
+	if err := importSnapshots(extClient, snapshots, true, out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Snapshot \"snap-2\" imported\n"; out.String() != want {
+		t.Errorf("expected output %q, got %q", want, out.String())
+	}
+
+	snapshot, err := extClient.KubedbV1alpha1().Snapshots("demo").Get("snap-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, imported := snapshot.Annotations[controller.SnapshotImportedAnnotation]; imported {
+		t.Error("expected the existing Snapshot to be kept")
+	}
+	snapshot, err = extClient.KubedbV1alpha1().Snapshots("demo").Get("snap-2", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Spec.DatabaseName != "foo" {
+		t.Errorf("expected Snapshot snap-2 of the first folder, got one of %v", snapshot.Spec.DatabaseName)
+	}
+	if snapshot.Status.Phase != api.SnapshotPhaseSucceeded {
+		t.Errorf("expected phase %v, got %v", api.SnapshotPhaseSucceeded, snapshot.Status.Phase)
+	}
+}
@@ -0,0 +1,285 @@
+// Package restore rebuilds a Postgres from the metadata stored with its backups, in a cluster
+// that may have lost every object of the original Postgres.
+package restore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/appscode/go/log"
+	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	cs "github.com/kubedb/apimachinery/client/clientset/versioned"
+	"github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	"github.com/kubedb/postgres/pkg/archive"
+	"github.com/kubedb/postgres/pkg/controller"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+	store "kmodules.xyz/objectstore-api/api/v1"
+)
+
+type Options struct {
+	// File with the backend of a wal archive or of a Snapshot folder, as in spec.backupSchedule.
+	// The prefix points to the folder that holds the metadata.
+	BackendFile string
+	// Namespace and name of the rebuilt Postgres, those of the original if empty
+	Namespace string
+	Name      string
+	// File with the key the auth Secret was encrypted with
+	MetadataKeyFile string
+	// Point in time to recover a wal archive to
+	TargetTime string
+	// Only print the objects that would be created
+	DryRun bool
+	// Whether the status subresource of KubeDB CRDs is enabled
+	EnableStatusSubresource bool
+}
+
+// Restore creates the PostgresVersion, the auth Secret and the Postgres described by the metadata of a backup.
+// The Postgres is initialized from the wal archive or from the Snapshot the metadata was read from.
+func Restore(kubeClient kubernetes.Interface, extClient cs.Interface, opt Options, out io.Writer) error {
+	backend, err := readBackend(opt.BackendFile)
+	if err != nil {
+		return err
+	}
+	namespace := opt.Namespace
+	if namespace == "" {
+		namespace = core.NamespaceDefault
+	}
+	// the storage secret of the backend is read from the namespace of the rebuilt Postgres
+	m, err := archive.ReadMetadata(kubeClient, *backend, namespace, archive.Prefix(*backend))
+	if err != nil {
+		return fmt.Errorf("failed to read backup metadata. Reason: %v", err)
+	}
+
+	postgres := m.Postgres.DeepCopy()
+	if opt.Name != "" {
+		postgres.Name = opt.Name
+	}
+	postgres.Namespace = namespace
+	postgres.Spec.Init = &api.InitSpec{}
+
+	var snapshot *api.Snapshot
+	if m.Snapshot == nil {
+		wal := &api.PostgresWALSourceSpec{Backend: *backend}
+		if m.BaseBackup != "" {
+			log.Infof("wal archive holds base backup %v or later", m.BaseBackup)
+		}
+		if opt.TargetTime != "" {
+			wal.PITR = &api.RecoveryTarget{TargetTime: opt.TargetTime}
+		}
+		postgres.Spec.Init.PostgresWAL = wal
+	} else {
+		if opt.TargetTime != "" {
+			return errors.New("a point in time can only be recovered from a wal archive")
+		}
+		if snapshot, err = importedSnapshot(m.Snapshot, *backend); err != nil {
+			return err
+		}
+		postgres.Spec.Init.SnapshotSource = &api.SnapshotSourceSpec{
+			Namespace: snapshot.Namespace,
+			Name:      snapshot.Name,
+		}
+	}
+
+	var secrets []*core.Secret
+	if m.Secret != nil {
+		if secrets, err = authSecrets(m.Secret, postgres, opt.MetadataKeyFile); err != nil {
+			return err
+		}
+	}
+
+	if opt.DryRun {
+		objs := []runtime.Object{m.PostgresVersion}
+		for _, secret := range secrets {
+			objs = append(objs, secret)
+		}
+		if snapshot != nil {
+			objs = append(objs, snapshot)
+		}
+		return printObjects(out, append(objs, postgres)...)
+	}
+
+	if _, err := extClient.CatalogV1alpha1().PostgresVersions().Create(m.PostgresVersion); err == nil {
+		fmt.Fprintf(out, "PostgresVersion %q created\n", m.PostgresVersion.Name)
+	} else if !kerr.IsAlreadyExists(err) {
+		return err
+	}
+	for _, secret := range secrets {
+		if _, err := kubeClient.CoreV1().Secrets(secret.Namespace).Create(secret); err == nil {
+			fmt.Fprintf(out, "Secret %q created\n", secret.Name)
+		} else if !kerr.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	if len(secrets) == 0 && m.Secret != nil {
+		if _, err := kubeClient.CoreV1().Secrets(namespace).Get(m.Secret.Name, metav1.GetOptions{}); err != nil {
+			return fmt.Errorf(`auth secret "%v" with keys %v must be created first, as its data was not stored. Reason: %v`,
+				m.Secret.Name, strings.Join(m.Secret.Keys, ","), err)
+		}
+	}
+	if snapshot != nil {
+		if err := ImportSnapshot(extClient, snapshot, opt.EnableStatusSubresource); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Snapshot %q imported\n", snapshot.Name)
+	}
+	if _, err := extClient.KubedbV1alpha1().Postgreses(postgres.Namespace).Create(postgres); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Postgres %q created\n", postgres.Name)
+	return nil
+}
+
+func readBackend(file string) (*store.Backend, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	backend := &store.Backend{}
+	if err := yaml.NewYAMLOrJSONDecoder(f, 4096).Decode(backend); err != nil {
+		return nil, err
+	}
+	if backend.Local != nil {
+		return nil, errors.New("local backends are not supported")
+	}
+	return backend, nil
+}
+
+// importedSnapshot returns the Snapshot described by metadata, for a backend pointing to its folder.
+// Snapshots are stored in "<prefix>/kubedb/<namespace>/<postgres>/<snapshot>", so the prefix of
+// the imported Snapshot is the one of the backend without the last four elements.
+func importedSnapshot(in *api.Snapshot, backend store.Backend) (*api.Snapshot, error) {
+	folder := strings.Trim(archive.Prefix(backend), "/")
+	suffix := path.Join(api.DatabaseNamePrefix, in.Namespace, in.Spec.DatabaseName, in.Name)
+	if folder != suffix && !strings.HasSuffix(folder, "/"+suffix) {
+		return nil, fmt.Errorf(`backend prefix "%v" does not end with the folder "%v" of Snapshot "%v"`, folder, suffix, in.Name)
+	}
+	prefix := strings.TrimSuffix(strings.TrimSuffix(folder, suffix), "/")
+	return newImportedSnapshot(in, backend, prefix), nil
+}
+
+// newImportedSnapshot copies a Snapshot stored in the backend with the given prefix.
+func newImportedSnapshot(in *api.Snapshot, backend store.Backend, prefix string) *api.Snapshot {
+	snapshot := &api.Snapshot{
+		TypeMeta: in.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        in.Name,
+			Namespace:   in.Namespace,
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+		},
+		Spec:   *in.Spec.DeepCopy(),
+		Status: in.Status,
+	}
+	for k, v := range in.Labels {
+		snapshot.Labels[k] = v
+	}
+	for k, v := range in.Annotations {
+		snapshot.Annotations[k] = v
+	}
+	snapshot.Annotations[controller.SnapshotImportedAnnotation] = "true"
+	delete(snapshot.Labels, api.LabelSnapshotStatus)
+
+	snapshot.Spec.Backend = backend
+	switch {
+	case backend.S3 != nil:
+		snapshot.Spec.S3 = backend.S3.DeepCopy()
+		snapshot.Spec.S3.Prefix = prefix
+	case backend.GCS != nil:
+		snapshot.Spec.GCS = backend.GCS.DeepCopy()
+		snapshot.Spec.GCS.Prefix = prefix
+	case backend.Azure != nil:
+		snapshot.Spec.Azure = backend.Azure.DeepCopy()
+		snapshot.Spec.Azure.Prefix = prefix
+	case backend.Swift != nil:
+		snapshot.Spec.Swift = backend.Swift.DeepCopy()
+		snapshot.Spec.Swift.Prefix = prefix
+	}
+	return snapshot
+}
+
+// ImportSnapshot creates a Snapshot for data already in the backend and completes it right away,
+// so that the operator takes no backup for it.
+func ImportSnapshot(extClient cs.Interface, snapshot *api.Snapshot, enableStatusSubresource bool) error {
+	status := snapshot.Status
+	created, err := extClient.KubedbV1alpha1().Snapshots(snapshot.Namespace).Create(snapshot)
+	if kerr.IsAlreadyExists(err) {
+		return fmt.Errorf(`snapshot "%v/%v" already exists`, snapshot.Namespace, snapshot.Name)
+	} else if err != nil {
+		return err
+	}
+	_, err = util.UpdateSnapshotStatus(extClient.KubedbV1alpha1(), created, func(in *api.SnapshotStatus) *api.SnapshotStatus {
+		in.Phase = api.SnapshotPhaseSucceeded
+		in.StartTime = status.StartTime
+		in.CompletionTime = status.CompletionTime
+		in.Reason = "imported from backend"
+		return in
+	}, enableStatusSubresource)
+	return err
+}
+
+// authSecrets returns the auth Secret stored with a backup and the Secret with the key it was encrypted with.
+// It returns none, if the data of the auth Secret was not stored or no key was given.
+func authSecrets(m *archive.SecretMetadata, postgres *api.Postgres, keyFile string) ([]*core.Secret, error) {
+	if len(m.EncryptedData) == 0 || keyFile == "" {
+		return nil, nil
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.Data(key)
+	if err != nil {
+		return nil, err
+	}
+	secrets := []*core.Secret{newSecret(m.Name, postgres, data)}
+	// the rebuilt Postgres keeps storing the auth Secret encrypted with the same key
+	if name := postgres.Annotations[validator.BackupMetadataKeyAnnotation]; name != "" {
+		secrets = append(secrets, newSecret(name, postgres, map[string][]byte{
+			validator.BackupMetadataKey: key,
+		}))
+	}
+	return secrets, nil
+}
+
+func newSecret(name string, postgres *api.Postgres, data map[string][]byte) *core.Secret {
+	return &core.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: postgres.Namespace,
+			Labels: map[string]string{
+				api.LabelDatabaseKind: api.ResourceKindPostgres,
+				api.LabelDatabaseName: postgres.Name,
+			},
+		},
+		Type: core.SecretTypeOpaque,
+		Data: data,
+	}
+}
+
+func printObjects(out io.Writer, objs ...runtime.Object) error {
+	for _, obj := range objs {
+		data, err := json.MarshalIndent(obj, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s\n", data)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Package volumesnapshot takes CSI VolumeSnapshots of the data volume of a database pod.
+package volumesnapshot
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/appscode/go/log"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	Group   = "snapshot.storage.k8s.io"
+	Version = "v1alpha1"
+	Kind    = "VolumeSnapshot"
+)
+
+var Resource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "volumesnapshots"}
+
+type Options struct {
+	Namespace string
+	// Name of the VolumeSnapshot
+	Name string
+	// PersistentVolumeClaim with the data directory
+	Claim string
+	// VolumeSnapshotClass, the default class if empty
+	Class  string
+	Labels map[string]string
+	// Time to wait for the VolumeSnapshot to be ready to use
+	Timeout time.Duration
+}
+
+// Take creates a VolumeSnapshot of a claim and waits until it is ready to use. The VolumeSnapshot is
+// crash-consistent, as the data volume is cut at a single point in time.
+func Take(client dynamic.Interface, opt Options) error {
+	vs := &unstructured.Unstructured{}
+	vs.SetAPIVersion(schema.GroupVersion{Group: Group, Version: Version}.String())
+	vs.SetKind(Kind)
+	vs.SetNamespace(opt.Namespace)
+	vs.SetName(opt.Name)
+	vs.SetLabels(opt.Labels)
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"kind": "PersistentVolumeClaim",
+			"name": opt.Claim,
+		},
+	}
+	if opt.Class != "" {
+		spec["snapshotClassName"] = opt.Class
+	}
+	if err := unstructured.SetNestedMap(vs.Object, spec, "spec"); err != nil {
+		return err
+	}
+	if _, err := client.Resource(Resource).Namespace(opt.Namespace).Create(vs, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	log.Infof(`created VolumeSnapshot "%s/%s" of claim "%s"`, opt.Namespace, opt.Name, opt.Claim)
+
+	err := wait.PollImmediate(5*time.Second, opt.Timeout, func() (bool, error) {
+		vs, err := client.Resource(Resource).Namespace(opt.Namespace).Get(opt.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		return Ready(vs)
+	})
+	if err != nil {
+		return fmt.Errorf(`VolumeSnapshot "%s/%s" is not ready. Reason: %v`, opt.Namespace, opt.Name, err)
+	}
+	return nil
+}
+
+// Ready returns whether a VolumeSnapshot is ready to use, or the error it failed with.
+func Ready(vs *unstructured.Unstructured) (bool, error) {
+	if msg, found, _ := unstructured.NestedString(vs.Object, "status", "error", "message"); found && msg != "" {
+		return false, errors.New(msg)
+	}
+	ready, _, _ := unstructured.NestedBool(vs.Object, "status", "readyToUse")
+	return ready, nil
+}
+
+// DataSource returns the reference to a VolumeSnapshot, to provision a claim with its data.
+func DataSource(name string) *core.TypedLocalObjectReference {
+	group := Group
+	return &core.TypedLocalObjectReference{
+		APIGroup: &group,
+		Kind:     Kind,
+		Name:     name,
+	}
+}
@@ -0,0 +1,38 @@
+package volumesnapshot
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestReady(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		status map[string]interface{}
+		ready  bool
+		failed bool
+	}{
+		{"no status", nil, false, false},
+		{"not ready", map[string]interface{}{"readyToUse": false}, false, false},
+		{"ready", map[string]interface{}{"readyToUse": true}, true, false},
+		{"failed", map[string]interface{}{"error": map[string]interface{}{"message": "snapshot failed"}}, false, true},
+		{"empty error", map[string]interface{}{"readyToUse": true, "error": map[string]interface{}{"message": ""}}, true, false},
+	} {
+		vs := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		if c.status != nil {
+			vs.Object["status"] = c.status
+		}
+		ready, err := Ready(vs)
+		if ready != c.ready || (err != nil) != c.failed {
+			t.Errorf("%s: expected ready=%v failed=%v, got ready=%v error: %v", c.name, c.ready, c.failed, ready, err)
+		}
+	}
+}
+
+func TestDataSource(t *testing.T) {
+	ref := DataSource("foo-snapshot")
+	if ref.APIGroup == nil || *ref.APIGroup != Group || ref.Kind != Kind || ref.Name != "foo-snapshot" {
+		t.Errorf("unexpected data source %+v", ref)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/appscode/go/crypto/rand"
 	"github.com/appscode/go/log"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
+	validator "github.com/kubedb/postgres/pkg/admission"
 	"github.com/kubedb/postgres/pkg/controller"
 	. "github.com/onsi/gomega"
 	core "k8s.io/api/core/v1"
@@ -27,6 +28,9 @@ var (
 	CustomPassword     = "password0987654321"
 )
 
+// Path of an armored OpenPGP key backups are encrypted with
+const BACKUP_ENCRYPTION_KEY = "BACKUP_ENCRYPTION_KEY"
+
 func (i *Invocation) SecretForLocalBackend() *core.Secret {
 	return &core.Secret{
 		ObjectMeta: metav1.ObjectMeta{
@@ -55,6 +59,26 @@ func (i *Invocation) SecretForS3Backend() *core.Secret {
 	}
 }
 
+// SecretForBackupEncryption returns a Secret with the OpenPGP key in the file BACKUP_ENCRYPTION_KEY points to.
+func (i *Invocation) SecretForBackupEncryption() *core.Secret {
+	keyFile := os.Getenv(BACKUP_ENCRYPTION_KEY)
+	if keyFile == "" {
+		return &core.Secret{}
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	Expect(err).NotTo(HaveOccurred())
+
+	return &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      rand.WithUniqSuffix(i.app + "-encryption"),
+			Namespace: i.namespace,
+		},
+		Data: map[string][]byte{
+			validator.DefaultBackupEncryptionKey: key,
+		},
+	}
+}
+
 func (i *Invocation) SecretForMinioBackend() *core.Secret {
 	if os.Getenv(store.AWS_ACCESS_KEY_ID) == "" ||
 		os.Getenv(store.AWS_SECRET_ACCESS_KEY) == "" {
@@ -2,6 +2,8 @@ package framework
 
 import (
 	"fmt"
+	"io"
+	"path"
 	"time"
 
 	"github.com/graymeta/stow"
@@ -36,21 +38,25 @@ func (f *Framework) EventuallyWalDataFound(postgres *api.Postgres) GomegaAsyncAs
 
 }
 
-func (f *Framework) checkWalData(postgres *api.Postgres) (bool, error) {
+func (f *Framework) walContainer(postgres *api.Postgres) (stow.Container, error) {
 	cfg, err := storage.NewOSMContext(f.kubeClient, *postgres.Spec.Archiver.Storage, postgres.Namespace)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	loc, err := stow.Dial(cfg.Provider, cfg.Config)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	containerID, err := postgres.Spec.Archiver.Storage.Container()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	container, err := loc.Container(containerID)
+	return loc.Container(containerID)
+}
+
+func (f *Framework) checkWalData(postgres *api.Postgres) (bool, error) {
+	container, err := f.walContainer(postgres)
 	if err != nil {
 		return false, err
 	}
@@ -75,7 +81,81 @@ func (f *Framework) checkWalData(postgres *api.Postgres) (bool, error) {
 	return totalItem != 0, nil
 }
 
+// EventuallyWalDataEncrypted checks that the wal archive of a Postgres has segments and that every one of them
+// is an OpenPGP message. Segments pushed without a key are lz4 frames.
+func (f *Framework) EventuallyWalDataEncrypted(postgres *api.Postgres) GomegaAsyncAssertion {
+	check := f.checkWalDataEncrypted
+	if f.IsMinio() {
+		check = func(postgres *api.Postgres) (bool, error) {
+			return f.checkMinio(postgres, f.checkWalDataEncrypted)
+		}
+	}
+	return Eventually(
+		func() bool {
+			encrypted, err := check(postgres)
+			Expect(err).NotTo(HaveOccurred())
+			return encrypted
+		},
+		time.Minute*5,
+		time.Second*5,
+	)
+}
+
+func (f *Framework) checkWalDataEncrypted(postgres *api.Postgres) (bool, error) {
+	container, err := f.walContainer(postgres)
+	if err != nil {
+		return false, err
+	}
+
+	prefix := path.Join(controller.WalDataDir(postgres), "wal_005") + "/"
+	cursor := stow.CursorStart
+	totalItem := 0
+	for {
+		items, next, err := container.Items(prefix, cursor, 50)
+		if err != nil {
+			return false, err
+		}
+		for _, item := range items {
+			encrypted, err := isOpenPGPMessage(item)
+			if err != nil {
+				return false, err
+			}
+			if !encrypted {
+				return false, fmt.Errorf("wal segment %v is not encrypted", item.Name())
+			}
+		}
+
+		totalItem = totalItem + len(items)
+
+		cursor = next
+		if stow.IsCursorEnd(cursor) {
+			break
+		}
+	}
+
+	return totalItem != 0, nil
+}
+
+// isOpenPGPMessage checks the first byte of an item, which is a packet tag with its highest bit set
+// for OpenPGP messages.
+func isOpenPGPMessage(item stow.Item) (bool, error) {
+	r, err := item.Open()
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	tag := make([]byte, 1)
+	if _, err := io.ReadFull(r, tag); err != nil {
+		return false, err
+	}
+	return tag[0]&0x80 != 0, nil
+}
+
 func (f *Framework) checkMinioWalData(postgres *api.Postgres) (bool, error) {
+	return f.checkMinio(postgres, f.checkWalData)
+}
+
+func (f *Framework) checkMinio(postgres *api.Postgres, check func(postgres *api.Postgres) (bool, error)) (bool, error) {
 	tunnel, err := f.GetMinioPortForwardingEndPoint()
 	//if tunnel.Local != 0{
 	//	endPoint := fmt.Sprintf("https://%s:%d", localIP, tunnel.Local)
@@ -95,7 +175,7 @@ func (f *Framework) checkMinioWalData(postgres *api.Postgres) (bool, error) {
 			postgres.Spec.Archiver.Storage.S3.Endpoint = endPoint
 		}
 	}
-	walBool, err := f.checkWalData(postgres)
+	walBool, err := check(postgres)
 	defer tunnel.Close()
 	if err != nil {
 		return false, err
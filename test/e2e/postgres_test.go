@@ -9,6 +9,8 @@ import (
 	"github.com/appscode/go/types"
 	api "github.com/kubedb/apimachinery/apis/kubedb/v1alpha1"
 	"github.com/kubedb/apimachinery/client/clientset/versioned/typed/kubedb/v1alpha1/util"
+	validator "github.com/kubedb/postgres/pkg/admission"
+	"github.com/kubedb/postgres/pkg/controller"
 	"github.com/kubedb/postgres/test/e2e/framework"
 	"github.com/kubedb/postgres/test/e2e/matcher"
 	. "github.com/onsi/ginkgo"
@@ -1737,7 +1739,7 @@ var _ = Describe("Postgres", func() {
 								Backend: store.Backend{
 									Local: &store.LocalSpec{
 										MountPath: "/repo",
-										SubPath:   fmt.Sprintf("%s-0", postgres.Name),
+										SubPath:   controller.WalDataDir(postgres),
 										VolumeSource: core.VolumeSource{
 											PersistentVolumeClaim: &core.PersistentVolumeClaimVolumeSource{
 												ClaimName: archivePVC.Name,
@@ -1754,7 +1756,7 @@ var _ = Describe("Postgres", func() {
 								Backend: store.Backend{
 									Local: &store.LocalSpec{
 										MountPath: "cold/sub0",
-										SubPath:   fmt.Sprintf("%s-0", postgres2nd.Name),
+										SubPath:   controller.WalDataDir(postgres2nd),
 										VolumeSource: core.VolumeSource{
 											PersistentVolumeClaim: &core.PersistentVolumeClaimVolumeSource{
 												ClaimName: archivePVC.Name,
@@ -1965,6 +1967,47 @@ var _ = Describe("Postgres", func() {
 
 					It("should remove wal data from backend", shouldWipeOutWalData)
 				})
+
+				Context("With backup encryption", func() {
+					var encryptionSecret *core.Secret
+
+					BeforeEach(func() {
+						encryptionSecret = f.SecretForBackupEncryption()
+						if encryptionSecret.Name == "" {
+							Skip("Skipping backup encryption test. reason: BACKUP_ENCRYPTION_KEY is not set.")
+						}
+						postgres.Spec.Replicas = types.Int32P(3)
+						postgres.Annotations = core_util.UpsertMap(postgres.Annotations, map[string]string{
+							validator.BackupEncryptionSecretAnnotation: encryptionSecret.Name,
+						})
+					})
+
+					AfterEach(func() {
+						if encryptionSecret.Name != "" {
+							err := f.DeleteSecret(encryptionSecret.ObjectMeta)
+							Expect(err).NotTo(HaveOccurred())
+						}
+					})
+
+					It("should encrypt the wal segments pushed by every pod", func() {
+						err := f.CreateSecret(secret)
+						Expect(err).NotTo(HaveOccurred())
+						err = f.CreateSecret(encryptionSecret)
+						Expect(err).NotTo(HaveOccurred())
+
+						// Create Postgres
+						createAndWaitForRunning()
+
+						By("Creating Table")
+						f.EventuallyCreateTable(postgres.ObjectMeta, dbName, dbUser, 3).Should(BeTrue())
+
+						By("Checking Archive")
+						f.EventuallyCountArchive(postgres.ObjectMeta, dbName, dbUser).Should(BeTrue())
+
+						By("Checking wal segments in backend are encrypted")
+						f.EventuallyWalDataEncrypted(postgres).Should(BeTrue())
+					})
+				})
 			})
 
 			Context("In GCS", func() {